@@ -1,9 +1,13 @@
 package combo
 
 import (
+	"desktop-audio-ctrl/label"
+	"desktop-audio-ctrl/meter"
 	"desktop-audio-ctrl/protocol"
+	"desktop-audio-ctrl/reconcile"
 	"desktop-audio-ctrl/rotary"
 	screenlib "desktop-audio-ctrl/screen"
+	"desktop-audio-ctrl/store"
 	"fmt"
 	"image/color"
 	"machine"
@@ -16,28 +20,45 @@ import (
 )
 
 var (
-	drawColor = color.RGBA{255, 255, 255, 255}
+	drawColor  = color.RGBA{255, 255, 255, 255}
+	clearColor = color.RGBA{0, 0, 0, 255}
 )
 
 type Combo struct {
-	screen    *screenlib.Screen
-	encoder   *rotary.Encoder
-	state     uint8
-	name      string
-	id        uint8
-	lastCount int32
-	lastTime  time.Time
-	exactStep float64
+	screen      *screenlib.Screen
+	encoder     *rotary.Encoder
+	state       uint8
+	name        label.Marquee
+	defaultName string
+	id          uint8
+	lastCount   int32
+	lastTime    time.Time
+	exactStep   float64
+	sync        reconcile.Knob
+	style       uint8
+	muted       bool
+	link        uint8
+	meter       meter.Needle
 }
 
+// Host link states, see SetLink.
+const (
+	// LinkWaiting is the state from boot until the host first speaks.
+	LinkWaiting uint8 = iota
+	LinkUp
+	// LinkLost is the state once the host went quiet.
+	LinkLost
+)
+
 func NewCombo(i2c *machine.I2C, screenChannel uint8, encoderAddress uint16, name string, id uint8) *Combo {
 	c := Combo{
-		screen:  screenlib.NewScreen(screenChannel),
-		encoder: rotary.NewEncoder(i2c, encoderAddress),
-		name:    name,
-		state:   uint8(rand.IntN(101)),
-		id:      id,
+		screen:      screenlib.NewScreen(screenChannel),
+		encoder:     rotary.NewEncoder(i2c, encoderAddress),
+		defaultName: name,
+		state:       uint8(rand.IntN(101)),
+		id:          id,
 	}
+	c.name.Set(name, time.Now())
 	return &c
 }
 
@@ -49,6 +70,104 @@ func (c *Combo) SetState(state uint8) bool {
 	return true
 }
 
+// ApplySet applies a SET from the host unless it was computed before the
+// latest turn of the knob. It reports whether the state changed.
+func (c *Combo) ApplySet(e protocol.Event) (applied, changed bool) {
+	version, versioned := e.Version()
+	if !c.sync.Accept(version, versioned, time.Now()) {
+		return false, false
+	}
+	return true, c.SetState(e.State)
+}
+
+// local returns an event for a change of the knob, stamped with a new
+// version.
+func (c *Combo) local(t protocol.EventType) *protocol.Event {
+	event := protocol.NewEvent(t, c.id, c.state)
+	event.Data = []byte{c.sync.Local(time.Now())}
+	return event
+}
+
+// SetStyle changes how the level is drawn, see protocol.STYLE_BAR.
+func (c *Combo) SetStyle(style uint8) bool {
+	if style == c.style {
+		return false
+	}
+	c.style = style
+	return true
+}
+
+// SetMuted changes whether the combo's endpoint is shown as muted.
+func (c *Combo) SetMuted(muted bool) bool {
+	if muted == c.muted {
+		return false
+	}
+	c.muted = muted
+	return true
+}
+
+// SetName changes the name shown above the bar. An empty name restores the
+// one the combo was created with. Names are folded onto the font, and
+// those too long for the screen scroll, see Scroll.
+func (c *Combo) SetName(name string) bool {
+	if name == "" {
+		name = c.defaultName
+	}
+	return c.name.Set(name, time.Now())
+}
+
+// SetLink changes what the combo knows about the host. Until it is up the
+// screen says so in place of the name, over the level restored or turned
+// meanwhile.
+func (c *Combo) SetLink(link uint8) bool {
+	if link == c.link {
+		return false
+	}
+	c.link = link
+	if link != LinkUp {
+		c.meter.Reset()
+	}
+	return true
+}
+
+// SetMeter sets the level the combo's VU meter moves to, see package meter.
+func (c *Combo) SetMeter(level uint8) {
+	c.meter.Set(level)
+}
+
+// AdvanceMeter moves the VU meter once it is due and reports whether the
+// combo needs to be drawn again. Only bars carry a meter.
+func (c *Combo) AdvanceMeter(now time.Time) bool {
+	if c.link != LinkUp || c.muted || c.style == protocol.STYLE_SWITCHER {
+		return false
+	}
+	return c.meter.Advance(now)
+}
+
+// Snapshot returns what the combo keeps across power cycles.
+func (c *Combo) Snapshot() store.Combo {
+	return store.Combo{Level: c.state, Style: c.style, Muted: c.muted, Name: c.name.Full()}
+}
+
+// Restore brings back a snapshot at boot, so the screen shows the last
+// known state until the host syncs.
+func (c *Combo) Restore(s store.Combo) {
+	c.state = min(s.Level, 100)
+	c.style = s.Style
+	c.muted = s.Muted
+	c.SetName(s.Name)
+}
+
+// Scroll moves a name too long for the screen on once it is due and
+// reports whether the combo needs to be drawn again. Switchers wrap their
+// name instead.
+func (c *Combo) Scroll() bool {
+	if c.style == protocol.STYLE_SWITCHER {
+		return false
+	}
+	return c.name.Advance(time.Now())
+}
+
 const (
 	STEP_INCREASE = .8
 	MAX_STEP      = 8
@@ -61,8 +180,10 @@ func (c *Combo) Update() (*protocol.Event, bool) {
 	}
 	switch state {
 	case rotary.BtnClick:
-		c.state = 0
-		return protocol.NewEvent(protocol.EVENT_TYPE_CLICK, c.id, c.state), true
+		if c.style != protocol.STYLE_SWITCHER {
+			c.state = 0
+		}
+		return c.local(protocol.EVENT_TYPE_CLICK), true
 	case rotary.BtnDoubleClick:
 		return protocol.NewEvent(protocol.EVENT_TYPE_DOUBLE_CLICK, c.id, c.state), true
 	default:
@@ -104,16 +225,19 @@ func (c *Combo) Update() (*protocol.Event, bool) {
 		c.exactStep = 1
 	}
 
-	newState := int(c.state) + int(delta)*step
-	if newState < 0 {
-		c.state = 0
-	} else {
-		c.state = uint8(newState)
-		if c.state < 0 {
+	// Switchers only report the turn; the host answers with what to show.
+	if c.style != protocol.STYLE_SWITCHER {
+		newState := int(c.state) + int(delta)*step
+		if newState < 0 {
 			c.state = 0
-		}
-		if c.state > 100 {
-			c.state = 100
+		} else {
+			c.state = uint8(newState)
+			if c.state < 0 {
+				c.state = 0
+			}
+			if c.state > 100 {
+				c.state = 100
+			}
 		}
 	}
 
@@ -123,7 +247,14 @@ func (c *Combo) Update() (*protocol.Event, bool) {
 		eventType = protocol.EVENT_TYPE_CCW
 	}
 
-	return protocol.NewEvent(eventType, c.id, c.state), true
+	// Hosts applying turns themselves need the detents and their pace.
+	event := c.local(eventType)
+	detents := int(delta)
+	if detents < 0 {
+		detents = -detents
+	}
+	event.Data = protocol.TurnData(event.Data[0], detents, deltaTime)
+	return event, true
 }
 
 const TEXT_HEIGHT = 9
@@ -132,16 +263,115 @@ func (c *Combo) Draw() {
 	c.screen.Activate()
 	screenlib.Display.ClearBuffer()
 
-	centerText(c.name, &freemono.Regular9pt7b, TEXT_HEIGHT+8)
-	bar(c.state)
+	// Switchers show what the host picked, which is gone with it.
+	if c.link != LinkUp && c.style == protocol.STYLE_SWITCHER {
+		c.drawLink()
+		screenlib.Display.Display()
+		return
+	}
+
+	title, font := c.name.Text(), &freemono.Regular9pt7b
+	if c.link != LinkUp {
+		title, font = c.linkWord(), &freemono.Bold9pt7b
+	}
+
+	if c.muted {
+		drawMuted(title, font)
+		screenlib.Display.Display()
+		return
+	}
+
+	if c.style == protocol.STYLE_SWITCHER {
+		switcher(c.name.Full(), c.state == 1)
+		screenlib.Display.Display()
+		return
+	}
+
+	centerText(title, font, TEXT_HEIGHT+8, drawColor)
+	if c.style == protocol.STYLE_BALANCE {
+		balance(c.state)
+	} else {
+		bar(c.state)
+	}
+	vu(c.meter.Value())
+
+	screenlib.Display.Display()
+}
+
+// drawMuted lights the whole screen and cuts the title and a bold MUTED out
+// of it, so a muted endpoint is hard to miss.
+func drawMuted(title string, font *tinyfont.Font) {
+	for x := int16(0); x < 128; x++ {
+		for y := int16(0); y < 64; y++ {
+			screenlib.Display.SetPixel(x, y, drawColor)
+		}
+	}
+	centerText(title, font, TEXT_HEIGHT+8, clearColor)
+	centerText("MUTED", &freemono.Bold9pt7b, 64+TEXT_HEIGHT/2+4, clearColor)
+}
+
+// linkWord says whether the host has not spoken yet or is gone.
+func (c *Combo) linkWord() string {
+	if c.link == LinkLost {
+		return "lost"
+	}
+	return "wait"
+}
+
+// drawLink shows the name and that the host has not spoken yet or is gone.
+func (c *Combo) drawLink() {
+	centerText(c.name.Text(), &freemono.Regular9pt7b, TEXT_HEIGHT+8, drawColor)
+	lines := []string{"wait", "host"}
+	if c.link == LinkLost {
+		lines = []string{"host", "lost"}
+	}
+	x := 64
+	for _, line := range lines {
+		centerText(line, &freemono.Regular9pt7b, x, drawColor)
+		x += TEXT_HEIGHT + 12
+	}
+}
+
+// switcher shows the endpoint a switcher is on, broken into lines of
+// label.Width characters, and whether it is the default already or needs a
+// click.
+func switcher(name string, current bool) {
+	lineHeight := TEXT_HEIGHT + 12
+	x := TEXT_HEIGHT + 8
+	for line := 0; line < 4 && len(name) > 0; line++ {
+		n := min(len(name), label.Width)
+		centerText(name[:n], &freemono.Regular9pt7b, x, drawColor)
+		name = name[n:]
+		x += lineHeight
+	}
+
+	if current {
+		centerText("ON", &freemono.Bold9pt7b, 128-8, drawColor)
+	} else {
+		centerText("click", &freemono.Regular9pt7b, 128-8, drawColor)
+	}
+}
+
+// DrawMessage replaces the combo's screen with a few short centered lines.
+// Each line fits about five characters.
+func (c *Combo) DrawMessage(lines ...string) {
+	c.screen.Activate()
+	screenlib.Display.ClearBuffer()
+
+	lineHeight := TEXT_HEIGHT + 12
+	x := (128-len(lines)*lineHeight)/2 + TEXT_HEIGHT + 4
+	for _, line := range lines {
+		centerText(line, &freemono.Regular9pt7b, x, drawColor)
+		x += lineHeight
+	}
 
 	screenlib.Display.Display()
 }
 
-func centerText(text string, font *tinyfont.Font, x int) {
+func centerText(text string, font *tinyfont.Font, x int, c color.RGBA) {
 	_, outBox := tinyfont.LineWidth(font, text)
 	y := 64 - ((64 - outBox) / 2)
-	tinyfont.WriteLineRotated(screenlib.Display, font, int16(x), int16(y), text, drawColor, tinyfont.ROTATION_270)
+	tinyfont.WriteLineRotated(screenlib.Display, font, int16(x), int16(y), text, c, tinyfont.ROTATION_270)
 }
 
 const (
@@ -151,15 +381,90 @@ const (
 	quadrantBottomRight
 )
 
+const (
+	leftX  int16 = 23
+	rightX int16 = 124
+	// topY int16 = 17
+	bottomY int16 = 64 - 10
+	topY    int16 = bottomY - 30
+	// bottomY int16 = 47
+	radius int16 = 10
+)
+
 func bar(volume uint8) {
-	var leftX int16 = 23
-	var rightX int16 = 124
-	// var topY int16 = 17
-	var bottomY int16 = 64 - 10
-	var topY int16 = bottomY - 30
-	// var bottomY int16 = 47
-	var radius int16 = 10
+	outline()
 
+	if volume > 0 {
+		startX := rightX - 1 - int16(volume-1)
+		if startX < leftX+1 {
+			startX = leftX + 1
+		}
+		for x := startX; x <= rightX-1; x++ {
+			fillColumn(x)
+		}
+	}
+
+	var text string
+	if volume == 100 {
+		text = "!!"
+	} else {
+		text = fmt.Sprintf("%02d", volume)
+	}
+	barText(text, volume)
+}
+
+// balance draws a bar filled from its center towards the position, with
+// marks at the center and the distance from it as text.
+func balance(position uint8) {
+	outline()
+
+	centerX := rightX - 50
+	x := rightX - int16(position)
+	for i := min(x, centerX); i <= max(x, centerX); i++ {
+		if i > leftX && i < rightX {
+			fillColumn(i)
+		}
+	}
+	for i := int16(1); i <= 4; i++ {
+		screenlib.Display.SetPixel(centerX, topY-i, drawColor)
+		screenlib.Display.SetPixel(centerX, bottomY+i, drawColor)
+	}
+
+	offset := int(position) - 50
+	if offset < 0 {
+		offset = -offset
+	}
+	var text string
+	if offset == 50 {
+		text = "!!"
+	} else {
+		text = fmt.Sprintf("%02d", offset*2)
+	}
+	barText(text, position)
+}
+
+// vu draws the meter as a band through the middle of the bar, filled from
+// the same end as the bar. The band inverts what is below it, so it shows
+// on both the filled and the empty part.
+func vu(level uint8) {
+	middle := (topY + bottomY) / 2
+	for i := int16(0); i < int16(level); i++ {
+		x := rightX - 1 - i
+		if x <= leftX {
+			break
+		}
+		for y := middle - 1; y <= middle+1; y++ {
+			if screenlib.Display.GetPixel(x, y) {
+				screenlib.Display.SetPixel(x, y, clearColor)
+			} else {
+				screenlib.Display.SetPixel(x, y, drawColor)
+			}
+		}
+	}
+}
+
+// outline draws the rounded frame of the bar.
+func outline() {
 	for y := topY + radius; y <= bottomY-radius; y++ {
 		screenlib.Display.SetPixel(leftX, y, drawColor)
 		screenlib.Display.SetPixel(rightX, y, drawColor)
@@ -174,41 +479,32 @@ func bar(volume uint8) {
 	drawCorner(rightX-radius, topY+radius, radius, quadrantTopRight)
 	drawCorner(leftX+radius, bottomY-radius, radius, quadrantBottomLeft)
 	drawCorner(rightX-radius, bottomY-radius, radius, quadrantBottomRight)
+}
 
-	if volume > 0 {
-		startX := rightX - 1 - int16(volume-1)
-		if startX < leftX+1 {
-			startX = leftX + 1
-		}
-		endX := rightX - 1
-		for x := startX; x <= endX; x++ {
-			var yStart, yEnd int16
-			if x >= leftX+radius && x <= rightX-radius {
-				yStart = topY + 1
-				yEnd = bottomY - 1
-			} else {
-				var dx int16
-				if x < leftX+radius {
-					dx = (leftX + radius) - x
-				} else {
-					dx = x - (rightX - radius)
-				}
-				dy := int16(math.Ceil(math.Sqrt(float64(radius*radius - dx*dx))))
-				yStart = (topY + radius) - dy + 1
-				yEnd = (bottomY - radius) + dy - 1
-			}
-			for y := yStart; y <= yEnd; y++ {
-				screenlib.Display.SetPixel(x, y, drawColor)
-			}
+// fillColumn fills the inside of the bar at x, following the rounded ends.
+func fillColumn(x int16) {
+	var yStart, yEnd int16
+	if x >= leftX+radius && x <= rightX-radius {
+		yStart = topY + 1
+		yEnd = bottomY - 1
+	} else {
+		var dx int16
+		if x < leftX+radius {
+			dx = (leftX + radius) - x
+		} else {
+			dx = x - (rightX - radius)
 		}
+		dy := int16(math.Ceil(math.Sqrt(float64(radius*radius - dx*dx))))
+		yStart = (topY + radius) - dy + 1
+		yEnd = (bottomY - radius) + dy - 1
 	}
-
-	var text string
-	if volume == 100 {
-		text = "!!"
-	} else {
-		text = fmt.Sprintf("%02d", volume)
+	for y := yStart; y <= yEnd; y++ {
+		screenlib.Display.SetPixel(x, y, drawColor)
 	}
+}
+
+// barText writes text above the bar, following the position.
+func barText(text string, volume uint8) {
 	var space int16 = 64 - topY
 	var textStartY int16 = topY - space/2 + TEXT_HEIGHT*2 - 2
 	var textPosX int16 = rightX - int16(volume) + TEXT_HEIGHT/2
@@ -0,0 +1,132 @@
+// Package label fits combo names onto the screens. The fonts only cover
+// printable ASCII and a line holds about Width characters, so names are
+// folded to ASCII and longer ones scroll.
+//
+// The package is used by the firmware and tested on the host.
+package label
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// Width is how many characters fit on a line of a screen.
+	Width = 5
+	// Step is how long each position of a scrolling label is shown.
+	Step = 400 * time.Millisecond
+	// Pause is how long a scrolling label rests at its start.
+	Pause = 2 * time.Second
+
+	// gap separates the end of a scrolling label from its start.
+	gap = "  "
+)
+
+// folds maps characters the fonts lack onto ASCII.
+var folds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE",
+	'‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-", '…': "...",
+}
+
+// letters lists accented letters by the letter they fold onto.
+var letters = []struct {
+	from string
+	to   string
+}{
+	{"àáâãäåāą", "a"}, {"ÀÁÂÃÄÅĀĄ", "A"},
+	{"çćč", "c"}, {"ÇĆČ", "C"},
+	{"ďđ", "d"}, {"ĎĐ", "D"},
+	{"èéêëēęě", "e"}, {"ÈÉÊËĒĘĚ", "E"},
+	{"ìíîïī", "i"}, {"ÌÍÎÏĪ", "I"},
+	{"łľ", "l"}, {"ŁĽ", "L"},
+	{"ñńň", "n"}, {"ÑŃŇ", "N"},
+	{"òóôõöøō", "o"}, {"ÒÓÔÕÖØŌ", "O"},
+	{"řŕ", "r"}, {"ŘŔ", "R"},
+	{"śšş", "s"}, {"ŚŠŞ", "S"},
+	{"ťţ", "t"}, {"ŤŢ", "T"},
+	{"ùúûüůū", "u"}, {"ÙÚÛÜŮŪ", "U"},
+	{"ýÿ", "y"}, {"ÝŸ", "Y"},
+	{"źżž", "z"}, {"ŹŻŽ", "Z"},
+}
+
+func init() {
+	for _, l := range letters {
+		for _, r := range l.from {
+			folds[r] = l.to
+		}
+	}
+}
+
+// Fold maps a UTF-8 name onto the characters the fonts have. Accented
+// letters lose their accents, whitespace becomes a space and anything else
+// outside printable ASCII, including invalid UTF-8, becomes '?'.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch f, ok := folds[r]; {
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case ok:
+			b.WriteString(f)
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Marquee shows a label in a window of Width characters, scrolling through
+// it if it is longer.
+type Marquee struct {
+	text   string
+	offset int
+	next   time.Time
+}
+
+// Set folds a label and shows it from its start. It reports whether the
+// label changed.
+func (m *Marquee) Set(text string, now time.Time) bool {
+	text = Fold(text)
+	if text == m.text {
+		return false
+	}
+	m.text, m.offset, m.next = text, 0, now.Add(Pause)
+	return true
+}
+
+// Full returns the whole folded label.
+func (m *Marquee) Full() string {
+	return m.text
+}
+
+// Scrolls reports whether the label is too long to show at once.
+func (m *Marquee) Scrolls() bool {
+	return len(m.text) > Width
+}
+
+// Text returns the part of the label to show now.
+func (m *Marquee) Text() string {
+	if !m.Scrolls() {
+		return m.text
+	}
+	loop := m.text + gap + m.text
+	return loop[m.offset : m.offset+Width]
+}
+
+// Advance scrolls the label by one character once it is due and reports
+// whether Text changed.
+func (m *Marquee) Advance(now time.Time) bool {
+	if !m.Scrolls() || now.Before(m.next) {
+		return false
+	}
+	m.offset = (m.offset + 1) % (len(m.text) + len(gap))
+	if m.offset == 0 {
+		m.next = now.Add(Pause)
+	} else {
+		m.next = now.Add(Step)
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package label
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Chat", "Chat"},
+		{"Spiel", "Spiel"},
+		{"Gäme", "Game"},
+		{"Straße", "Strasse"},
+		{"Señal\tÉté", "Senal Ete"},
+		{"Game 🎮", "Game ?"},
+		{"a\xffb", "a?b"},
+	}
+	for _, tt := range tests {
+		if got := Fold(tt.in); got != tt.want {
+			t.Errorf("Fold(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestMarqueeShort(t *testing.T) {
+	var m Marquee
+	now := time.Now()
+	if !m.Set("Chät", now) {
+		t.Errorf("Expected a new label to change")
+	}
+	if m.Set("Chät", now) {
+		t.Errorf("Expected the same label not to change")
+	}
+	if m.Text() != "Chat" || m.Scrolls() {
+		t.Errorf("Expected Chat without scrolling, got %q", m.Text())
+	}
+	if m.Advance(now.Add(time.Hour)) {
+		t.Errorf("Expected a short label never to scroll")
+	}
+}
+
+func TestMarqueeScroll(t *testing.T) {
+	var m Marquee
+	now := time.Now()
+	m.Set("Discord", now)
+	if m.Text() != "Disco" {
+		t.Errorf("Expected Disco, got %q", m.Text())
+	}
+
+	// The label rests at its start first.
+	if m.Advance(now.Add(Pause - time.Millisecond)) {
+		t.Errorf("Expected no scrolling during the pause")
+	}
+	now = now.Add(Pause)
+	var seen []string
+	for i := 0; i < 9; i++ {
+		if !m.Advance(now) {
+			t.Fatalf("Expected step %d to scroll", i)
+		}
+		seen = append(seen, m.Text())
+		now = now.Add(Step)
+	}
+	want := []string{"iscor", "scord", "cord ", "ord  ", "rd  D", "d  Di", "  Dis", " Disc", "Disco"}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("Step %d: expected %q, got %q", i, want[i], seen[i])
+		}
+	}
+
+	// Back at the start it pauses again.
+	if m.Advance(now) {
+		t.Errorf("Expected a pause after a full loop")
+	}
+
+	m.Set("Media", now)
+	if m.Text() != "Media" || m.Scrolls() {
+		t.Errorf("Expected a new short label to stop scrolling, got %q", m.Text())
+	}
+}
@@ -0,0 +1,78 @@
+// Package meter turns peak levels into the VU meters drawn over the combo
+// bars. The host scales peaks with Level before streaming them, and the
+// firmware moves a Needle towards the levels it receives.
+//
+// The package is used by the firmware and tested on the host.
+package meter
+
+import (
+	"desktop-audio-ctrl/protocol"
+	"math"
+	"time"
+)
+
+const (
+	// Floor is the quietest peak a meter shows, in dB below full scale.
+	Floor = -60
+	// Frame is the shortest time between two positions of a needle, so
+	// meters do not take up the I2C bus the screens and knobs share.
+	Frame = 100 * time.Millisecond
+	// Fall is how fast a needle drops, in levels per second. It rises at
+	// once, so short peaks still show.
+	Fall = 150
+)
+
+// Level maps a peak from 0 to 1 onto 0 to protocol.MAX_METER on a dB scale
+// from Floor to full scale, which matches how loud it sounds.
+func Level(peak float32) uint8 {
+	if peak <= 0 {
+		return 0
+	}
+	db := 20 * math.Log10(float64(min(peak, 1)))
+	if db <= Floor {
+		return 0
+	}
+	return uint8(math.Round((1 - db/Floor) * protocol.MAX_METER))
+}
+
+// Needle is the position of one meter.
+type Needle struct {
+	target uint8
+	shown  uint8
+	moved  time.Time
+}
+
+// Set makes level the position the needle moves to.
+func (n *Needle) Set(level uint8) {
+	n.target = min(level, protocol.MAX_METER)
+}
+
+// Value returns the position to draw.
+func (n *Needle) Value() uint8 {
+	return n.shown
+}
+
+// Reset drops the needle to zero at once and reports whether it moved.
+func (n *Needle) Reset() bool {
+	moved := n.shown != 0
+	n.target, n.shown = 0, 0
+	return moved
+}
+
+// Advance moves the needle towards its target once a Frame has passed
+// since it last moved and reports whether Value changed.
+func (n *Needle) Advance(now time.Time) bool {
+	elapsed := now.Sub(n.moved)
+	if elapsed < Frame || n.shown == n.target {
+		return false
+	}
+	next := n.target
+	if next < n.shown {
+		// A needle that rested for long drops no further than in two
+		// Frames, so it still visibly falls.
+		fall := int(min(elapsed, 2*Frame).Seconds() * Fall)
+		next = uint8(max(int(n.shown)-max(fall, 1), int(n.target)))
+	}
+	n.shown, n.moved = next, now
+	return true
+}
@@ -0,0 +1,76 @@
+package meter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		peak float32
+		want uint8
+	}{
+		{0, 0},
+		{-1, 0},
+		{0.0005, 0},
+		{0.001, 0},
+		{0.01, 33},
+		{0.1, 67},
+		{0.5, 90},
+		{1, 100},
+		{2, 100},
+	}
+	for _, tt := range tests {
+		if got := Level(tt.peak); got != tt.want {
+			t.Errorf("Level(%v): expected %d, got %d", tt.peak, tt.want, got)
+		}
+	}
+}
+
+func TestNeedle(t *testing.T) {
+	var n Needle
+	now := time.Now()
+
+	// The needle jumps up at once.
+	n.Set(80)
+	if !n.Advance(now) || n.Value() != 80 {
+		t.Fatalf("Expected the needle at 80, got %d", n.Value())
+	}
+
+	// Within a Frame it stays where it is.
+	n.Set(90)
+	if n.Advance(now.Add(Frame/2)) || n.Value() != 80 {
+		t.Errorf("Expected no move within a frame, got %d", n.Value())
+	}
+	now = now.Add(Frame)
+	n.Advance(now)
+
+	// It falls by Fall levels a second, frame by frame.
+	n.Set(0)
+	var seen []uint8
+	for n.Advance(now.Add(Frame)) {
+		now = now.Add(Frame)
+		seen = append(seen, n.Value())
+	}
+	want := []uint8{75, 60, 45, 30, 15, 0}
+	if len(seen) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("Frame %d: expected %d, got %d", i, want[i], seen[i])
+		}
+	}
+
+	// After a long rest it does not drop all the way at once.
+	n.Set(100)
+	n.Advance(now.Add(Frame))
+	n.Set(0)
+	if n.Advance(now.Add(time.Hour)); n.Value() != 70 {
+		t.Errorf("Expected a drop of two frames to 70, got %d", n.Value())
+	}
+
+	if !n.Reset() || n.Value() != 0 || n.Reset() {
+		t.Errorf("Expected Reset to drop the needle once, got %d", n.Value())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"desktop-audio-ctrl/multiplexer"
 	"desktop-audio-ctrl/protocol"
 	screenlib "desktop-audio-ctrl/screen"
+	"desktop-audio-ctrl/store"
 	"image/color"
 	"machine"
 	"time"
@@ -15,17 +16,51 @@ import (
 const (
 	muxAddr = 0x70
 
-	DELIMINATOR = 0xF0
+	DELIMINATOR = protocol.DELIMITER
+
+	// MAX_FRAME_LENGTH bounds incoming frames; longer ones are dropped.
+	MAX_FRAME_LENGTH = 64
 
 	INACTIVITY_TIMEOUT = 15 * time.Second
+
+	NOTICE_DURATION = 2 * time.Second
+
+	// HOST_TIMEOUT is how long the host may stay quiet before it counts as
+	// gone. It pings every protocol.HEARTBEAT_PERIOD.
+	HOST_TIMEOUT = protocol.HEARTBEAT_MISSES * protocol.HEARTBEAT_PERIOD
+
+	// STATE_BLOCKS is how many flash erase blocks hold the saved state.
+	STATE_BLOCKS = 4
+	// SAVE_DELAY is how long the combos have to stay unchanged before their
+	// state is saved, so turning a knob does not wear the flash.
+	SAVE_DELAY = 5 * time.Second
 )
 
 var (
-	combos = make([]*combo.Combo, 5, 5)
+	combos = make([]*combo.Combo, protocol.COMBOS)
 	names  = []string{"Game", "Chat", "Media", "Aux", "Speak"}
 
 	lastActivity = time.Now()
 	screenOn     = true
+	noticeUntil  time.Time
+
+	// saved keeps the state in flash; it is nil if the flash is unusable.
+	saved *store.Store
+	// unsavedSince is when the combos last changed, or zero once saved.
+	unsavedSince time.Time
+
+	// link is whether the host is there, see combo.LinkUp, and hostSeen
+	// when it last sent a frame.
+	link     = combo.LinkWaiting
+	hostSeen time.Time
+	// pending holds the latest turn of each combo made while the host was
+	// away and pendingDetents the detents of all of them, clockwise ones
+	// positive, for delivery as one turn once it is back.
+	pending        = make([]*protocol.Event, protocol.COMBOS)
+	pendingDetents = make([]int, protocol.COMBOS)
+	// nextMeter is the combo whose meter is looked at first, so every
+	// screen gets its turn.
+	nextMeter = 0
 )
 
 func main() {
@@ -60,13 +95,16 @@ func main() {
 	println("Creating combos")
 	for i := 0; i < 5; i++ {
 		combos[i] = combo.NewCombo(i2c, uint8(i), uint16(0x30+i), names[i], uint8(i))
-		combos[i].Draw()
+	}
+	restoreState()
+	for _, c := range combos {
+		c.Draw()
 	}
 
 	serial := machine.Serial
 
-	const eventLength = 6
-	buffer := make([]byte, 0, eventLength)
+	buffer := make([]byte, 0, MAX_FRAME_LENGTH)
+	overflow := false
 
 	blinkInternal()
 
@@ -79,31 +117,98 @@ func main() {
 				println("Error reading serial:", err)
 				break
 			}
-			buffer = append(buffer, b)
 
-			if len(buffer) >= eventLength {
-				event, ok := protocol.Unmarshal(buffer[:eventLength-1])
+			if b != DELIMINATOR {
+				if len(buffer) < MAX_FRAME_LENGTH {
+					buffer = append(buffer, b)
+				} else {
+					overflow = true
+				}
+				continue
+			}
+
+			if !overflow {
+				event, ok := protocol.Unmarshal(buffer)
 				if ok {
+					hostAlive()
 					handleEvent(event)
-					serial.Write(protocol.Marshal(protocol.Event{Type: protocol.EVENT_TYPE_ACK, Combo: event.Combo, State: event.State}))
+					// Pings are answered with a pong instead, and meters
+					// come too often to be worth one.
+					if event.Type != protocol.EVENT_TYPE_PING && event.Type != protocol.EVENT_TYPE_METER {
+						ack := protocol.Marshal(protocol.Event{Type: protocol.EVENT_TYPE_ACK, Combo: event.Combo, State: event.State})
+						serial.Write(append(ack, DELIMINATOR))
+					}
 				} else {
 					// println("Invalid event received")
 				}
-				buffer = buffer[eventLength:]
+			} else {
+				println("Dropping oversized frame")
 			}
+			buffer = buffer[:0]
+			overflow = false
 		}
 
 		for i := 0; i < 5; i++ {
 			if event, ok := combos[i].Update(); ok {
 				combos[i].Draw()
 				updated = true
-				packet := protocol.Marshal(*event)
-				packet = append(packet, DELIMINATOR)
-				_, err := serial.Write(packet)
-				if err != nil {
-					println("ERROR: ", err)
+				switch {
+				case link == combo.LinkUp:
+					sendEvent(event)
+				case event.Type == protocol.EVENT_TYPE_CW || event.Type == protocol.EVENT_TYPE_CCW:
+					// Clicks would act on whatever the host has by
+					// the time it is back, so only turns are kept.
+					pending[i] = event
+					if detents, _, ok := event.Turn(); ok {
+						if event.Type == protocol.EVENT_TYPE_CCW {
+							pendingDetents[i] -= int(detents)
+						} else {
+							pendingDetents[i] += int(detents)
+						}
+					}
 				}
 				lastActivity = time.Now()
+				markUnsaved()
+			}
+		}
+
+		if link == combo.LinkUp && time.Since(hostSeen) > HOST_TIMEOUT {
+			println("Host lost")
+			setLink(combo.LinkLost)
+		}
+
+		if !unsavedSince.IsZero() && time.Since(unsavedSince) > SAVE_DELAY {
+			saveState()
+		}
+
+		if !noticeUntil.IsZero() && time.Now().After(noticeUntil) {
+			noticeUntil = time.Time{}
+			for _, c := range combos {
+				c.Draw()
+			}
+		}
+
+		// Scroll names too long for the screens, but without keeping them
+		// awake.
+		if screenOn && noticeUntil.IsZero() {
+			for _, c := range combos {
+				if c.Scroll() {
+					c.Draw()
+				}
+			}
+		}
+
+		// Meters redraw at most one screen per pass, which keeps the I2C
+		// bus free enough for the knobs.
+		if screenOn && noticeUntil.IsZero() {
+			now := time.Now()
+			for n := 0; n < len(combos); n++ {
+				i := (nextMeter + n) % len(combos)
+				if combos[i].AdvanceMeter(now) {
+					combos[i].Draw()
+					nextMeter = i + 1
+					break
+				}
 			}
 		}
 
@@ -122,6 +227,107 @@ func main() {
 	}
 }
 
+func sendEvent(event *protocol.Event) {
+	packet := append(protocol.Marshal(*event), DELIMINATOR)
+	if _, err := machine.Serial.Write(packet); err != nil {
+		println("ERROR: ", err)
+	}
+}
+
+// hostAlive notes a frame from the host. When the host comes back it shows
+// the combos again and delivers the turns made while it was away.
+func hostAlive() {
+	hostSeen = time.Now()
+	if link == combo.LinkUp {
+		return
+	}
+	println("Host connected")
+	setLink(combo.LinkUp)
+	for i, event := range pending {
+		if event == nil {
+			continue
+		}
+		// The latest turn carries the level and version, and the sum of
+		// all detents for hosts that apply turns themselves, without a
+		// pace as the turns are long past.
+		detents := pendingDetents[i]
+		event.Type = protocol.EVENT_TYPE_CW
+		if detents < 0 {
+			event.Type = protocol.EVENT_TYPE_CCW
+			detents = -detents
+		}
+		version, _ := event.Version()
+		event.Data = protocol.TurnData(version, detents, protocol.MAX_TURN_INTERVAL)
+		sendEvent(event)
+		pending[i], pendingDetents[i] = nil, 0
+	}
+}
+
+func setLink(l uint8) {
+	link = l
+	for _, c := range combos {
+		if c.SetLink(l) && screenOn && noticeUntil.IsZero() {
+			c.Draw()
+		}
+	}
+}
+
+// restoreState brings back the combos' state from flash, so the screens
+// show the last levels and names instead of random ones until the host
+// syncs.
+func restoreState() {
+	var err error
+	saved, err = store.New(machine.Flash, STATE_BLOCKS)
+	if err != nil {
+		println("State storage unavailable:", err.Error())
+		return
+	}
+	st, ok, err := saved.Load()
+	if err != nil {
+		println("Error loading state:", err.Error())
+		return
+	}
+	if !ok {
+		println("No saved state")
+		return
+	}
+	for i, c := range st.Combos {
+		if i < len(combos) {
+			combos[i].Restore(c)
+		}
+	}
+}
+
+// redraw shows a change the host made to a combo, unless a notice covers
+// the screens, and schedules saving it.
+func redraw(i uint8) {
+	markUnsaved()
+	if noticeUntil.IsZero() {
+		combos[i].Draw()
+	}
+}
+
+// markUnsaved notes that the combos changed, restarting the save delay.
+func markUnsaved() {
+	unsavedSince = time.Now()
+}
+
+// saveState writes the combos' state to flash; unchanged state is not
+// written again.
+func saveState() {
+	unsavedSince = time.Time{}
+	if saved == nil {
+		return
+	}
+	st := store.State{Combos: make([]store.Combo, len(combos))}
+	for i, c := range combos {
+		st.Combos[i] = c.Snapshot()
+	}
+	if err := saved.Save(st); err != nil {
+		println("Error saving state:", err.Error())
+	}
+}
+
 func blinkInternal() {
 	onTime := 100 * time.Millisecond
 	offTime := 100 * time.Millisecond
@@ -140,19 +346,80 @@ func handleEvent(e protocol.Event) {
 		println("Received ACK event for Combo:", e.Combo, "with State:", e.State)
 	case protocol.EVENT_TYPE_SET:
 		if e.Combo < uint8(len(combos)) {
-			changed := combos[e.Combo].SetState(e.State)
+			applied, changed := combos[e.Combo].ApplySet(e)
+			if !applied {
+				println("Ignoring stale SET event for Combo:", e.Combo)
+			}
 			if changed {
-				combos[e.Combo].Draw()
+				redraw(e.Combo)
 				lastActivity = time.Now()
 			}
 		} else {
 			println("Invalid Combo ID in SET event:", e.Combo)
 		}
+	case protocol.EVENT_TYPE_NOTIFY:
+		showNotice(e.State)
+	case protocol.EVENT_TYPE_LABEL:
+		if e.Combo < uint8(len(combos)) {
+			if combos[e.Combo].SetName(string(e.Data)) {
+				redraw(e.Combo)
+			}
+		} else {
+			println("Invalid Combo ID in LABEL event:", e.Combo)
+		}
+	case protocol.EVENT_TYPE_STYLE:
+		if e.Combo < uint8(len(combos)) {
+			if combos[e.Combo].SetStyle(e.State) {
+				redraw(e.Combo)
+			}
+		} else {
+			println("Invalid Combo ID in STYLE event:", e.Combo)
+		}
+	case protocol.EVENT_TYPE_MUTE:
+		if e.Combo < uint8(len(combos)) {
+			if combos[e.Combo].SetMuted(e.State != 0) {
+				redraw(e.Combo)
+			}
+		} else {
+			println("Invalid Combo ID in MUTE event:", e.Combo)
+		}
+	case protocol.EVENT_TYPE_METER:
+		levels, _ := e.Meters()
+		for i, level := range levels {
+			if i < len(combos) {
+				combos[i].SetMeter(level)
+			}
+		}
+	case protocol.EVENT_TYPE_PING:
+		// hostAlive already took note, the pong lets the host measure the
+		// round trip and tell this firmware still runs.
+		sendEvent(protocol.NewPong(&e))
 	default:
 		println("Received non-SET event:", e.String())
 	}
 }
 
+// showNotice briefly replaces all screens with a host notification.
+func showNotice(code uint8) {
+	var lines []string
+	switch code {
+	case protocol.NOTIFY_CONFIG_RELOADED:
+		lines = []string{"Cfg", "OK"}
+	case protocol.NOTIFY_CONFIG_ERROR:
+		lines = []string{"Cfg", "Error"}
+	default:
+		println("Unknown notification code:", code)
+		return
+	}
+
+	for _, c := range combos {
+		c.DrawMessage(lines...)
+	}
+	screenOn = true
+	lastActivity = time.Now()
+	noticeUntil = time.Now().Add(NOTICE_DURATION)
+}
+
 func turnScreensOff() {
 	for _, c := range combos {
 		c.ClearScreen()
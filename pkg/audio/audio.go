@@ -0,0 +1,252 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Flow is the direction audio travels through an endpoint.
+type Flow uint8
+
+const (
+	FlowRender Flow = iota
+	FlowCapture
+)
+
+func (f Flow) String() string {
+	switch f {
+	case FlowRender:
+		return "render"
+	case FlowCapture:
+		return "capture"
+	default:
+		return "unknown"
+	}
+}
+
+func (f Flow) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// ParseFlow parses "render" or "capture".
+func ParseFlow(s string) (Flow, error) {
+	switch s {
+	case "render":
+		return FlowRender, nil
+	case "capture":
+		return FlowCapture, nil
+	default:
+		return 0, fmt.Errorf("unknown flow %q, expected render or capture", s)
+	}
+}
+
+// State mirrors the endpoint states reported by the operating system.
+type State uint8
+
+const (
+	StateActive State = iota + 1
+	StateDisabled
+	StateNotPresent
+	StateUnplugged
+)
+
+func (s State) String() string {
+	switch s {
+	case StateActive:
+		return "active"
+	case StateDisabled:
+		return "disabled"
+	case StateNotPresent:
+		return "notpresent"
+	case StateUnplugged:
+		return "unplugged"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Device describes a single audio endpoint.
+type Device struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Flow  Flow   `json:"flow"`
+	State State  `json:"state"`
+}
+
+// Role selects which system default endpoint a selector refers to.
+type Role uint8
+
+const (
+	RoleNone Role = iota
+	RoleConsole
+	RoleCommunications
+)
+
+// Special device names that resolve to the current system default endpoint.
+const (
+	DefaultName               = "default"
+	DefaultCommunicationsName = "defaultCommunications"
+)
+
+var (
+	ErrNoMatch   = errors.New("no matching device")
+	ErrAmbiguous = errors.New("ambiguous device match")
+)
+
+// AmbiguousError is returned when a selector matches more than one device.
+type AmbiguousError struct {
+	Selector   Selector
+	Candidates []Device
+}
+
+func (e *AmbiguousError) Error() string {
+	names := make([]string, len(e.Candidates))
+	for i, d := range e.Candidates {
+		names[i] = fmt.Sprintf("%q (%s)", d.Name, d.ID)
+	}
+	return fmt.Sprintf("%s matches %d devices: %s", e.Selector, len(e.Candidates), strings.Join(names, ", "))
+}
+
+func (e *AmbiguousError) Unwrap() error {
+	return ErrAmbiguous
+}
+
+// Selector identifies an endpoint by ID, friendly name, name pattern or
+// default role, or the streams of an application by process name. Exactly
+// one of ID, Name, Pattern and App is expected to be set. Flow optionally
+// limits matches to render or capture endpoints and picks the default a
+// role selector refers to.
+type Selector struct {
+	ID      string
+	Name    string
+	Pattern string
+	App     string
+	Flow    string
+}
+
+// DefaultFlow returns the flow of the default endpoint a role selector
+// refers to: capture if the selector asks for it, render otherwise.
+func (s Selector) DefaultFlow() Flow {
+	if s.Flow == FlowCapture.String() {
+		return FlowCapture
+	}
+	return FlowRender
+}
+
+// Role reports whether the selector refers to a system default endpoint.
+func (s Selector) Role() Role {
+	switch {
+	case s.ID != "" || s.Pattern != "" || s.App != "":
+		return RoleNone
+	case strings.EqualFold(s.Name, DefaultName):
+		return RoleConsole
+	case strings.EqualFold(s.Name, DefaultCommunicationsName):
+		return RoleCommunications
+	default:
+		return RoleNone
+	}
+}
+
+// IsZero reports whether no selection criteria are set.
+func (s Selector) IsZero() bool {
+	return s.ID == "" && s.Name == "" && s.Pattern == "" && s.App == ""
+}
+
+func (s Selector) String() string {
+	var str string
+	switch {
+	case s.ID != "":
+		str = "deviceID " + s.ID
+	case s.Pattern != "":
+		str = "devicePattern /" + s.Pattern + "/"
+	case s.Name != "":
+		str = "deviceName " + s.Name
+	case s.App != "":
+		str = "app " + s.App
+	default:
+		return "empty selector"
+	}
+	if s.Flow != "" {
+		str += " (" + s.Flow + ")"
+	}
+	return str
+}
+
+// Validate checks that the selector is usable without looking at any devices.
+func (s Selector) Validate() error {
+	set := 0
+	for _, v := range []string{s.ID, s.Name, s.Pattern, s.App} {
+		if v != "" {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return errors.New("no device selector set")
+	case set > 1:
+		return errors.New("only one of deviceID, deviceName, devicePattern and app may be set")
+	}
+	if s.App != "" && s.Flow != "" {
+		return errors.New("flow does not apply to app")
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("invalid devicePattern: %w", err)
+		}
+	}
+	if s.Flow != "" {
+		if _, err := ParseFlow(s.Flow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match picks the single device in devices the selector refers to. Only
+// active devices are considered for name and pattern matches. Default role
+// and app selectors cannot be matched against a list and return ErrNoMatch.
+// Selectors with a flow only match endpoints of that flow.
+func Match(devices []Device, s Selector) (Device, error) {
+	if err := s.Validate(); err != nil {
+		return Device{}, err
+	}
+	if s.Role() != RoleNone || s.App != "" {
+		return Device{}, fmt.Errorf("%s: %w", s, ErrNoMatch)
+	}
+
+	var match func(Device) bool
+	switch {
+	case s.ID != "":
+		match = func(d Device) bool { return d.ID == s.ID }
+	case s.Pattern != "":
+		re := regexp.MustCompile(s.Pattern)
+		match = func(d Device) bool { return d.State == StateActive && re.MatchString(d.Name) }
+	default:
+		match = func(d Device) bool { return d.State == StateActive && strings.EqualFold(d.Name, s.Name) }
+	}
+
+	var candidates []Device
+	for _, d := range devices {
+		if s.Flow != "" && d.Flow.String() != s.Flow {
+			continue
+		}
+		if match(d) {
+			candidates = append(candidates, d)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return Device{}, fmt.Errorf("%s: %w", s, ErrNoMatch)
+	case 1:
+		return candidates[0], nil
+	default:
+		return Device{}, &AmbiguousError{Selector: s, Candidates: candidates}
+	}
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"errors"
+	"testing"
+)
+
+var testDevices = []Device{
+	{ID: "{0.0.0.00000000}.{9285d823}", Name: "SteelSeries Sonar - Gaming", Flow: FlowRender, State: StateActive},
+	{ID: "{0.0.0.00000000}.{21b28250}", Name: "SteelSeries Sonar - Chat", Flow: FlowRender, State: StateActive},
+	{ID: "{0.0.0.00000000}.{90ae6596}", Name: "Speakers (Realtek(R) Audio)", Flow: FlowRender, State: StateActive},
+	{ID: "{0.0.0.00000000}.{deadbeef}", Name: "Speakers (USB Audio)", Flow: FlowRender, State: StateUnplugged},
+	{ID: "{0.0.0.00000000}.{a7c4a7c4}", Name: "Arctis 7 Chat", Flow: FlowRender, State: StateActive},
+	{ID: "{0.0.1.00000000}.{a7c4a7c5}", Name: "Arctis 7 Chat", Flow: FlowCapture, State: StateActive},
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector Selector
+		wantID   string
+		wantErr  error
+	}{
+		{"by id", Selector{ID: "{0.0.0.00000000}.{21b28250}"}, "{0.0.0.00000000}.{21b28250}", nil},
+		{"by id inactive", Selector{ID: "{0.0.0.00000000}.{deadbeef}"}, "{0.0.0.00000000}.{deadbeef}", nil},
+		{"by name", Selector{Name: "SteelSeries Sonar - Gaming"}, "{0.0.0.00000000}.{9285d823}", nil},
+		{"by name case insensitive", Selector{Name: "steelseries sonar - chat"}, "{0.0.0.00000000}.{21b28250}", nil},
+		{"by name inactive", Selector{Name: "Speakers (USB Audio)"}, "", ErrNoMatch},
+		{"by pattern", Selector{Pattern: "Realtek"}, "{0.0.0.00000000}.{90ae6596}", nil},
+		{"by pattern skips inactive", Selector{Pattern: "^Speakers"}, "{0.0.0.00000000}.{90ae6596}", nil},
+		{"ambiguous pattern", Selector{Pattern: "Sonar"}, "", ErrAmbiguous},
+		{"missing", Selector{Name: "Headset"}, "", ErrNoMatch},
+		{"default role", Selector{Name: "default"}, "", ErrNoMatch},
+		{"name on both flows", Selector{Name: "Arctis 7 Chat"}, "", ErrAmbiguous},
+		{"by name capture", Selector{Name: "Arctis 7 Chat", Flow: "capture"}, "{0.0.1.00000000}.{a7c4a7c5}", nil},
+		{"by name render", Selector{Name: "Arctis 7 Chat", Flow: "render"}, "{0.0.0.00000000}.{a7c4a7c4}", nil},
+		{"by id wrong flow", Selector{ID: "{0.0.0.00000000}.{9285d823}", Flow: "capture"}, "", ErrNoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Match(testDevices, tt.selector)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if d.ID != tt.wantID {
+				t.Errorf("Expected device '%s', got '%s'", tt.wantID, d.ID)
+			}
+		})
+	}
+}
+
+func TestSelectorValidate(t *testing.T) {
+	if err := (Selector{}).Validate(); err == nil {
+		t.Errorf("Expected error for empty selector")
+	}
+	if err := (Selector{ID: "x", Name: "y"}).Validate(); err == nil {
+		t.Errorf("Expected error for selector with several criteria")
+	}
+	if err := (Selector{Pattern: "("}).Validate(); err == nil {
+		t.Errorf("Expected error for invalid pattern")
+	}
+	if err := (Selector{Pattern: "Sonar.*Chat"}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := (Selector{Name: "Mic", Flow: "input"}).Validate(); err == nil {
+		t.Errorf("Expected error for unknown flow")
+	}
+	if err := (Selector{Flow: "capture"}).Validate(); err == nil {
+		t.Errorf("Expected error for selector with only a flow")
+	}
+}
+
+func TestSelectorRole(t *testing.T) {
+	if r := (Selector{Name: "default"}).Role(); r != RoleConsole {
+		t.Errorf("Expected RoleConsole, got %d", r)
+	}
+	if r := (Selector{Name: "DefaultCommunications"}).Role(); r != RoleCommunications {
+		t.Errorf("Expected RoleCommunications, got %d", r)
+	}
+	if r := (Selector{Name: "Speakers"}).Role(); r != RoleNone {
+		t.Errorf("Expected RoleNone, got %d", r)
+	}
+	if f := (Selector{Name: "default", Flow: "capture"}).DefaultFlow(); f != FlowCapture {
+		t.Errorf("Expected FlowCapture, got %s", f)
+	}
+	if f := (Selector{Name: "default"}).DefaultFlow(); f != FlowRender {
+		t.Errorf("Expected FlowRender, got %s", f)
+	}
+}
@@ -0,0 +1,77 @@
+package audio
+
+import "errors"
+
+var (
+	ErrUnsupported = errors.New("audio backend not supported on this platform")
+	ErrClosed      = errors.New("audio backend closed")
+)
+
+// Backend is implemented by each platform audio API. Levels are scalars in
+// the range 0 to 1. Implementations must be safe for concurrent use.
+type Backend interface {
+	// Name identifies the backend in logs and command output.
+	Name() string
+
+	// Devices lists all render and capture endpoints, including inactive ones.
+	Devices() ([]Device, error)
+	// DefaultDevice returns the current system default endpoint for a role.
+	DefaultDevice(flow Flow, role Role) (Device, error)
+
+	Volume(deviceID string) (float32, error)
+	SetVolume(deviceID string, level float32) error
+	Mute(deviceID string) (bool, error)
+	SetMute(deviceID string, muted bool) error
+
+	// Watch calls onChange whenever endpoints are added, removed, change
+	// state or the default endpoint changes. onChange may be called from
+	// any goroutine.
+	Watch(onChange func(reason string)) error
+
+	Close() error
+}
+
+// DecibelBackend is implemented by backends that can set volumes in dB
+// rather than as scalars.
+type DecibelBackend interface {
+	// VolumeRange returns the lowest and highest volume an endpoint
+	// accepts in dB.
+	VolumeRange(deviceID string) (minDB, maxDB float32, err error)
+	VolumeDB(deviceID string) (float32, error)
+	SetVolumeDB(deviceID string, db float32) error
+}
+
+// DefaultSetter is implemented by backends that can change the system
+// default endpoints.
+type DefaultSetter interface {
+	// SetDefaultDevice makes an endpoint the default of its flow for a
+	// role.
+	SetDefaultDevice(deviceID string, role Role) error
+}
+
+// MeterBackend is implemented by backends that can measure what endpoints
+// play or record.
+type MeterBackend interface {
+	// Peak returns the highest level of an endpoint's samples, from 0 to 1,
+	// over the last moments.
+	Peak(deviceID string) (float32, error)
+}
+
+// Resolve finds the endpoint a selector currently refers to.
+func Resolve(b Backend, s Selector) (Device, error) {
+	if err := s.Validate(); err != nil {
+		return Device{}, err
+	}
+	if role := s.Role(); role != RoleNone {
+		return b.DefaultDevice(s.DefaultFlow(), role)
+	}
+	if s.App != "" {
+		return resolveApp(b, s.App)
+	}
+
+	devices, err := b.Devices()
+	if err != nil {
+		return Device{}, err
+	}
+	return Match(devices, s)
+}
@@ -0,0 +1,178 @@
+package pulse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// meterRate is the sample rate meters record at. Peaks only need a rough
+	// picture of the signal, so a low rate keeps the streams cheap.
+	meterRate = 8000
+	// meterIdle is how long a meter keeps recording after it was last read.
+	meterIdle = 5 * time.Second
+	// meterChunk is how many bytes of samples are looked at in one go, about
+	// 16ms of stereo float samples.
+	meterChunk = 1024
+)
+
+// meter records an endpoint and keeps the highest sample level seen since
+// it was last read.
+type meter struct {
+	stream io.ReadCloser
+	read   time.Time
+
+	mu    sync.Mutex
+	peak  float32
+	ended bool
+}
+
+// recordParec starts parec recording source as raw float samples.
+func recordParec(source string) (io.ReadCloser, error) {
+	path, err := exec.LookPath("parec")
+	if err != nil {
+		return nil, fmt.Errorf("parec not found: %w", err)
+	}
+	cmd := exec.Command(path,
+		"--device="+source,
+		"--format=float32le",
+		"--channels=2",
+		fmt.Sprintf("--rate=%d", meterRate),
+		"--latency-msec=20",
+		"--client-name=desktop-audio-ctrl",
+		"--stream-name=meter",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting parec: %w", err)
+	}
+	return &process{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// process is the output of a running command; closing it ends the command.
+type process struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p *process) Close() error {
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	return nil
+}
+
+// run detects the peaks of the stream until it ends.
+func (m *meter) run() {
+	buf := make([]byte, meterChunk)
+	for {
+		n, err := io.ReadFull(m.stream, buf)
+		var peak float32
+		for i := 0; i+4 <= n; i += 4 {
+			sample := math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+			peak = max(peak, float32(math.Abs(float64(sample))))
+		}
+
+		m.mu.Lock()
+		m.peak = max(m.peak, min(peak, 1))
+		m.ended = err != nil
+		m.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// take returns the peak since the last call and whether the stream ended.
+func (m *meter) take() (float32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peak := m.peak
+	m.peak = 0
+	return peak, m.ended
+}
+
+// Peak records the endpoint, or the monitor of a sink, from the first call
+// on and returns the highest level since the previous call. Recording stops
+// once Peak has not been called for a while.
+func (b *Backend) Peak(deviceID string) (float32, error) {
+	now := time.Now()
+	b.mu.Lock()
+	b.stopIdleMeters(now)
+	m, ok := b.meters[deviceID]
+	b.mu.Unlock()
+
+	if !ok {
+		var err error
+		if m, err = b.startMeter(deviceID); err != nil {
+			return 0, err
+		}
+	}
+
+	b.mu.Lock()
+	m.read = now
+	b.mu.Unlock()
+
+	peak, ended := m.take()
+	if ended {
+		// The endpoint went away or the server restarted; the next call
+		// starts over.
+		b.mu.Lock()
+		if b.meters[deviceID] == m {
+			delete(b.meters, deviceID)
+		}
+		b.mu.Unlock()
+		m.stream.Close()
+	}
+	return peak, nil
+}
+
+func (b *Backend) startMeter(deviceID string) (*meter, error) {
+	kind, e, err := b.lookup(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	source := e.Name
+	if kind == "sink" {
+		source = e.MonitorSource
+		if source == "" {
+			source = e.Name + ".monitor"
+		}
+	}
+	stream, err := b.record(source)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &meter{stream: stream, read: time.Now()}
+	b.mu.Lock()
+	if other, ok := b.meters[deviceID]; ok {
+		// Another call started one meanwhile.
+		b.mu.Unlock()
+		stream.Close()
+		return other, nil
+	}
+	b.meters[deviceID] = m
+	b.mu.Unlock()
+
+	go m.run()
+	return m, nil
+}
+
+// stopIdleMeters ends the meters nobody read for meterIdle. b.mu must be
+// held.
+func (b *Backend) stopIdleMeters(now time.Time) {
+	for id, m := range b.meters {
+		if now.Sub(m.read) > meterIdle {
+			delete(b.meters, id)
+			go m.stream.Close()
+		}
+	}
+}
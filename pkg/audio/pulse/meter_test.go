@@ -0,0 +1,83 @@
+package pulse
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeRecorder hands out pipes in place of parec streams.
+type fakeRecorder struct {
+	sources []string
+	writers []*io.PipeWriter
+}
+
+func (f *fakeRecorder) record(source string) (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	f.sources = append(f.sources, source)
+	f.writers = append(f.writers, w)
+	return r, nil
+}
+
+// chunk returns a chunk of samples holding the given ones and silence.
+func chunk(samples ...float32) []byte {
+	buf := make([]byte, meterChunk)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(s))
+	}
+	return buf
+}
+
+func TestPeak(t *testing.T) {
+	b := newBackend((&fakePactl{}).run)
+	rec := &fakeRecorder{}
+	b.record = rec.record
+	defer b.Close()
+
+	sink := "alsa_output.pci-0000_00_1f.3.analog-stereo"
+	if peak, err := b.Peak(sink); err != nil || peak != 0 {
+		t.Fatalf("Expected a silent new meter, got %v, %v", peak, err)
+	}
+	if rec.sources[0] != sink+".monitor" {
+		t.Errorf("Expected the sink's monitor to be recorded, got %s", rec.sources[0])
+	}
+
+	// The meter has looked at a chunk once the next one is taken.
+	rec.writers[0].Write(chunk(0.25, -0.5, 0.125))
+	rec.writers[0].Write(chunk())
+	if peak, _ := b.Peak(sink); peak != 0.5 {
+		t.Errorf("Expected peak 0.5, got %v", peak)
+	}
+	if peak, _ := b.Peak(sink); peak != 0 {
+		t.Errorf("Expected the peak to reset once read, got %v", peak)
+	}
+
+	// A stream that ends is started again.
+	rec.writers[0].Close()
+	time.Sleep(10 * time.Millisecond)
+	b.Peak(sink)
+	b.Peak(sink)
+	if len(rec.sources) != 2 {
+		t.Errorf("Expected the meter to restart, got %d streams", len(rec.sources))
+	}
+
+	source := "alsa_input.usb-headset.mono"
+	b.Peak(source)
+	if last := rec.sources[len(rec.sources)-1]; last != source {
+		t.Errorf("Expected the source itself to be recorded, got %s", last)
+	}
+
+	// Meters nobody reads stop.
+	b.mu.Lock()
+	b.meters[source].read = time.Now().Add(-2 * meterIdle)
+	b.mu.Unlock()
+	b.Peak(sink)
+	b.mu.Lock()
+	_, ok := b.meters[source]
+	b.mu.Unlock()
+	if ok {
+		t.Errorf("Expected the idle meter to stop")
+	}
+}
@@ -0,0 +1,445 @@
+package pulse
+
+import (
+	"bufio"
+	"bytes"
+	"desktop-audio-ctrl/pkg/audio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// volumeNorm is PA_VOLUME_NORM, the raw volume value for 100%.
+const volumeNorm = 0x10000
+
+// minDB is the lowest volume offered in dB. PulseAudio volumes go down to
+// silence, which has no finite dB value.
+const minDB = -60
+
+// Backend controls PulseAudio and PipeWire endpoints by running pactl, and
+// meters them by recording with parec. Endpoint IDs are sink and source
+// names, which stay stable across restarts.
+type Backend struct {
+	pactl  func(args ...string) ([]byte, error)
+	record func(source string) (io.ReadCloser, error)
+
+	mu     sync.Mutex
+	kinds  map[string]string // endpoint name -> "sink" or "source"
+	meters map[string]*meter // endpoint name -> running meter
+
+	subscribe *exec.Cmd
+}
+
+// New checks that pactl is available and returns a backend using it.
+func New() (audio.Backend, error) {
+	path, err := exec.LookPath("pactl")
+	if err != nil {
+		return nil, fmt.Errorf("pactl not found: %w", err)
+	}
+	return newBackend(func(args ...string) ([]byte, error) {
+		out, err := exec.Command(path, args...).Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return out, fmt.Errorf("pactl %s: %s", strings.Join(args, " "), bytes.TrimSpace(exitErr.Stderr))
+		}
+		return out, err
+	}), nil
+}
+
+func newBackend(pactl func(args ...string) ([]byte, error)) *Backend {
+	return &Backend{
+		pactl:  pactl,
+		record: recordParec,
+		kinds:  make(map[string]string),
+		meters: make(map[string]*meter),
+	}
+}
+
+type channelVolume struct {
+	Value int `json:"value"`
+}
+
+type port struct {
+	Name         string `json:"name"`
+	Availability string `json:"availability"`
+}
+
+// endpoint is the subset of `pactl -f json list sinks|sources` we use.
+type endpoint struct {
+	Index         int                      `json:"index"`
+	Name          string                   `json:"name"`
+	Description   string                   `json:"description"`
+	State         string                   `json:"state"`
+	Mute          bool                     `json:"mute"`
+	Volume        map[string]channelVolume `json:"volume"`
+	MonitorOfSink string                   `json:"monitor_of_sink"`
+	MonitorSource string                   `json:"monitor_source"`
+	Properties    map[string]string        `json:"properties"`
+	Ports         []port                   `json:"ports"`
+	ActivePort    string                   `json:"active_port"`
+}
+
+func (e *endpoint) isMonitor() bool {
+	if e.Properties["device.class"] == "monitor" {
+		return true
+	}
+	return e.MonitorOfSink != "" && e.MonitorOfSink != "n/a"
+}
+
+func (e *endpoint) level() float32 {
+	return average(e.Volume)
+}
+
+// average returns the mean of the channel volumes as a scalar.
+func average(volume map[string]channelVolume) float32 {
+	if len(volume) == 0 {
+		return 0
+	}
+	total := 0
+	for _, ch := range volume {
+		total += ch.Value
+	}
+	return float32(total) / float32(len(volume)) / volumeNorm
+}
+
+// sinkInput is the subset of `pactl -f json list sink-inputs` we use.
+type sinkInput struct {
+	Index      int                      `json:"index"`
+	Corked     bool                     `json:"corked"`
+	Mute       bool                     `json:"mute"`
+	Volume     map[string]channelVolume `json:"volume"`
+	Properties map[string]string        `json:"properties"`
+}
+
+func (e *endpoint) device(flow audio.Flow) audio.Device {
+	d := audio.Device{
+		ID:    e.Name,
+		Name:  e.Description,
+		Flow:  flow,
+		State: audio.StateActive,
+	}
+	for _, p := range e.Ports {
+		if p.Name == e.ActivePort && p.Availability == "not available" {
+			d.State = audio.StateUnplugged
+		}
+	}
+	return d
+}
+
+func (b *Backend) list(kind string) ([]endpoint, error) {
+	out, err := b.pactl("-f", "json", "list", kind+"s")
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []endpoint
+	if err := json.Unmarshal(out, &endpoints); err != nil {
+		return nil, fmt.Errorf("parsing pactl %s list: %w", kind, err)
+	}
+
+	b.mu.Lock()
+	for _, e := range endpoints {
+		b.kinds[e.Name] = kind
+	}
+	b.mu.Unlock()
+	return endpoints, nil
+}
+
+// lookup returns the sink or source named id.
+func (b *Backend) lookup(id string) (string, *endpoint, error) {
+	for _, kind := range []string{"sink", "source"} {
+		endpoints, err := b.list(kind)
+		if err != nil {
+			return "", nil, err
+		}
+		for i := range endpoints {
+			if endpoints[i].Name == id {
+				return kind, &endpoints[i], nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("%s: %w", id, audio.ErrNoMatch)
+}
+
+// kind returns whether id names a sink or a source, listing endpoints only
+// when the name has not been seen before.
+func (b *Backend) kind(id string) (string, error) {
+	b.mu.Lock()
+	kind, ok := b.kinds[id]
+	b.mu.Unlock()
+	if ok {
+		return kind, nil
+	}
+	kind, _, err := b.lookup(id)
+	return kind, err
+}
+
+func (b *Backend) Name() string {
+	return "pulse"
+}
+
+func (b *Backend) Devices() ([]audio.Device, error) {
+	var devices []audio.Device
+	for _, kind := range []string{"sink", "source"} {
+		endpoints, err := b.list(kind)
+		if err != nil {
+			return nil, err
+		}
+		flow := audio.FlowRender
+		if kind == "source" {
+			flow = audio.FlowCapture
+		}
+		for _, e := range endpoints {
+			if e.isMonitor() {
+				continue
+			}
+			devices = append(devices, e.device(flow))
+		}
+	}
+	return devices, nil
+}
+
+// DefaultDevice returns the default sink or source. PulseAudio has no
+// separate communications role, so every role maps to the same endpoint.
+func (b *Backend) DefaultDevice(flow audio.Flow, role audio.Role) (audio.Device, error) {
+	kind := "sink"
+	if flow == audio.FlowCapture {
+		kind = "source"
+	}
+	out, err := b.pactl("get-default-" + kind)
+	if err != nil {
+		return audio.Device{}, err
+	}
+	name := strings.TrimSpace(string(out))
+
+	_, e, err := b.lookup(name)
+	if err != nil {
+		return audio.Device{}, err
+	}
+	return e.device(flow), nil
+}
+
+// SetDefaultDevice makes deviceID the default sink or source, for every
+// role.
+func (b *Backend) SetDefaultDevice(deviceID string, role audio.Role) error {
+	kind, err := b.kind(deviceID)
+	if err != nil {
+		return err
+	}
+	_, err = b.pactl("set-default-"+kind, deviceID)
+	return err
+}
+
+func (b *Backend) Volume(deviceID string) (float32, error) {
+	_, e, err := b.lookup(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	return e.level(), nil
+}
+
+func (b *Backend) SetVolume(deviceID string, level float32) error {
+	kind, err := b.kind(deviceID)
+	if err != nil {
+		return err
+	}
+	raw := int(level*volumeNorm + 0.5)
+	_, err = b.pactl("set-"+kind+"-volume", deviceID, strconv.Itoa(raw))
+	return err
+}
+
+func (b *Backend) VolumeRange(deviceID string) (float32, float32, error) {
+	if _, err := b.kind(deviceID); err != nil {
+		return 0, 0, err
+	}
+	return minDB, 0, nil
+}
+
+// VolumeDB converts the raw volume like pa_sw_volume_to_dB, which treats
+// it as the cube root of the linear amplitude.
+func (b *Backend) VolumeDB(deviceID string) (float32, error) {
+	level, err := b.Volume(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	if level <= 0 {
+		return float32(math.Inf(-1)), nil
+	}
+	return float32(60 * math.Log10(float64(level))), nil
+}
+
+func (b *Backend) SetVolumeDB(deviceID string, db float32) error {
+	return b.SetVolume(deviceID, float32(math.Pow(10, float64(db)/60)))
+}
+
+func (b *Backend) Mute(deviceID string) (bool, error) {
+	_, e, err := b.lookup(deviceID)
+	if err != nil {
+		return false, err
+	}
+	return e.Mute, nil
+}
+
+func (b *Backend) SetMute(deviceID string, muted bool) error {
+	kind, err := b.kind(deviceID)
+	if err != nil {
+		return err
+	}
+	value := "0"
+	if muted {
+		value = "1"
+	}
+	_, err = b.pactl("set-"+kind+"-mute", deviceID, value)
+	return err
+}
+
+// Sessions lists the sink inputs of applications, named after their
+// application.process.binary.
+func (b *Backend) Sessions() ([]audio.Session, error) {
+	inputs, err := b.sinkInputs()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []audio.Session
+	for _, in := range inputs {
+		binary := in.Properties["application.process.binary"]
+		if binary == "" {
+			continue
+		}
+		sessions = append(sessions, audio.Session{
+			ID:     strconv.Itoa(in.Index),
+			App:    audio.NormalizeApp(binary),
+			Active: !in.Corked,
+		})
+	}
+	return sessions, nil
+}
+
+func (b *Backend) sinkInputs() ([]sinkInput, error) {
+	out, err := b.pactl("-f", "json", "list", "sink-inputs")
+	if err != nil {
+		return nil, err
+	}
+	var inputs []sinkInput
+	if err := json.Unmarshal(out, &inputs); err != nil {
+		return nil, fmt.Errorf("parsing pactl sink-input list: %w", err)
+	}
+	return inputs, nil
+}
+
+// sinkInput returns the sink input with index id.
+func (b *Backend) sinkInput(id string) (*sinkInput, error) {
+	inputs, err := b.sinkInputs()
+	if err != nil {
+		return nil, err
+	}
+	for i := range inputs {
+		if strconv.Itoa(inputs[i].Index) == id {
+			return &inputs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("sink input %s: %w", id, audio.ErrNotRunning)
+}
+
+func (b *Backend) SessionVolume(sessionID string) (float32, error) {
+	in, err := b.sinkInput(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return average(in.Volume), nil
+}
+
+func (b *Backend) SetSessionVolume(sessionID string, level float32) error {
+	raw := int(level*volumeNorm + 0.5)
+	_, err := b.pactl("set-sink-input-volume", sessionID, strconv.Itoa(raw))
+	return err
+}
+
+func (b *Backend) SessionMute(sessionID string) (bool, error) {
+	in, err := b.sinkInput(sessionID)
+	if err != nil {
+		return false, err
+	}
+	return in.Mute, nil
+}
+
+func (b *Backend) SetSessionMute(sessionID string, muted bool) error {
+	value := "0"
+	if muted {
+		value = "1"
+	}
+	_, err := b.pactl("set-sink-input-mute", sessionID, value)
+	return err
+}
+
+// Watch follows `pactl subscribe` and reports endpoints coming and going as
+// well as server changes, which include changes of the default endpoint.
+func (b *Backend) Watch(onChange func(reason string)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribe != nil {
+		return fmt.Errorf("already watching devices")
+	}
+
+	cmd := exec.Command("pactl", "subscribe")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting pactl subscribe: %w", err)
+	}
+	b.subscribe = cmd
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if reason, ok := subscribeReason(scanner.Text()); ok {
+				onChange(reason)
+			}
+		}
+		cmd.Wait()
+	}()
+	return nil
+}
+
+// subscribeReason maps a `pactl subscribe` line such as
+// "Event 'new' on sink #57" to a change reason.
+func subscribeReason(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "Event" {
+		return "", false
+	}
+	event := strings.Trim(fields[1], "'")
+	facility := fields[3]
+
+	switch {
+	case facility == "server" && event == "change":
+		return "default device changed", true
+	case facility != "sink" && facility != "source":
+		return "", false
+	case event == "new":
+		return "device added", true
+	case event == "remove":
+		return "device removed", true
+	default:
+		return "", false
+	}
+}
+
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribe != nil && b.subscribe.Process != nil {
+		b.subscribe.Process.Kill()
+		b.subscribe = nil
+	}
+	for id, m := range b.meters {
+		delete(b.meters, id)
+		go m.stream.Close()
+	}
+	return nil
+}
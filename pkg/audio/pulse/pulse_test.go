@@ -0,0 +1,238 @@
+package pulse
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const sinksJSON = `[
+  {"index":52,"state":"RUNNING","name":"alsa_output.pci-0000_00_1f.3.analog-stereo","description":"Built-in Audio Analog Stereo",
+   "mute":false,"volume":{"front-left":{"value":32768},"front-right":{"value":32768}},
+   "properties":{"device.class":"sound"},"ports":[{"name":"analog-output-speaker","availability":"available"}],"active_port":"analog-output-speaker"},
+  {"index":53,"state":"SUSPENDED","name":"alsa_output.usb-headset.analog-stereo","description":"USB Headset",
+   "mute":true,"volume":{"mono":{"value":65536}},
+   "properties":{"device.class":"sound"},"ports":[{"name":"analog-output","availability":"not available"}],"active_port":"analog-output"}
+]`
+
+const sourcesJSON = `[
+  {"index":60,"state":"IDLE","name":"alsa_output.pci-0000_00_1f.3.analog-stereo.monitor","description":"Monitor of Built-in Audio",
+   "mute":false,"volume":{"front-left":{"value":65536}},"monitor_of_sink":"alsa_output.pci-0000_00_1f.3.analog-stereo",
+   "properties":{"device.class":"monitor"}},
+  {"index":61,"state":"RUNNING","name":"alsa_input.usb-headset.mono","description":"USB Headset Microphone",
+   "mute":false,"volume":{"mono":{"value":16384}},"monitor_of_sink":"n/a",
+   "properties":{"device.class":"sound"}}
+]`
+
+const sinkInputsJSON = `[
+  {"index":201,"sink":52,"corked":false,"mute":false,"volume":{"front-left":{"value":32768},"front-right":{"value":32768}},
+   "properties":{"application.name":"Spotify","application.process.binary":"spotify"}},
+  {"index":202,"sink":52,"corked":true,"mute":true,"volume":{"front-left":{"value":65536}},
+   "properties":{"application.name":"Discord","application.process.binary":"Discord"}},
+  {"index":203,"sink":52,"corked":false,"mute":false,"volume":{"mono":{"value":65536}},
+   "properties":{"media.name":"event"}}
+]`
+
+// fakePactl records invocations and answers list and default queries.
+type fakePactl struct {
+	calls []string
+}
+
+func (f *fakePactl) run(args ...string) ([]byte, error) {
+	call := strings.Join(args, " ")
+	f.calls = append(f.calls, call)
+	switch call {
+	case "-f json list sinks":
+		return []byte(sinksJSON), nil
+	case "-f json list sources":
+		return []byte(sourcesJSON), nil
+	case "-f json list sink-inputs":
+		return []byte(sinkInputsJSON), nil
+	case "get-default-sink":
+		return []byte("alsa_output.pci-0000_00_1f.3.analog-stereo\n"), nil
+	}
+	return nil, nil
+}
+
+func TestDevices(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	devices, err := b.Devices()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("Expected 3 devices without monitors, got %d: %+v", len(devices), devices)
+	}
+
+	headset := devices[1]
+	if headset.Name != "USB Headset" || headset.State != audio.StateUnplugged || headset.Flow != audio.FlowRender {
+		t.Errorf("Unexpected headset device: %+v", headset)
+	}
+	mic := devices[2]
+	if mic.ID != "alsa_input.usb-headset.mono" || mic.Flow != audio.FlowCapture {
+		t.Errorf("Unexpected microphone device: %+v", mic)
+	}
+}
+
+func TestVolumeAndMute(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	level, err := b.Volume("alsa_output.pci-0000_00_1f.3.analog-stereo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if level != 0.5 {
+		t.Errorf("Expected level 0.5, got %v", level)
+	}
+
+	muted, err := b.Mute("alsa_output.usb-headset.analog-stereo")
+	if err != nil || !muted {
+		t.Errorf("Expected headset to be muted, got %v (%v)", muted, err)
+	}
+
+	if _, err := b.Volume("missing"); !errors.Is(err, audio.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestSetVolumeAndMute(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	if err := b.SetVolume("alsa_input.usb-headset.mono", 0.25); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := b.SetMute("alsa_input.usb-headset.mono", true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []string{
+		"set-source-volume alsa_input.usb-headset.mono 16384",
+		"set-source-mute alsa_input.usb-headset.mono 1",
+	}
+	got := f.calls[len(f.calls)-2:]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected call '%s', got '%s'", want[i], got[i])
+		}
+	}
+}
+
+func TestDefaultDevice(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	d, err := b.DefaultDevice(audio.FlowRender, audio.RoleCommunications)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Name != "Built-in Audio Analog Stereo" {
+		t.Errorf("Unexpected default device: %+v", d)
+	}
+}
+
+func TestSetDefaultDevice(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	for _, id := range []string{"alsa_output.usb-headset.analog-stereo", "alsa_input.usb-headset.mono"} {
+		if err := b.SetDefaultDevice(id, audio.RoleConsole); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := b.SetDefaultDevice("gone", audio.RoleConsole); !errors.Is(err, audio.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch, got %v", err)
+	}
+
+	want := []string{
+		"set-default-sink alsa_output.usb-headset.analog-stereo",
+		"set-default-source alsa_input.usb-headset.mono",
+	}
+	var got []string
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, "set-default-") {
+			got = append(got, call)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected calls %q, got %q", want, got)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	sessions, err := b.Sessions()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []audio.Session{{ID: "201", App: "spotify", Active: true}, {ID: "202", App: "discord"}}
+	if len(sessions) != len(want) || sessions[0] != want[0] || sessions[1] != want[1] {
+		t.Errorf("Expected sessions %+v, got %+v", want, sessions)
+	}
+
+	if level, err := audio.AppVolume(b, "Spotify"); err != nil || level != 0.5 {
+		t.Errorf("Expected volume 0.5, got %v, %v", level, err)
+	}
+	if muted, err := audio.AppMute(b, "discord"); err != nil || !muted {
+		t.Errorf("Expected discord to be muted, got %v, %v", muted, err)
+	}
+	if err := audio.SetAppVolume(b, "spotify", 0.25); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := f.calls[len(f.calls)-1]; got != "set-sink-input-volume 201 16384" {
+		t.Errorf("Expected call 'set-sink-input-volume 201 16384', got '%s'", got)
+	}
+	if err := audio.SetAppMute(b, "discord", false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := f.calls[len(f.calls)-1]; got != "set-sink-input-mute 202 0" {
+		t.Errorf("Expected call 'set-sink-input-mute 202 0', got '%s'", got)
+	}
+}
+
+func TestSubscribeReason(t *testing.T) {
+	tests := []struct {
+		line   string
+		reason string
+		ok     bool
+	}{
+		{"Event 'new' on sink #57", "device added", true},
+		{"Event 'remove' on source #12", "device removed", true},
+		{"Event 'change' on server #-1", "default device changed", true},
+		{"Event 'change' on sink #52", "", false},
+		{"Event 'new' on sink-input #301", "", false},
+		{"garbage", "", false},
+	}
+	for _, tt := range tests {
+		reason, ok := subscribeReason(tt.line)
+		if reason != tt.reason || ok != tt.ok {
+			t.Errorf("subscribeReason(%q) = %q, %v; expected %q, %v", tt.line, reason, ok, tt.reason, tt.ok)
+		}
+	}
+}
+
+func TestVolumeDB(t *testing.T) {
+	f := &fakePactl{}
+	b := newBackend(f.run)
+
+	db, err := b.VolumeDB("alsa_output.pci-0000_00_1f.3.analog-stereo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if db < -18.07 || db > -18.05 {
+		t.Errorf("Expected -18.06 dB at 50%%, got %v", db)
+	}
+
+	if err := b.SetVolumeDB("alsa_input.usb-headset.mono", -36.1236); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := f.calls[len(f.calls)-1]; got != "set-source-volume alsa_input.usb-headset.mono 16384" {
+		t.Errorf("Expected call 'set-source-volume alsa_input.usb-headset.mono 16384', got '%s'", got)
+	}
+}
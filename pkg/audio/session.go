@@ -0,0 +1,170 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Session is one audio stream of an application.
+type Session struct {
+	// ID identifies the stream for as long as it exists.
+	ID string `json:"id"`
+	// App is the stream's process name, see NormalizeApp.
+	App string `json:"app"`
+	// Active reports whether the stream is playing.
+	Active bool `json:"active"`
+	// Peak is the stream's current peak level from 0 to 1, or 0 where the
+	// backend cannot meter streams, see SessionMeter.
+	Peak float32 `json:"peak,omitempty"`
+}
+
+// SessionMeter is implemented by SessionBackends that fill in Session.Peak.
+type SessionMeter interface {
+	// MetersSessions reports whether Sessions reports peaks.
+	MetersSessions() bool
+}
+
+// MetersSessions reports whether b fills in Session.Peak.
+func MetersSessions(b SessionBackend) bool {
+	m, ok := b.(SessionMeter)
+	return ok && m.MetersSessions()
+}
+
+// SessionBackend is implemented by backends that can control the streams of
+// single applications. Levels are scalars like those of endpoints.
+type SessionBackend interface {
+	// Sessions lists the streams of all running applications.
+	Sessions() ([]Session, error)
+	SessionVolume(sessionID string) (float32, error)
+	SetSessionVolume(sessionID string, level float32) error
+	SessionMute(sessionID string) (bool, error)
+	SetSessionMute(sessionID string, muted bool) error
+}
+
+var (
+	ErrNotRunning = errors.New("app is not running")
+	// ErrNoSessionMeter is returned for peaks of apps by backends that
+	// cannot meter streams.
+	ErrNoSessionMeter = errors.New("audio backend cannot meter apps")
+)
+
+// appPrefix marks endpoint IDs that stand for an application's streams.
+const appPrefix = "app:"
+
+// AppID returns the endpoint ID app selectors resolve to. It stands for all
+// streams of the app and stays the same across restarts of it.
+func AppID(app string) string {
+	return appPrefix + NormalizeApp(app)
+}
+
+// AppOf returns the app an ID from AppID stands for.
+func AppOf(id string) (string, bool) {
+	return strings.CutPrefix(id, appPrefix)
+}
+
+// NormalizeApp lowercases a process name and strips a .exe extension, so
+// "Discord.exe" and "discord" name the same app.
+func NormalizeApp(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".exe")
+}
+
+// AppSessions returns the streams of app. The error wraps ErrNotRunning if
+// it has none.
+func AppSessions(b SessionBackend, app string) ([]Session, error) {
+	all, err := b.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	app = NormalizeApp(app)
+	var sessions []Session
+	for _, s := range all {
+		if s.App == app {
+			sessions = append(sessions, s)
+		}
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("%s: %w", app, ErrNotRunning)
+	}
+	return sessions, nil
+}
+
+// AppVolume returns the volume of the first stream of app.
+func AppVolume(b SessionBackend, app string) (float32, error) {
+	sessions, err := AppSessions(b, app)
+	if err != nil {
+		return 0, err
+	}
+	return b.SessionVolume(sessions[0].ID)
+}
+
+// SetAppVolume sets the volume of every stream of app.
+func SetAppVolume(b SessionBackend, app string, level float32) error {
+	sessions, err := AppSessions(b, app)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range sessions {
+		errs = append(errs, b.SetSessionVolume(s.ID, level))
+	}
+	return errors.Join(errs...)
+}
+
+// AppMute reports whether every stream of app is muted.
+func AppMute(b SessionBackend, app string) (bool, error) {
+	sessions, err := AppSessions(b, app)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range sessions {
+		muted, err := b.SessionMute(s.ID)
+		if err != nil || !muted {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// AppPeak returns the highest peak among the streams of app. It returns
+// ErrNoSessionMeter if the backend cannot meter streams.
+func AppPeak(b SessionBackend, app string) (float32, error) {
+	if !MetersSessions(b) {
+		return 0, ErrNoSessionMeter
+	}
+	sessions, err := AppSessions(b, app)
+	if err != nil {
+		return 0, err
+	}
+	var peak float32
+	for _, s := range sessions {
+		peak = max(peak, s.Peak)
+	}
+	return peak, nil
+}
+
+// SetAppMute mutes or unmutes every stream of app.
+func SetAppMute(b SessionBackend, app string, muted bool) error {
+	sessions, err := AppSessions(b, app)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range sessions {
+		errs = append(errs, b.SetSessionMute(s.ID, muted))
+	}
+	return errors.Join(errs...)
+}
+
+// resolveApp checks that app is running and returns the endpoint standing
+// for its streams.
+func resolveApp(b Backend, app string) (Device, error) {
+	sb, ok := b.(SessionBackend)
+	if !ok {
+		return Device{}, fmt.Errorf("the %s backend cannot control apps", b.Name())
+	}
+	if _, err := AppSessions(sb, app); err != nil {
+		return Device{}, err
+	}
+	return Device{ID: AppID(app), Name: NormalizeApp(app), Flow: FlowRender, State: StateActive}, nil
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeSessions is a SessionBackend over a fixed set of streams.
+type fakeSessions struct {
+	sessions []Session
+	volumes  map[string]float32
+	muted    map[string]bool
+}
+
+func newFakeSessions() *fakeSessions {
+	return &fakeSessions{
+		sessions: []Session{
+			{ID: "1", App: "discord", Active: true},
+			{ID: "2", App: "firefox"},
+			{ID: "3", App: "firefox", Active: true},
+		},
+		volumes: map[string]float32{"1": 0.5, "2": 0.25, "3": 1},
+		muted:   map[string]bool{"2": true},
+	}
+}
+
+func (f *fakeSessions) Sessions() ([]Session, error) { return f.sessions, nil }
+
+func (f *fakeSessions) SessionVolume(id string) (float32, error) { return f.volumes[id], nil }
+
+func (f *fakeSessions) SetSessionVolume(id string, level float32) error {
+	f.volumes[id] = level
+	return nil
+}
+
+func (f *fakeSessions) SessionMute(id string) (bool, error) { return f.muted[id], nil }
+
+func (f *fakeSessions) SetSessionMute(id string, muted bool) error {
+	f.muted[id] = muted
+	return nil
+}
+
+func (f *fakeSessions) MetersSessions() bool { return true }
+
+func TestAppID(t *testing.T) {
+	id := AppID("Discord.exe")
+	if id != "app:discord" {
+		t.Errorf("Expected app:discord, got %s", id)
+	}
+	if app, ok := AppOf(id); !ok || app != "discord" {
+		t.Errorf("Expected discord, got %q, %v", app, ok)
+	}
+	if _, ok := AppOf("alsa_output.analog"); ok {
+		t.Errorf("Expected endpoint ID not to be an app")
+	}
+}
+
+func TestAppSessions(t *testing.T) {
+	f := newFakeSessions()
+
+	if volume, err := AppVolume(f, "Discord"); err != nil || volume != 0.5 {
+		t.Errorf("Expected volume 0.5, got %v, %v", volume, err)
+	}
+	if err := SetAppVolume(f, "firefox", 0.75); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.volumes["2"] != 0.75 || f.volumes["3"] != 0.75 {
+		t.Errorf("Expected every firefox stream at 0.75, got %v", f.volumes)
+	}
+
+	if muted, _ := AppMute(f, "firefox"); muted {
+		t.Errorf("Expected firefox with one unmuted stream not to be muted")
+	}
+	if err := SetAppMute(f, "firefox", true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if muted, _ := AppMute(f, "firefox"); !muted {
+		t.Errorf("Expected firefox to be muted")
+	}
+
+	if _, err := AppVolume(f, "spotify"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+
+	f.sessions[1].Peak, f.sessions[2].Peak = 0.5, 0.25
+	if peak, err := AppPeak(f, "firefox"); err != nil || peak != 0.5 {
+		t.Errorf("Expected the louder stream's peak 0.5, got %v, %v", peak, err)
+	}
+	// Without peaks every app would look silent.
+	unmetered := struct{ SessionBackend }{f}
+	if _, err := AppPeak(unmetered, "firefox"); !errors.Is(err, ErrNoSessionMeter) {
+		t.Errorf("Expected ErrNoSessionMeter, got %v", err)
+	}
+}
+
+func TestResolveApp(t *testing.T) {
+	b := struct {
+		Backend
+		*fakeSessions
+	}{fakeSessions: newFakeSessions()}
+
+	d, err := Resolve(b, Selector{App: "discord"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.ID != "app:discord" {
+		t.Errorf("Expected app:discord, got %s", d.ID)
+	}
+	if _, err := Resolve(b, Selector{App: "spotify"}); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+	if err := (Selector{App: "discord", Flow: "render"}).Validate(); err == nil {
+		t.Errorf("Expected error for app selector with a flow")
+	}
+}
@@ -0,0 +1,86 @@
+//go:build windows
+
+package wasapi
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// IPolicyConfig is undocumented, but it is what the Sound control panel uses
+// to change default endpoints and has kept its layout since Windows 7.
+var (
+	clsidPolicyConfigClient = ole.NewGUID("{870AF99C-171D-4F9E-AF0D-E63DF40C2BC9}")
+	iidPolicyConfig         = ole.NewGUID("{F8679F50-850A-41CF-9C72-430F290290C8}")
+)
+
+type policyConfig struct {
+	ole.IUnknown
+}
+
+type policyConfigVtbl struct {
+	ole.IUnknownVtbl
+	GetMixFormat          uintptr
+	GetDeviceFormat       uintptr
+	ResetDeviceFormat     uintptr
+	SetDeviceFormat       uintptr
+	GetProcessingPeriod   uintptr
+	SetProcessingPeriod   uintptr
+	GetShareMode          uintptr
+	SetShareMode          uintptr
+	GetPropertyValue      uintptr
+	SetPropertyValue      uintptr
+	SetDefaultEndpoint    uintptr
+	SetEndpointVisibility uintptr
+}
+
+func (pc *policyConfig) VTable() *policyConfigVtbl {
+	return (*policyConfigVtbl)(unsafe.Pointer(pc.RawVTable))
+}
+
+func (pc *policyConfig) SetDefaultEndpoint(deviceID string, role uint32) error {
+	id, err := syscall.UTF16PtrFromString(deviceID)
+	if err != nil {
+		return err
+	}
+	hr, _, _ := syscall.Syscall(
+		pc.VTable().SetDefaultEndpoint,
+		3,
+		uintptr(unsafe.Pointer(pc)),
+		uintptr(unsafe.Pointer(id)),
+		uintptr(role))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// SetDefaultDevice makes deviceID the default endpoint of its flow. The
+// console role also sets the multimedia role, as the Sound control panel
+// does.
+func (b *Backend) SetDefaultDevice(deviceID string, role audio.Role) error {
+	return b.invoke(func() error {
+		unk, err := ole.CreateInstance(clsidPolicyConfigClient, iidPolicyConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create IPolicyConfig: %w", err)
+		}
+		pc := (*policyConfig)(unsafe.Pointer(unk))
+		defer pc.Release()
+
+		roles := []uint32{wca.EConsole, wca.EMultimedia}
+		if role == audio.RoleCommunications {
+			roles = []uint32{wca.ECommunications}
+		}
+		for _, r := range roles {
+			if err := pc.SetDefaultEndpoint(deviceID, r); err != nil {
+				return fmt.Errorf("SetDefaultEndpoint failed: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,215 @@
+//go:build windows
+
+package wasapi
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"fmt"
+	"path/filepath"
+
+	"github.com/moutend/go-wca/pkg/wca"
+	"golang.org/x/sys/windows"
+)
+
+// Sessions lists the audio sessions on all active render endpoints, named
+// after the executable of their process. The system sounds session has no
+// process and is left out.
+func (b *Backend) Sessions() ([]audio.Session, error) {
+	var sessions []audio.Session
+	err := b.invoke(func() error {
+		return b.eachSession(func(id string, asc2 *wca.IAudioSessionControl2) (bool, error) {
+			var pid uint32
+			if err := asc2.GetProcessId(&pid); err != nil || pid == 0 {
+				return false, nil
+			}
+			name, err := processName(pid)
+			if err != nil {
+				return false, nil
+			}
+			var state uint32
+			if err := asc2.GetState(&state); err != nil {
+				return false, fmt.Errorf("GetState failed: %w", err)
+			}
+			sessions = append(sessions, audio.Session{
+				ID:     id,
+				App:    audio.NormalizeApp(name),
+				Active: state == wca.AudioSessionStateActive,
+				Peak:   sessionPeak(asc2),
+			})
+			return false, nil
+		})
+	})
+	return sessions, err
+}
+
+// MetersSessions reports that Sessions fills in peaks.
+func (b *Backend) MetersSessions() bool {
+	return true
+}
+
+// sessionPeak returns the current peak of a session, or 0 if it cannot be
+// metered.
+func sessionPeak(asc2 *wca.IAudioSessionControl2) float32 {
+	var ami *wca.IAudioMeterInformation
+	if err := asc2.PutQueryInterface(wca.IID_IAudioMeterInformation, &ami); err != nil {
+		return 0
+	}
+	defer ami.Release()
+
+	var peak float32
+	if err := ami.GetPeakValue(&peak); err != nil {
+		return 0
+	}
+	return peak
+}
+
+func (b *Backend) SessionVolume(sessionID string) (float32, error) {
+	var level float32
+	err := b.simpleVolume(sessionID, func(sav *wca.ISimpleAudioVolume) error {
+		if err := sav.GetMasterVolume(&level); err != nil {
+			return fmt.Errorf("GetMasterVolume failed: %w", err)
+		}
+		return nil
+	})
+	return level, err
+}
+
+func (b *Backend) SetSessionVolume(sessionID string, level float32) error {
+	return b.simpleVolume(sessionID, func(sav *wca.ISimpleAudioVolume) error {
+		if err := sav.SetMasterVolume(level, nil); err != nil {
+			return fmt.Errorf("SetMasterVolume failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *Backend) SessionMute(sessionID string) (bool, error) {
+	var muted bool
+	err := b.simpleVolume(sessionID, func(sav *wca.ISimpleAudioVolume) error {
+		if err := sav.GetMute(&muted); err != nil {
+			return fmt.Errorf("GetMute failed: %w", err)
+		}
+		return nil
+	})
+	return muted, err
+}
+
+func (b *Backend) SetSessionMute(sessionID string, muted bool) error {
+	return b.simpleVolume(sessionID, func(sav *wca.ISimpleAudioVolume) error {
+		if err := sav.SetMute(muted, nil); err != nil {
+			return fmt.Errorf("SetMute failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// simpleVolume finds the session with the instance identifier sessionID and
+// runs f with its ISimpleAudioVolume on the COM thread.
+func (b *Backend) simpleVolume(sessionID string, f func(sav *wca.ISimpleAudioVolume) error) error {
+	return b.invoke(func() error {
+		found := false
+		err := b.eachSession(func(id string, asc2 *wca.IAudioSessionControl2) (bool, error) {
+			if id != sessionID {
+				return false, nil
+			}
+			found = true
+			var sav *wca.ISimpleAudioVolume
+			if err := asc2.PutQueryInterface(wca.IID_ISimpleAudioVolume, &sav); err != nil {
+				return true, fmt.Errorf("QueryInterface ISimpleAudioVolume failed: %w", err)
+			}
+			defer sav.Release()
+			return true, f(sav)
+		})
+		if err == nil && !found {
+			err = fmt.Errorf("session %s: %w", sessionID, audio.ErrNotRunning)
+		}
+		return err
+	})
+}
+
+// eachSession calls f with the instance identifier and control of every
+// session on the active render endpoints until f reports it is done. It
+// must run on the COM thread.
+func (b *Backend) eachSession(f func(id string, asc2 *wca.IAudioSessionControl2) (done bool, err error)) error {
+	var dc *wca.IMMDeviceCollection
+	if err := b.mmde.EnumAudioEndpoints(wca.ERender, wca.DEVICE_STATE_ACTIVE, &dc); err != nil {
+		return fmt.Errorf("EnumAudioEndpoints failed: %w", err)
+	}
+	defer dc.Release()
+
+	var count uint32
+	if err := dc.GetCount(&count); err != nil {
+		return fmt.Errorf("GetCount failed: %w", err)
+	}
+	for i := uint32(0); i < count; i++ {
+		var mmd *wca.IMMDevice
+		if err := dc.Item(i, &mmd); err != nil {
+			return fmt.Errorf("Item failed: %w", err)
+		}
+		done, err := deviceSessions(mmd, f)
+		mmd.Release()
+		if done || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deviceSessions(mmd *wca.IMMDevice, f func(id string, asc2 *wca.IAudioSessionControl2) (bool, error)) (bool, error) {
+	var asm *wca.IAudioSessionManager2
+	if err := mmd.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &asm); err != nil {
+		return false, fmt.Errorf("Activate IAudioSessionManager2 failed: %w", err)
+	}
+	defer asm.Release()
+
+	var ase *wca.IAudioSessionEnumerator
+	if err := asm.GetSessionEnumerator(&ase); err != nil {
+		return false, fmt.Errorf("GetSessionEnumerator failed: %w", err)
+	}
+	defer ase.Release()
+
+	var count int
+	if err := ase.GetCount(&count); err != nil {
+		return false, fmt.Errorf("GetCount failed: %w", err)
+	}
+	for i := 0; i < count; i++ {
+		var asc *wca.IAudioSessionControl
+		if err := ase.GetSession(i, &asc); err != nil {
+			return false, fmt.Errorf("GetSession failed: %w", err)
+		}
+		var asc2 *wca.IAudioSessionControl2
+		err := asc.PutQueryInterface(wca.IID_IAudioSessionControl2, &asc2)
+		asc.Release()
+		if err != nil {
+			return false, fmt.Errorf("QueryInterface IAudioSessionControl2 failed: %w", err)
+		}
+
+		var id string
+		done := false
+		if err = asc2.GetSessionInstanceIdentifier(&id); err == nil {
+			done, err = f(id, asc2)
+		}
+		asc2.Release()
+		if done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+// processName returns the executable name of a process, such as
+// "Discord.exe".
+func processName(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, windows.MAX_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &size); err != nil {
+		return "", err
+	}
+	return filepath.Base(windows.UTF16ToString(buf[:size])), nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package wasapi
+
+import "desktop-audio-ctrl/pkg/audio"
+
+// New reports audio.ErrUnsupported outside of Windows.
+func New() (audio.Backend, error) {
+	return nil, audio.ErrUnsupported
+}
@@ -0,0 +1,353 @@
+//go:build windows
+
+package wasapi
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// Backend controls endpoints through the Windows Core Audio APIs. All COM
+// calls are funneled through a single OS thread owned by the backend, so
+// callers do not need to initialize COM themselves.
+type Backend struct {
+	calls     chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Only touched from the COM thread.
+	mmde *wca.IMMDeviceEnumerator
+	// notifier is kept referenced so the callback is not collected while
+	// registered with the enumerator.
+	notifier *wca.IMMNotificationClient
+}
+
+// New starts the COM thread and creates the device enumerator.
+func New() (audio.Backend, error) {
+	b := &Backend{
+		calls: make(chan func()),
+		done:  make(chan struct{}),
+	}
+
+	ready := make(chan error)
+	go b.run(ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Backend) run(ready chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		ready <- fmt.Errorf("CoInitializeEx failed: %w", err)
+		return
+	}
+	defer ole.CoUninitialize()
+
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &b.mmde); err != nil {
+		ready <- fmt.Errorf("failed to create IMMDeviceEnumerator: %w", err)
+		return
+	}
+	defer b.mmde.Release()
+
+	ready <- nil
+
+	for {
+		select {
+		case f := <-b.calls:
+			f()
+		case <-b.done:
+			if b.notifier != nil {
+				b.mmde.UnregisterEndpointNotificationCallback(b.notifier)
+				b.notifier = nil
+			}
+			return
+		}
+	}
+}
+
+// invoke runs f on the COM thread and waits for it to finish.
+func (b *Backend) invoke(f func() error) error {
+	errc := make(chan error, 1)
+	select {
+	case b.calls <- func() { errc <- f() }:
+	case <-b.done:
+		return audio.ErrClosed
+	}
+	return <-errc
+}
+
+func (b *Backend) Name() string {
+	return "wasapi"
+}
+
+func (b *Backend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+func (b *Backend) Devices() ([]audio.Device, error) {
+	var devices []audio.Device
+	err := b.invoke(func() error {
+		for _, flow := range []audio.Flow{audio.FlowRender, audio.FlowCapture} {
+			var dc *wca.IMMDeviceCollection
+			if err := b.mmde.EnumAudioEndpoints(dataFlow(flow), wca.DEVICE_STATEMASK_ALL, &dc); err != nil {
+				return fmt.Errorf("EnumAudioEndpoints failed: %w", err)
+			}
+			found, err := collectDevices(dc, flow)
+			dc.Release()
+			if err != nil {
+				return err
+			}
+			devices = append(devices, found...)
+		}
+		return nil
+	})
+	return devices, err
+}
+
+func collectDevices(dc *wca.IMMDeviceCollection, flow audio.Flow) ([]audio.Device, error) {
+	var count uint32
+	if err := dc.GetCount(&count); err != nil {
+		return nil, fmt.Errorf("GetCount failed: %w", err)
+	}
+
+	devices := make([]audio.Device, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var mmd *wca.IMMDevice
+		if err := dc.Item(i, &mmd); err != nil {
+			return nil, fmt.Errorf("Item failed: %w", err)
+		}
+		d, err := describeDevice(mmd)
+		mmd.Release()
+		if err != nil {
+			return nil, err
+		}
+		d.Flow = flow
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+func describeDevice(mmd *wca.IMMDevice) (audio.Device, error) {
+	var d audio.Device
+	if err := mmd.GetId(&d.ID); err != nil {
+		return d, fmt.Errorf("GetId failed: %w", err)
+	}
+
+	var state uint32
+	if err := mmd.GetState(&state); err != nil {
+		return d, fmt.Errorf("GetState failed: %w", err)
+	}
+	switch state {
+	case wca.DEVICE_STATE_ACTIVE:
+		d.State = audio.StateActive
+	case wca.DEVICE_STATE_DISABLED:
+		d.State = audio.StateDisabled
+	case wca.DEVICE_STATE_NOTPRESENT:
+		d.State = audio.StateNotPresent
+	case wca.DEVICE_STATE_UNPLUGGED:
+		d.State = audio.StateUnplugged
+	}
+
+	var ps *wca.IPropertyStore
+	if err := mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
+		return d, fmt.Errorf("OpenPropertyStore failed: %w", err)
+	}
+	defer ps.Release()
+
+	var pv wca.PROPVARIANT
+	if err := ps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return d, fmt.Errorf("GetValue failed: %w", err)
+	}
+	d.Name = pv.String()
+	return d, nil
+}
+
+func (b *Backend) DefaultDevice(flow audio.Flow, role audio.Role) (audio.Device, error) {
+	var d audio.Device
+	err := b.invoke(func() error {
+		var mmd *wca.IMMDevice
+		if err := b.mmde.GetDefaultAudioEndpoint(dataFlow(flow), deviceRole(role), &mmd); err != nil {
+			return fmt.Errorf("GetDefaultAudioEndpoint failed: %w", err)
+		}
+		defer mmd.Release()
+
+		var err error
+		d, err = describeDevice(mmd)
+		d.Flow = flow
+		return err
+	})
+	return d, err
+}
+
+func (b *Backend) Volume(deviceID string) (float32, error) {
+	var level float32
+	err := b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.GetMasterVolumeLevelScalar(&level); err != nil {
+			return fmt.Errorf("GetMasterVolumeLevelScalar failed: %w", err)
+		}
+		return nil
+	})
+	return level, err
+}
+
+func (b *Backend) SetVolume(deviceID string, level float32) error {
+	return b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.SetMasterVolumeLevelScalar(level, nil); err != nil {
+			return fmt.Errorf("SetMasterVolumeLevelScalar failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *Backend) VolumeRange(deviceID string) (float32, float32, error) {
+	var minDB, maxDB, increment float32
+	err := b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.GetVolumeRange(&minDB, &maxDB, &increment); err != nil {
+			return fmt.Errorf("GetVolumeRange failed: %w", err)
+		}
+		return nil
+	})
+	return minDB, maxDB, err
+}
+
+func (b *Backend) VolumeDB(deviceID string) (float32, error) {
+	var db float32
+	err := b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.GetMasterVolumeLevel(&db); err != nil {
+			return fmt.Errorf("GetMasterVolumeLevel failed: %w", err)
+		}
+		return nil
+	})
+	return db, err
+}
+
+func (b *Backend) SetVolumeDB(deviceID string, db float32) error {
+	return b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.SetMasterVolumeLevel(db, nil); err != nil {
+			return fmt.Errorf("SetMasterVolumeLevel failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *Backend) Mute(deviceID string) (bool, error) {
+	var muted bool
+	err := b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.GetMute(&muted); err != nil {
+			return fmt.Errorf("GetMute failed: %w", err)
+		}
+		return nil
+	})
+	return muted, err
+}
+
+func (b *Backend) SetMute(deviceID string, muted bool) error {
+	return b.endpointVolume(deviceID, func(aev *wca.IAudioEndpointVolume) error {
+		if err := aev.SetMute(muted, nil); err != nil {
+			return fmt.Errorf("SetMute failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// Peak reads the endpoint's IAudioMeterInformation, which reports the
+// peak of the samples it processed last.
+func (b *Backend) Peak(deviceID string) (float32, error) {
+	var peak float32
+	err := b.invoke(func() error {
+		var mmd *wca.IMMDevice
+		if err := b.mmde.GetDevice(deviceID, &mmd); err != nil {
+			return fmt.Errorf("GetDevice failed: %w", err)
+		}
+		defer mmd.Release()
+
+		var ami *wca.IAudioMeterInformation
+		if err := mmd.Activate(wca.IID_IAudioMeterInformation, wca.CLSCTX_ALL, nil, &ami); err != nil {
+			return fmt.Errorf("Activate IAudioMeterInformation failed: %w", err)
+		}
+		defer ami.Release()
+
+		if err := ami.GetPeakValue(&peak); err != nil {
+			return fmt.Errorf("GetPeakValue failed: %w", err)
+		}
+		return nil
+	})
+	return peak, err
+}
+
+func (b *Backend) Watch(onChange func(reason string)) error {
+	return b.invoke(func() error {
+		if b.notifier != nil {
+			return fmt.Errorf("already watching devices")
+		}
+		notifier := wca.NewIMMNotificationClient(wca.IMMNotificationClientCallback{
+			OnDefaultDeviceChanged: func(flow wca.EDataFlow, role wca.ERole, deviceID string) error {
+				onChange("default device changed")
+				return nil
+			},
+			OnDeviceAdded: func(deviceID string) error {
+				onChange("device added")
+				return nil
+			},
+			OnDeviceRemoved: func(deviceID string) error {
+				onChange("device removed")
+				return nil
+			},
+			OnDeviceStateChanged: func(deviceID string, state uint64) error {
+				onChange("device state changed")
+				return nil
+			},
+		})
+		if err := b.mmde.RegisterEndpointNotificationCallback(notifier); err != nil {
+			return fmt.Errorf("RegisterEndpointNotificationCallback failed: %w", err)
+		}
+		b.notifier = notifier
+		return nil
+	})
+}
+
+// endpointVolume activates IAudioEndpointVolume for deviceID and runs f with
+// it on the COM thread.
+func (b *Backend) endpointVolume(deviceID string, f func(aev *wca.IAudioEndpointVolume) error) error {
+	return b.invoke(func() error {
+		var mmd *wca.IMMDevice
+		if err := b.mmde.GetDevice(deviceID, &mmd); err != nil {
+			return fmt.Errorf("GetDevice failed: %w", err)
+		}
+		defer mmd.Release()
+
+		var aev *wca.IAudioEndpointVolume
+		if err := mmd.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &aev); err != nil {
+			return fmt.Errorf("Activate IAudioEndpointVolume failed: %w", err)
+		}
+		defer aev.Release()
+
+		return f(aev)
+	})
+}
+
+func dataFlow(flow audio.Flow) uint32 {
+	if flow == audio.FlowCapture {
+		return wca.ECapture
+	}
+	return wca.ERender
+}
+
+func deviceRole(role audio.Role) uint32 {
+	if role == audio.RoleCommunications {
+		return wca.ECommunications
+	}
+	return wca.EConsole
+}
@@ -0,0 +1,128 @@
+package control
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownCombo   = errors.New("unknown combo")
+	ErrNoDevice       = errors.New("combo has no device")
+	ErrUnknownProfile = errors.New("unknown profile")
+
+	ErrUnknownSubsystem = errors.New("unknown log subsystem")
+	ErrInvalidLogLevel  = errors.New("invalid log level")
+)
+
+// ComboStatus is the state of a combo as seen by the host.
+type ComboStatus struct {
+	Combo uint8 `json:"combo"`
+	// Name is the combo's configured name, if any.
+	Name     string `json:"name,omitempty"`
+	DeviceID string `json:"deviceID,omitempty"`
+	Level    int    `json:"level"`
+	Muted    bool   `json:"muted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProfileStatus lists a configured profile.
+type ProfileStatus struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// LogLevel is the level of a logging subsystem.
+type LogLevel struct {
+	Subsystem string `json:"subsystem"`
+	Level     string `json:"level"`
+}
+
+// LinkStatus describes the serial link to the device.
+type LinkStatus struct {
+	Connected bool   `json:"connected"`
+	Port      string `json:"port"`
+}
+
+// Update kinds published on a Hub.
+const (
+	KindEvent   = "event"
+	KindVolume  = "volume"
+	KindMute    = "mute"
+	KindProfile = "profile"
+)
+
+// Update describes something that happened in the daemon.
+type Update struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Combo uint8     `json:"combo"`
+	Level int       `json:"level"`
+	Muted bool      `json:"muted"`
+	// Event is the protocol event type for KindEvent updates.
+	Event string `json:"event,omitempty"`
+	// Profile is the newly active profile for KindProfile updates.
+	Profile string `json:"profile,omitempty"`
+}
+
+// Controller is the surface external interfaces use to drive combos.
+type Controller interface {
+	Combos() ([]ComboStatus, error)
+	Get(combo uint8) (ComboStatus, error)
+	SetLevel(combo uint8, level int) (ComboStatus, error)
+	SetMute(combo uint8, muted bool) (ComboStatus, error)
+	ToggleMute(combo uint8) (ComboStatus, error)
+
+	Profiles() ([]ProfileStatus, error)
+	SwitchProfile(name string) ([]ProfileStatus, error)
+
+	LogLevels() ([]LogLevel, error)
+	SetLogLevel(subsystem, level string) ([]LogLevel, error)
+}
+
+// Hub fans updates out to any number of subscribers. Slow subscribers miss
+// updates rather than blocking the publisher.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Update]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Update]struct{})}
+}
+
+// Publish sends u to all current subscribers.
+func (h *Hub) Publish(u Update) {
+	if u.Time.IsZero() {
+		u.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all future updates and a function
+// that ends the subscription and closes the channel.
+func (h *Hub) Subscribe() (<-chan Update, func()) {
+	ch := make(chan Update, 64)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
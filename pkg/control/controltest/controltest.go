@@ -0,0 +1,151 @@
+// Package controltest provides an in-memory control.Controller for the tests
+// of the control interfaces.
+package controltest
+
+import (
+	"desktop-audio-ctrl/pkg/control"
+	"slices"
+	"sync"
+)
+
+// Controller keeps combo levels, the active profile and the log level of the
+// "serial" subsystem in memory.
+type Controller struct {
+	mu      sync.Mutex
+	levels  map[uint8]int
+	muted   map[uint8]bool
+	profile string
+	serial  string
+}
+
+// New returns a controller with combo 0 at 10 and combo 1 at 20, the profiles
+// "gaming" and "meeting" with "gaming" active, and "serial" logging at info.
+func New() *Controller {
+	return &Controller{
+		levels:  map[uint8]int{0: 10, 1: 20},
+		muted:   map[uint8]bool{},
+		profile: "gaming",
+		serial:  "info",
+	}
+}
+
+// Level returns the level of a combo and whether the combo exists.
+func (c *Controller) Level(combo uint8) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	level, ok := c.levels[combo]
+	return level, ok
+}
+
+// Muted reports whether a combo is muted.
+func (c *Controller) Muted(combo uint8) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.muted[combo]
+}
+
+// RemoveCombo makes a combo unknown, as if it was removed from the config.
+func (c *Controller) RemoveCombo(combo uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.levels, combo)
+	delete(c.muted, combo)
+}
+
+func (c *Controller) status(combo uint8) (control.ComboStatus, error) {
+	level, ok := c.levels[combo]
+	if !ok {
+		return control.ComboStatus{}, control.ErrUnknownCombo
+	}
+	return control.ComboStatus{Combo: combo, Level: level, Muted: c.muted[combo]}, nil
+}
+
+func (c *Controller) Combos() ([]control.ComboStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var statuses []control.ComboStatus
+	for combo := range c.levels {
+		s, _ := c.status(combo)
+		statuses = append(statuses, s)
+	}
+	slices.SortFunc(statuses, func(a, b control.ComboStatus) int { return int(a.Combo) - int(b.Combo) })
+	return statuses, nil
+}
+
+func (c *Controller) Get(combo uint8) (control.ComboStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status(combo)
+}
+
+func (c *Controller) SetLevel(combo uint8, level int) (control.ComboStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.status(combo); err != nil {
+		return control.ComboStatus{}, err
+	}
+	c.levels[combo] = level
+	return c.status(combo)
+}
+
+func (c *Controller) SetMute(combo uint8, muted bool) (control.ComboStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.status(combo); err != nil {
+		return control.ComboStatus{}, err
+	}
+	c.muted[combo] = muted
+	return c.status(combo)
+}
+
+func (c *Controller) ToggleMute(combo uint8) (control.ComboStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.status(combo); err != nil {
+		return control.ComboStatus{}, err
+	}
+	c.muted[combo] = !c.muted[combo]
+	return c.status(combo)
+}
+
+func (c *Controller) Profiles() ([]control.ProfileStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var profiles []control.ProfileStatus
+	for _, name := range []string{"gaming", "meeting"} {
+		profiles = append(profiles, control.ProfileStatus{Name: name, Active: name == c.profile})
+	}
+	return profiles, nil
+}
+
+func (c *Controller) SwitchProfile(name string) ([]control.ProfileStatus, error) {
+	c.mu.Lock()
+	if name != "gaming" && name != "meeting" {
+		c.mu.Unlock()
+		return nil, control.ErrUnknownProfile
+	}
+	c.profile = name
+	c.mu.Unlock()
+	return c.Profiles()
+}
+
+func (c *Controller) LogLevels() ([]control.LogLevel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return []control.LogLevel{{Subsystem: "serial", Level: c.serial}}, nil
+}
+
+func (c *Controller) SetLogLevel(subsystem, level string) ([]control.LogLevel, error) {
+	c.mu.Lock()
+	if subsystem != "serial" {
+		c.mu.Unlock()
+		return nil, control.ErrUnknownSubsystem
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, level) {
+		c.mu.Unlock()
+		return nil, control.ErrInvalidLogLevel
+	}
+	c.serial = level
+	c.mu.Unlock()
+	return c.LogLevels()
+}
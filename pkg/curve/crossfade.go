@@ -0,0 +1,55 @@
+package curve
+
+import (
+	"fmt"
+	"math"
+)
+
+// Crossfade laws.
+const (
+	// LawLinear fades the levels linearly, dipping to half of each in the
+	// middle.
+	LawLinear = "linear"
+	// LawConstantPower keeps the combined power constant, so the middle
+	// sounds as loud as either end.
+	LawConstantPower = "constantPower"
+)
+
+// ValidateLaw checks a crossfade law. Empty means LawLinear.
+func ValidateLaw(law string) error {
+	switch law {
+	case "", LawLinear, LawConstantPower:
+		return nil
+	default:
+		return fmt.Errorf("unknown crossfade law %q", law)
+	}
+}
+
+// Crossfade returns the positions of both sides for a knob position: knob 0
+// is all a, Steps all b.
+func Crossfade(law string, knob int) (a, b int) {
+	x := float64(min(max(knob, 0), Steps)) / Steps
+	var ga, gb float64
+	if law == LawConstantPower {
+		ga, gb = math.Cos(x*math.Pi/2), math.Sin(x*math.Pi/2)
+	} else {
+		ga, gb = 1-x, x
+	}
+	return int(math.Round(ga * Steps)), int(math.Round(gb * Steps))
+}
+
+// Balance returns the knob position for the positions of both sides, the
+// inverse of Crossfade. With both sides silent it is the middle.
+func Balance(law string, a, b int) int {
+	if a <= 0 && b <= 0 {
+		return Steps / 2
+	}
+	fa, fb := float64(max(a, 0)), float64(max(b, 0))
+	var x float64
+	if law == LawConstantPower {
+		x = math.Atan2(fb, fa) * 2 / math.Pi
+	} else {
+		x = fb / (fa + fb)
+	}
+	return int(math.Round(x * Steps))
+}
@@ -0,0 +1,194 @@
+package curve
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Steps is the number of knob positions above zero; the knob reports
+// positions 0 through Steps.
+const Steps = 100
+
+// Curve kinds.
+const (
+	Linear = "linear"
+	Log    = "log"
+	DB     = "db"
+	Custom = "custom"
+)
+
+// logSteepness shapes the log curve so that it spans roughly 60 dB, which
+// is close to how loudness is perceived.
+const logSteepness = 6.907755278982137 // ln(1000)
+
+// Point maps a knob position to a volume, both in percent.
+type Point struct {
+	Knob  float64 `yaml:"knob"`
+	Level float64 `yaml:"level"`
+}
+
+// Spec is the configured shape of a combo's volume curve.
+type Spec struct {
+	// Curve is one of linear, log, db or custom. Empty means linear.
+	Curve string `yaml:"curve,omitempty"`
+	// Min and Max bound the curve in percent of the endpoint volume, or in
+	// dB for db curves. They default to the full range.
+	Min *float64 `yaml:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty"`
+	// Points define a custom curve. Positions in between are interpolated
+	// linearly.
+	Points []Point `yaml:"points,omitempty"`
+}
+
+func (s *Spec) kind() string {
+	if s.Curve == "" {
+		return Linear
+	}
+	return s.Curve
+}
+
+// Decibel reports whether the curve produces dB values rather than scalar
+// volume levels.
+func (s *Spec) Decibel() bool {
+	return s.kind() == DB
+}
+
+// Validate checks the spec without knowing the endpoint's dB range.
+func (s *Spec) Validate() error {
+	switch s.kind() {
+	case Linear, Log:
+		lo, hi := s.bounds(0, 100)
+		if lo < 0 || hi > 100 {
+			return fmt.Errorf("min and max must be within 0-100, got %g-%g", lo, hi)
+		}
+		if lo >= hi {
+			return fmt.Errorf("min must be below max, got %g-%g", lo, hi)
+		}
+	case DB:
+		lo, hi := s.bounds(math.Inf(-1), math.Inf(1))
+		if lo >= hi {
+			return fmt.Errorf("min must be below max, got %g-%g", lo, hi)
+		}
+	case Custom:
+		return s.validatePoints()
+	default:
+		return fmt.Errorf("unknown curve %q", s.Curve)
+	}
+	if len(s.Points) > 0 {
+		return errors.New("points require curve: custom")
+	}
+	return nil
+}
+
+func (s *Spec) validatePoints() error {
+	if s.Min != nil || s.Max != nil {
+		return errors.New("min and max cannot be combined with a custom curve")
+	}
+	if len(s.Points) < 2 {
+		return errors.New("custom curve needs at least two points")
+	}
+	first, last := s.Points[0], s.Points[len(s.Points)-1]
+	if first.Knob != 0 || last.Knob != 100 {
+		return fmt.Errorf("custom curve must start at knob 0 and end at knob 100, got %g-%g", first.Knob, last.Knob)
+	}
+	for i, p := range s.Points {
+		if p.Level < 0 || p.Level > 100 {
+			return fmt.Errorf("point %d: level must be within 0-100, got %g", i, p.Level)
+		}
+		// Both coordinates must increase so every level maps back to
+		// exactly one knob position.
+		if i > 0 && (p.Knob <= s.Points[i-1].Knob || p.Level <= s.Points[i-1].Level) {
+			return fmt.Errorf("point %d: knob and level must increase", i)
+		}
+	}
+	return nil
+}
+
+func (s *Spec) bounds(lo, hi float64) (float64, float64) {
+	if s.Min != nil {
+		lo = *s.Min
+	}
+	if s.Max != nil {
+		hi = *s.Max
+	}
+	return lo, hi
+}
+
+// Build returns the curve for an endpoint. minDB and maxDB are the
+// endpoint's volume range and are only used by db curves, whose bounds
+// default to and are clamped to it.
+func (s *Spec) Build(minDB, maxDB float64) Curve {
+	c := Curve{kind: s.kind()}
+	switch c.kind {
+	case Linear, Log:
+		lo, hi := s.bounds(0, 100)
+		c.lo, c.hi = lo/100, hi/100
+	case DB:
+		c.lo, c.hi = s.bounds(minDB, maxDB)
+		c.lo = math.Max(c.lo, minDB)
+		c.hi = math.Min(c.hi, maxDB)
+	case Custom:
+		c.points = s.Points
+	}
+	return c
+}
+
+// Curve maps knob positions to volume levels and back.
+type Curve struct {
+	kind   string
+	lo, hi float64
+	points []Point
+}
+
+// Level returns the volume for a knob position: a scalar between 0 and 1,
+// or dB for db curves.
+func (c Curve) Level(position int) float64 {
+	x := float64(min(max(position, 0), Steps)) / Steps
+	switch c.kind {
+	case Log:
+		t := (math.Exp(logSteepness*x) - 1) / (math.Exp(logSteepness) - 1)
+		return c.lo + t*(c.hi-c.lo)
+	case Custom:
+		return interpolate(c.points, x*100, func(p Point) (float64, float64) { return p.Knob, p.Level }) / 100
+	default:
+		return c.lo + x*(c.hi-c.lo)
+	}
+}
+
+// Position returns the knob position for a volume level. Levels outside
+// the curve's range map to the nearest end, and Position(Level(p)) == p
+// for every position.
+func (c Curve) Position(level float64) int {
+	var x float64
+	switch c.kind {
+	case Log:
+		t := (level - c.lo) / (c.hi - c.lo)
+		x = math.Log1p(max(t, 0)*(math.Exp(logSteepness)-1)) / logSteepness
+	case Custom:
+		x = interpolate(c.points, level*100, func(p Point) (float64, float64) { return p.Level, p.Knob }) / 100
+	default:
+		x = (level - c.lo) / (c.hi - c.lo)
+	}
+	if math.IsNaN(x) {
+		x = 0
+	}
+	return int(math.Round(min(max(x, 0), 1) * Steps))
+}
+
+// interpolate evaluates the piecewise linear function through points at x,
+// using axes to pick which coordinate is the input.
+func interpolate(points []Point, x float64, axes func(Point) (float64, float64)) float64 {
+	x0, y0 := axes(points[0])
+	if x <= x0 {
+		return y0
+	}
+	for _, p := range points[1:] {
+		x1, y1 := axes(p)
+		if x <= x1 {
+			return y0 + (x-x0)/(x1-x0)*(y1-y0)
+		}
+		x0, y0 = x1, y1
+	}
+	return y0
+}
@@ -0,0 +1,131 @@
+package curve
+
+import (
+	"math"
+	"testing"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         Spec
+		minDB, maxDB float64
+	}{
+		{"linear", Spec{}, 0, 0},
+		{"linear range", Spec{Min: ptr(20), Max: ptr(60)}, 0, 0},
+		{"log", Spec{Curve: Log}, 0, 0},
+		{"log range", Spec{Curve: Log, Min: ptr(5), Max: ptr(80)}, 0, 0},
+		{"db", Spec{Curve: DB}, -65.25, 0},
+		{"db range", Spec{Curve: DB, Min: ptr(-40), Max: ptr(-6)}, -96, 0},
+		{"custom", Spec{Curve: Custom, Points: []Point{{0, 0}, {50, 10}, {80, 40}, {100, 100}}}, 0, 0},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.Validate(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		c := tt.spec.Build(tt.minDB, tt.maxDB)
+		for p := 0; p <= Steps; p++ {
+			// Backends store levels as float32, and PulseAudio quantizes
+			// them further to 1/0x10000.
+			level := float64(float32(c.Level(p)))
+			if !tt.spec.Decibel() {
+				level = math.Round(level*0x10000) / 0x10000
+			}
+			if got := c.Position(level); got != p {
+				t.Errorf("%s: expected position %d to round-trip, got %d (level %v)", tt.name, p, got, level)
+			}
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	c := (&Spec{Min: ptr(20), Max: ptr(60)}).Build(0, 0)
+	if got := c.Level(0); got != 0.2 {
+		t.Errorf("Expected level 0.2 at position 0, got %v", got)
+	}
+	if got := c.Level(100); got != 0.6 {
+		t.Errorf("Expected level 0.6 at position 100, got %v", got)
+	}
+	if got := c.Position(0.9); got != 100 {
+		t.Errorf("Expected levels above max to map to 100, got %d", got)
+	}
+	if got := (&Spec{Curve: DB}).Build(-60, 0).Position(math.Inf(-1)); got != 0 {
+		t.Errorf("Expected silence to map to 0, got %d", got)
+	}
+
+	log := (&Spec{Curve: Log}).Build(0, 0)
+	if got := log.Level(50); got < 0.02 || got > 0.04 {
+		t.Errorf("Expected log curve to be around -30 dB at half way, got %v", got)
+	}
+
+	db := (&Spec{Curve: DB, Min: ptr(-120), Max: ptr(10)}).Build(-65.25, 0)
+	if db.Level(0) != -65.25 || db.Level(100) != 0 {
+		t.Errorf("Expected db curve clamped to the endpoint range, got %v-%v", db.Level(0), db.Level(100))
+	}
+
+	custom := (&Spec{Curve: Custom, Points: []Point{{0, 0}, {50, 10}, {100, 100}}}).Build(0, 0)
+	if got := custom.Level(25); math.Abs(got-0.05) > 1e-9 {
+		t.Errorf("Expected custom curve to interpolate to 0.05, got %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+	}{
+		{"unknown", Spec{Curve: "cubic"}},
+		{"out of range", Spec{Max: ptr(120)}},
+		{"inverted", Spec{Curve: Log, Min: ptr(50), Max: ptr(10)}},
+		{"db inverted", Spec{Curve: DB, Min: ptr(0), Max: ptr(-10)}},
+		{"points without custom", Spec{Points: []Point{{0, 0}, {100, 100}}}},
+		{"custom with min", Spec{Curve: Custom, Min: ptr(10), Points: []Point{{0, 0}, {100, 100}}}},
+		{"custom too short", Spec{Curve: Custom, Points: []Point{{0, 0}}}},
+		{"custom not covering", Spec{Curve: Custom, Points: []Point{{10, 0}, {100, 100}}}},
+		{"custom flat", Spec{Curve: Custom, Points: []Point{{0, 0}, {50, 50}, {70, 50}, {100, 100}}}},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.Validate(); err == nil {
+			t.Errorf("%s: expected validation error", tt.name)
+		}
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	for _, tt := range []struct {
+		law        string
+		knob, a, b int
+	}{
+		{LawLinear, 0, 100, 0},
+		{LawLinear, 50, 50, 50},
+		{LawLinear, 75, 25, 75},
+		{LawConstantPower, 0, 100, 0},
+		{LawConstantPower, 50, 71, 71},
+		{LawConstantPower, 100, 0, 100},
+	} {
+		a, b := Crossfade(tt.law, tt.knob)
+		if a != tt.a || b != tt.b {
+			t.Errorf("%s: expected %d/%d at knob %d, got %d/%d", tt.law, tt.a, tt.b, tt.knob, a, b)
+		}
+	}
+
+	for _, law := range []string{LawLinear, LawConstantPower} {
+		for knob := 0; knob <= Steps; knob++ {
+			a, b := Crossfade(law, knob)
+			if got := Balance(law, a, b); got != knob {
+				t.Errorf("%s: expected knob %d to round-trip, got %d", law, knob, got)
+			}
+		}
+	}
+	if got := Balance(LawLinear, 0, 0); got != 50 {
+		t.Errorf("Expected silence to be centered, got %d", got)
+	}
+
+	if ValidateLaw("cubic") == nil {
+		t.Errorf("Expected unknown law to be rejected")
+	}
+}
@@ -0,0 +1,134 @@
+// Package focus picks the application a focus combo follows from the
+// streams the audio backend reports.
+package focus
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"fmt"
+	"time"
+)
+
+// Modes of following.
+const (
+	// Recent follows the app that most recently started playing.
+	Recent = "recent"
+	// Loudest follows the playing app with the highest peak, falling back
+	// to Recent while no stream reports a peak.
+	Loudest = "loudest"
+)
+
+const (
+	// Hold is how long another app has to stay the loudest before a
+	// Loudest tracker switches to it, so short sounds do not steal focus.
+	Hold = time.Second
+	// Silence is the peak below which a stream counts as quiet.
+	Silence = 0.01
+)
+
+// ValidateMode checks that mode is "", Recent or Loudest.
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", Recent, Loudest:
+		return nil
+	}
+	return fmt.Errorf("unknown follow mode %q, expected %s or %s", mode, Recent, Loudest)
+}
+
+// Tracker remembers when apps started playing and which one is followed.
+// It is not safe for concurrent use.
+type Tracker struct {
+	mode    string
+	started map[string]time.Time
+	current string
+
+	// candidate is the loudest app while it waits out Hold.
+	candidate      string
+	candidateSince time.Time
+}
+
+func NewTracker(mode string) *Tracker {
+	return &Tracker{mode: mode, started: make(map[string]time.Time)}
+}
+
+// Current returns the followed app, or "" until one has played.
+func (t *Tracker) Current() string {
+	return t.current
+}
+
+// Update takes the streams seen at now and returns the followed app and
+// whether it changed. When nothing plays the last app is kept, so the knob
+// still controls it.
+func (t *Tracker) Update(sessions []audio.Session, now time.Time) (string, bool) {
+	playing := make(map[string]float32)
+	for _, s := range sessions {
+		if s.Active {
+			playing[s.App] = max(playing[s.App], s.Peak)
+		}
+	}
+	for app := range playing {
+		if _, ok := t.started[app]; !ok {
+			t.started[app] = now
+		}
+	}
+	for app := range t.started {
+		if _, ok := playing[app]; !ok {
+			delete(t.started, app)
+		}
+	}
+
+	next := t.recent()
+	if t.mode == Loudest {
+		if loud, ok := t.loudest(playing, now); ok {
+			next = loud
+		}
+	}
+	if next == "" || next == t.current {
+		return t.current, false
+	}
+	t.current = next
+	return next, true
+}
+
+// recent returns the playing app that started last, or "".
+func (t *Tracker) recent() string {
+	var app string
+	var latest time.Time
+	for a, started := range t.started {
+		// Break ties by name so polls starting several apps at once
+		// always pick the same one.
+		if app == "" || started.After(latest) || started.Equal(latest) && a < app {
+			app, latest = a, started
+		}
+	}
+	return app
+}
+
+// loudest returns the app to follow by peak, or false if no app is above
+// Silence.
+func (t *Tracker) loudest(playing map[string]float32, now time.Time) (string, bool) {
+	var app string
+	var peak float32
+	for a, p := range playing {
+		if p > peak || p == peak && a < app {
+			app, peak = a, p
+		}
+	}
+	if peak < Silence {
+		t.candidate = ""
+		return "", false
+	}
+
+	// Switch right away if the followed app went quiet.
+	if playing[t.current] < Silence || app == t.current {
+		t.candidate = ""
+		return app, true
+	}
+	if app != t.candidate {
+		t.candidate, t.candidateSince = app, now
+	}
+	if now.Sub(t.candidateSince) >= Hold {
+		t.candidate = ""
+		return app, true
+	}
+	return t.current, true
+}
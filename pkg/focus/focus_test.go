@@ -0,0 +1,87 @@
+package focus
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"testing"
+	"time"
+)
+
+func playing(peaks map[string]float32) []audio.Session {
+	var sessions []audio.Session
+	for app, peak := range peaks {
+		sessions = append(sessions, audio.Session{ID: app, App: app, Active: true, Peak: peak})
+	}
+	return sessions
+}
+
+func TestRecent(t *testing.T) {
+	tr := NewTracker(Recent)
+	start := time.Now()
+
+	if app, changed := tr.Update(nil, start); app != "" || changed {
+		t.Errorf("Expected no app before anything played, got %q, %v", app, changed)
+	}
+	if app, changed := tr.Update(playing(map[string]float32{"discord": 0}), start); app != "discord" || !changed {
+		t.Errorf("Expected discord, got %q, %v", app, changed)
+	}
+	if app, changed := tr.Update(playing(map[string]float32{"discord": 0, "spotify": 0}), start.Add(time.Second)); app != "spotify" || !changed {
+		t.Errorf("Expected spotify to take focus, got %q, %v", app, changed)
+	}
+	if app, changed := tr.Update(playing(map[string]float32{"discord": 0}), start.Add(2*time.Second)); app != "discord" || !changed {
+		t.Errorf("Expected focus back on discord when spotify stops, got %q, %v", app, changed)
+	}
+	if app, changed := tr.Update(nil, start.Add(3*time.Second)); app != "discord" || changed {
+		t.Errorf("Expected discord to be kept when nothing plays, got %q, %v", app, changed)
+	}
+	if tr.Current() != "discord" {
+		t.Errorf("Expected current discord, got %q", tr.Current())
+	}
+}
+
+func TestLoudest(t *testing.T) {
+	tr := NewTracker(Loudest)
+	start := time.Now()
+
+	tr.Update(playing(map[string]float32{"discord": 0.2, "spotify": 0.5}), start)
+	if tr.Current() != "spotify" {
+		t.Errorf("Expected spotify, got %q", tr.Current())
+	}
+
+	// A louder app only takes over after Hold.
+	tr.Update(playing(map[string]float32{"discord": 0.8, "spotify": 0.5}), start.Add(time.Second))
+	if tr.Current() != "spotify" {
+		t.Errorf("Expected spotify to keep focus, got %q", tr.Current())
+	}
+	app, changed := tr.Update(playing(map[string]float32{"discord": 0.8, "spotify": 0.5}), start.Add(time.Second+Hold))
+	if app != "discord" || !changed {
+		t.Errorf("Expected discord after Hold, got %q, %v", app, changed)
+	}
+
+	// A quiet followed app gives up focus right away.
+	app, _ = tr.Update(playing(map[string]float32{"discord": 0, "spotify": 0.3}), start.Add(3*time.Second))
+	if app != "spotify" {
+		t.Errorf("Expected spotify once discord went quiet, got %q", app)
+	}
+}
+
+func TestLoudestWithoutPeaks(t *testing.T) {
+	tr := NewTracker(Loudest)
+	start := time.Now()
+
+	tr.Update(playing(map[string]float32{"discord": 0}), start)
+	app, _ := tr.Update(playing(map[string]float32{"discord": 0, "spotify": 0}), start.Add(time.Second))
+	if app != "spotify" {
+		t.Errorf("Expected the most recent app without peaks, got %q", app)
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	for _, mode := range []string{"", Recent, Loudest} {
+		if err := ValidateMode(mode); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", mode, err)
+		}
+	}
+	if err := ValidateMode("newest"); err == nil {
+		t.Errorf("Expected error for unknown mode")
+	}
+}
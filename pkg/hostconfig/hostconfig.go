@@ -0,0 +1,730 @@
+package hostconfig
+
+import (
+	"cmp"
+	"desktop-audio-ctrl/pkg/audio"
+	"desktop-audio-ctrl/pkg/curve"
+	"desktop-audio-ctrl/pkg/focus"
+	"desktop-audio-ctrl/pkg/logging"
+	"desktop-audio-ctrl/pkg/relative"
+	"desktop-audio-ctrl/protocol"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type ComboConfig struct {
+	Combo uint8 `yaml:"combo"`
+	// Name is shown on the combo's screen instead of the firmware default.
+	// It has to fit a LABEL frame, see protocol.MAX_LABEL_LENGTH.
+	Name          string `yaml:"name,omitempty"`
+	DeviceID      string `yaml:"deviceID,omitempty"`
+	DeviceName    string `yaml:"deviceName,omitempty"`
+	DevicePattern string `yaml:"devicePattern,omitempty"`
+	// App binds the combo to the audio streams of an application by process
+	// name, such as "discord", in place of an endpoint.
+	App string `yaml:"app,omitempty"`
+	// Flow limits the selector to "render" or "capture" endpoints. With
+	// deviceName default it picks the default microphone.
+	Flow string `yaml:"flow,omitempty"`
+	// Target is TargetAudio for an audio endpoint or TargetOSC for a combo
+	// only mirrored over OSC, which takes no device selector.
+	Target string `yaml:"target,omitempty"`
+	// OSCLevel and OSCMute replace the OSC addresses of this combo.
+	OSCLevel string `yaml:"oscLevel,omitempty"`
+	OSCMute  string `yaml:"oscMute,omitempty"`
+
+	// Targets gang several endpoints on one knob in place of a device
+	// selector. The screen shows the level of the target at index
+	// Reference.
+	Targets   []TargetConfig `yaml:"targets,omitempty"`
+	Reference int            `yaml:"reference,omitempty"`
+	// Type TypeCrossfade fades between exactly two targets under Law, see
+	// curve.Crossfade, instead of moving them together. TypeSwitcher turns
+	// through the targets and makes the shown one the default endpoint for
+	// Role on click. TypeFocus takes no selector and controls the app
+	// picked by Follow, see focus.Recent and focus.Loudest.
+	Type   string `yaml:"type,omitempty"`
+	Law    string `yaml:"law,omitempty"`
+	Role   string `yaml:"role,omitempty"`
+	Follow string `yaml:"follow,omitempty"`
+
+	// Gesture actions, see ParseAction. Without one the knob's level is
+	// applied as for turns, except that clicks on capture combos toggle
+	// mute.
+	OnClick       string `yaml:"onClick,omitempty"`
+	OnDoubleClick string `yaml:"onDoubleClick,omitempty"`
+
+	// Volume curve mapping knob positions to endpoint volumes.
+	curve.Spec `yaml:",inline"`
+	// Turns selects whether turns are applied by the firmware or stepped
+	// on the host.
+	Turns relative.Spec `yaml:",inline"`
+}
+
+// Combo targets.
+const (
+	TargetAudio = "audio"
+	TargetOSC   = "osc"
+)
+
+// Combo types.
+const (
+	TypeLevel     = "level"
+	TypeCrossfade = "crossfade"
+	TypeSwitcher  = "switcher"
+	TypeFocus     = "focus"
+)
+
+// Default roles of switcher combos.
+const (
+	RoleConsole        = "console"
+	RoleCommunications = "communications"
+)
+
+// IsCrossfade reports whether the combo fades between two targets.
+func (c *ComboConfig) IsCrossfade() bool {
+	return c.Type == TypeCrossfade
+}
+
+// IsSwitcher reports whether the combo picks the default endpoint instead
+// of setting a level.
+func (c *ComboConfig) IsSwitcher() bool {
+	return c.Type == TypeSwitcher
+}
+
+// IsFocus reports whether the combo follows the app that is playing.
+func (c *ComboConfig) IsFocus() bool {
+	return c.Type == TypeFocus
+}
+
+// DefaultRole returns the role a switcher sets the default endpoint for.
+func (c *ComboConfig) DefaultRole() audio.Role {
+	if c.Role == RoleCommunications {
+		return audio.RoleCommunications
+	}
+	return audio.RoleConsole
+}
+
+// IsOSC reports whether the combo is bound to OSC instead of an audio
+// endpoint.
+func (c *ComboConfig) IsOSC() bool {
+	return c.Target == TargetOSC
+}
+
+func (c *ComboConfig) Selector() audio.Selector {
+	return audio.Selector{
+		ID:      c.DeviceID,
+		Name:    c.DeviceName,
+		Pattern: c.DevicePattern,
+		App:     c.App,
+		Flow:    c.Flow,
+	}
+}
+
+// Gang returns the endpoints the combo moves: its targets, or a single one
+// from its own selector.
+func (c *ComboConfig) Gang() []TargetConfig {
+	if len(c.Targets) > 0 {
+		return c.Targets
+	}
+	return []TargetConfig{{DeviceID: c.DeviceID, DeviceName: c.DeviceName, DevicePattern: c.DevicePattern, App: c.App, Flow: c.Flow}}
+}
+
+// HasApp reports whether any of the combo's targets is an application.
+// Focus combos always control one.
+func (c *ComboConfig) HasApp() bool {
+	if c.IsOSC() {
+		return false
+	}
+	if c.IsFocus() {
+		return true
+	}
+	for _, t := range c.Gang() {
+		if t.App != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCapture reports whether all of the combo's endpoints are capture
+// endpoints.
+func (c *ComboConfig) IsCapture() bool {
+	if c.IsOSC() {
+		return false
+	}
+	for _, t := range c.Gang() {
+		if t.Flow != audio.FlowCapture.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// ClickAction returns the action of a click: OnClick, or toggling mute on
+// capture combos other than switchers.
+func (c *ComboConfig) ClickAction() string {
+	if c.OnClick == "" && c.IsCapture() && !c.IsSwitcher() {
+		return ActionToggleMuteName
+	}
+	return c.OnClick
+}
+
+// Positions returns where each target of the combo goes for a knob
+// position.
+func (c *ComboConfig) Positions(knob int) []int {
+	gang := c.Gang()
+	if c.IsCrossfade() {
+		a, b := curve.Crossfade(c.Law, knob)
+		return []int{gang[0].Position(a), gang[1].Position(b)}
+	}
+	positions := make([]int, len(gang))
+	for i, t := range gang {
+		positions[i] = t.Position(knob)
+	}
+	return positions
+}
+
+// LevelTargets returns the targets the knob position is read back from.
+func (c *ComboConfig) LevelTargets() []int {
+	if c.IsCrossfade() {
+		return []int{0, 1}
+	}
+	return []int{c.Reference}
+}
+
+// Knob returns the knob position for the positions of the targets, the
+// inverse of Positions. Only the positions of LevelTargets are used.
+func (c *ComboConfig) Knob(positions []int) int {
+	gang := c.Gang()
+	if c.IsCrossfade() {
+		return curve.Balance(c.Law, gang[0].Knob(positions[0]), gang[1].Knob(positions[1]))
+	}
+	return gang[c.Reference].Knob(positions[c.Reference])
+}
+
+// KnobNear is Knob, but returns last if it puts the LevelTargets at
+// positions. Targets that are limited or scaled map several knob positions
+// onto one, and only last tells which of them the knob is at, so reading
+// it back does not make the knob jump. last is ignored outside 0-100.
+func (c *ComboConfig) KnobNear(positions []int, last int) int {
+	if last >= 0 && last <= curve.Steps {
+		at := c.Positions(last)
+		if !slices.ContainsFunc(c.LevelTargets(), func(i int) bool { return at[i] != positions[i] }) {
+			return last
+		}
+	}
+	return c.Knob(positions)
+}
+
+// TargetConfig is one endpoint of a ganged combo.
+type TargetConfig struct {
+	DeviceID      string `yaml:"deviceID,omitempty"`
+	DeviceName    string `yaml:"deviceName,omitempty"`
+	DevicePattern string `yaml:"devicePattern,omitempty"`
+	App           string `yaml:"app,omitempty"`
+	Flow          string `yaml:"flow,omitempty"`
+	// Scale and Offset map the knob position onto the target's own
+	// position as position*Scale+Offset, limited to 0-100. Scale defaults
+	// to 1.
+	Scale  *float64 `yaml:"scale,omitempty"`
+	Offset float64  `yaml:"offset,omitempty"`
+}
+
+func (t *TargetConfig) Selector() audio.Selector {
+	return audio.Selector{
+		ID:      t.DeviceID,
+		Name:    t.DeviceName,
+		Pattern: t.DevicePattern,
+		App:     t.App,
+		Flow:    t.Flow,
+	}
+}
+
+func (t *TargetConfig) scale() float64 {
+	if t.Scale == nil {
+		return 1
+	}
+	return *t.Scale
+}
+
+// Position maps a knob position onto the target.
+func (t *TargetConfig) Position(knob int) int {
+	p := math.Round(float64(knob)*t.scale() + t.Offset)
+	return int(min(max(p, 0), curve.Steps))
+}
+
+// Knob maps the target's position back onto the knob.
+func (t *TargetConfig) Knob(position int) int {
+	k := math.Round((float64(position) - t.Offset) / t.scale())
+	return int(min(max(k, 0), curve.Steps))
+}
+
+// Profile is a named set of combos that replaces the base combos with the
+// same number while it is active.
+type Profile struct {
+	Name   string        `yaml:"name"`
+	Combos []ComboConfig `yaml:"combos"`
+}
+
+// MinMeterPeriod bounds how fast meters are sampled, as faster ones would
+// only cost the host and the link without the screens showing more.
+const MinMeterPeriod = 20 * time.Millisecond
+
+type Config struct {
+	PortName           string        `yaml:"portName"`
+	BaudRate           int           `yaml:"baudRate"`
+	Combos             []ComboConfig `yaml:"combos"`
+	Profiles           []Profile     `yaml:"profiles,omitempty"`
+	ConfigReloadPeriod time.Duration `yaml:"configReloadPeriod"`
+	SetEventPeriod     time.Duration `yaml:"setEventPeriod"`
+	Backend            string        `yaml:"backend,omitempty"`
+	ControlSocket      string        `yaml:"controlSocket,omitempty"`
+	// MeterPeriod is how often the combos' peak meters are sampled and
+	// streamed to the device; zero turns meters off.
+	MeterPeriod time.Duration `yaml:"meterPeriod,omitempty"`
+	// StateFile keeps runtime state such as the active profile across
+	// restarts.
+	StateFile string `yaml:"stateFile,omitempty"`
+	// LockFile keeps a second daemon from starting. PIDFile is written in
+	// service mode.
+	LockFile string          `yaml:"lockFile,omitempty"`
+	PIDFile  string          `yaml:"pidFile,omitempty"`
+	HTTP     HTTPConfig      `yaml:"http,omitempty"`
+	MQTT     MQTTConfig      `yaml:"mqtt,omitempty"`
+	OSC      OSCConfig       `yaml:"osc,omitempty"`
+	Metrics  MetricsConfig   `yaml:"metrics,omitempty"`
+	Log      logging.Options `yaml:"log,omitempty"`
+}
+
+// HTTPConfig configures the HTTP and WebSocket API. It is read at startup
+// only.
+type HTTPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Listen defaults to a localhost port.
+	Listen string `yaml:"listen,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+}
+
+// MetricsConfig configures the Prometheus metrics endpoint. It is read at
+// startup only.
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Listen defaults to a localhost port.
+	Listen string `yaml:"listen,omitempty"`
+}
+
+// MQTTConfig configures the MQTT bridge. An empty broker disables it. It is
+// read at startup only.
+type MQTTConfig struct {
+	// Broker is a URL such as tcp://homeassistant.local:1883.
+	Broker          string `yaml:"broker"`
+	Username        string `yaml:"username,omitempty"`
+	Password        string `yaml:"password,omitempty"`
+	ClientID        string `yaml:"clientID,omitempty"`
+	TopicPrefix     string `yaml:"topicPrefix,omitempty"`
+	DiscoveryPrefix string `yaml:"discoveryPrefix,omitempty"`
+	NodeID          string `yaml:"nodeID,omitempty"`
+}
+
+// Default OSC addresses; {combo} is replaced by the combo number.
+const (
+	DefaultOSCLevel = "/combo/{combo}/level"
+	DefaultOSCMute  = "/combo/{combo}/mute"
+)
+
+// OSCConfig configures OSC input and output. It is read at startup only.
+type OSCConfig struct {
+	// Listen is the UDP address OSC messages are received on. Empty
+	// disables input.
+	Listen string `yaml:"listen,omitempty"`
+	// Send lists UDP addresses that receive level and mute changes.
+	Send []string `yaml:"send,omitempty"`
+	// Level and Mute are the combo address templates.
+	Level string `yaml:"level,omitempty"`
+	Mute  string `yaml:"mute,omitempty"`
+}
+
+// Enabled reports whether OSC is configured at all.
+func (o *OSCConfig) Enabled() bool {
+	return o.Listen != "" || len(o.Send) > 0
+}
+
+// OSCAddresses returns the level and mute addresses of a combo.
+func (c *Config) OSCAddresses(combo ComboConfig) (level, mute string) {
+	level, mute = combo.OSCLevel, combo.OSCMute
+	if level == "" {
+		level = cmp.Or(c.OSC.Level, DefaultOSCLevel)
+	}
+	if mute == "" {
+		mute = cmp.Or(c.OSC.Mute, DefaultOSCMute)
+	}
+	n := strconv.Itoa(int(combo.Combo))
+	return strings.ReplaceAll(level, "{combo}", n), strings.ReplaceAll(mute, "{combo}", n)
+}
+
+// Load reads and parses the config file at path without validating it.
+// Keys the config does not know, such as leftovers or typos, are ignored
+// and returned as warnings.
+func Load(path string) (c Config, unknown []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return Config{}, nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	// Parsing succeeded, so all the strict parse can still complain about
+	// are unknown keys.
+	var strict Config
+	var typeErr *yaml.TypeError
+	if errors.As(yaml.UnmarshalStrict(data, &strict), &typeErr) {
+		unknown = typeErr.Errors
+	}
+	return c, unknown, nil
+}
+
+// Validate checks the config for problems that do not depend on the audio
+// devices present. All problems are reported together.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.BaudRate <= 0 {
+		errs = append(errs, fmt.Errorf("baudRate must be positive, got %d", c.BaudRate))
+	}
+	if c.ConfigReloadPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("configReloadPeriod must be positive, got %s", c.ConfigReloadPeriod))
+	}
+	if c.SetEventPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("setEventPeriod must be positive, got %s", c.SetEventPeriod))
+	}
+	if c.MeterPeriod != 0 && c.MeterPeriod < MinMeterPeriod {
+		errs = append(errs, fmt.Errorf("meterPeriod must be 0 or at least %s, got %s", MinMeterPeriod, c.MeterPeriod))
+	}
+
+	if err := c.Log.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, addr := range []string{c.OSC.Level, c.OSC.Mute} {
+		if addr != "" && !strings.HasPrefix(addr, "/") {
+			errs = append(errs, fmt.Errorf("osc address %q must start with /", addr))
+		}
+	}
+
+	errs = append(errs, c.validateCombos("", c.Combos)...)
+
+	profiles := make(map[string]bool)
+	for _, p := range c.Profiles {
+		if p.Name == "" {
+			errs = append(errs, errors.New("profile without a name"))
+		} else if profiles[p.Name] {
+			errs = append(errs, fmt.Errorf("profile %q is configured more than once", p.Name))
+		}
+		profiles[p.Name] = true
+		errs = append(errs, c.validateCombos(fmt.Sprintf("profile %q: ", p.Name), p.Combos)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateCombos(prefix string, combos []ComboConfig) []error {
+	var errs []error
+	seen := make(map[uint8]bool)
+	for _, combo := range combos {
+		if seen[combo.Combo] {
+			errs = append(errs, fmt.Errorf("%scombo %d is configured more than once", prefix, combo.Combo))
+		}
+		seen[combo.Combo] = true
+
+		if combo.Combo >= protocol.COMBOS {
+			errs = append(errs, fmt.Errorf("%scombo %d does not exist, the device has combos 0 to %d", prefix, combo.Combo, protocol.COMBOS-1))
+		}
+		if len(combo.Name) > protocol.MAX_LABEL_LENGTH {
+			errs = append(errs, fmt.Errorf("%scombo %d: name %q is longer than %d bytes", prefix, combo.Combo, combo.Name, protocol.MAX_LABEL_LENGTH))
+		}
+
+		switch combo.Target {
+		case "", TargetAudio:
+			if combo.IsFocus() {
+				if combo.Selector() != (audio.Selector{}) || len(combo.Targets) > 0 {
+					errs = append(errs, fmt.Errorf("%scombo %d: focus combos pick their app and take no device selector", prefix, combo.Combo))
+				}
+			} else if len(combo.Targets) > 0 {
+				for _, err := range validateTargets(combo) {
+					errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+				}
+			} else if err := combo.Selector().Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+			}
+		case TargetOSC:
+			if combo.Selector() != (audio.Selector{}) || len(combo.Targets) > 0 {
+				errs = append(errs, fmt.Errorf("%scombo %d: osc combos take no device selector", prefix, combo.Combo))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%scombo %d: unknown target %q", prefix, combo.Combo, combo.Target))
+		}
+		for _, addr := range []string{combo.OSCLevel, combo.OSCMute} {
+			if addr != "" && !strings.HasPrefix(addr, "/") {
+				errs = append(errs, fmt.Errorf("%scombo %d: osc address %q must start with /", prefix, combo.Combo, addr))
+			}
+		}
+		if err := combo.Spec.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+		}
+		if combo.Decibel() && combo.HasApp() {
+			errs = append(errs, fmt.Errorf("%scombo %d: db curves do not apply to apps", prefix, combo.Combo))
+		}
+		if combo.Law != "" && !combo.IsCrossfade() {
+			errs = append(errs, fmt.Errorf("%scombo %d: law requires type: %s", prefix, combo.Combo, TypeCrossfade))
+		}
+		if combo.Follow != "" && !combo.IsFocus() {
+			errs = append(errs, fmt.Errorf("%scombo %d: follow requires type: %s", prefix, combo.Combo, TypeFocus))
+		}
+		switch combo.Role {
+		case "", RoleConsole, RoleCommunications:
+			if combo.Role != "" && !combo.IsSwitcher() {
+				errs = append(errs, fmt.Errorf("%scombo %d: role requires type: %s", prefix, combo.Combo, TypeSwitcher))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%scombo %d: unknown role %q, expected %s or %s", prefix, combo.Combo, combo.Role, RoleConsole, RoleCommunications))
+		}
+		switch combo.Type {
+		case "", TypeLevel:
+		case TypeCrossfade:
+			if combo.IsOSC() || len(combo.Targets) != 2 {
+				errs = append(errs, fmt.Errorf("%scombo %d: crossfade combos need exactly two targets", prefix, combo.Combo))
+			}
+			if combo.Reference != 0 {
+				errs = append(errs, fmt.Errorf("%scombo %d: crossfade combos take no reference", prefix, combo.Combo))
+			}
+			if err := curve.ValidateLaw(combo.Law); err != nil {
+				errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+			}
+		case TypeSwitcher:
+			if combo.IsOSC() || len(combo.Targets) < 2 {
+				errs = append(errs, fmt.Errorf("%scombo %d: switcher combos need at least two targets", prefix, combo.Combo))
+			}
+			if combo.Reference != 0 {
+				errs = append(errs, fmt.Errorf("%scombo %d: switcher combos take no reference", prefix, combo.Combo))
+			}
+			if combo.OnClick != "" {
+				errs = append(errs, fmt.Errorf("%scombo %d: switcher combos commit on click and take no onClick", prefix, combo.Combo))
+			}
+		case TypeFocus:
+			if combo.IsOSC() {
+				errs = append(errs, fmt.Errorf("%scombo %d: focus combos control apps and cannot target osc", prefix, combo.Combo))
+			}
+			if err := focus.ValidateMode(combo.Follow); err != nil {
+				errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%scombo %d: unknown type %q", prefix, combo.Combo, combo.Type))
+		}
+		if err := combo.Turns.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+		}
+		for _, gesture := range []string{combo.OnClick, combo.OnDoubleClick} {
+			action, err := ParseAction(gesture)
+			if err == nil && action.Kind == ActionProfile && c.Profile(action.Profile) == nil {
+				err = fmt.Errorf("unknown profile %q", action.Profile)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%scombo %d: %w", prefix, combo.Combo, err))
+			}
+		}
+	}
+	return errs
+}
+
+func validateTargets(combo ComboConfig) []error {
+	var errs []error
+	if combo.Selector() != (audio.Selector{}) {
+		errs = append(errs, errors.New("a device selector cannot be combined with targets"))
+	}
+	if combo.Reference < 0 || combo.Reference >= len(combo.Targets) {
+		errs = append(errs, fmt.Errorf("reference %d is not a target", combo.Reference))
+	}
+	for i, t := range combo.Targets {
+		if err := t.Selector().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target %d: %w", i, err))
+		}
+		if t.scale() <= 0 {
+			errs = append(errs, fmt.Errorf("target %d: scale must be positive, got %g", i, t.scale()))
+		}
+	}
+	return errs
+}
+
+// Profile returns the profile called name, or nil.
+func (c *Config) Profile(name string) *Profile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// DefaultProfile is active when no profile was selected yet: the first one,
+// or none if no profiles are configured.
+func (c *Config) DefaultProfile() string {
+	if len(c.Profiles) == 0 {
+		return ""
+	}
+	return c.Profiles[0].Name
+}
+
+// NextProfile returns the profile after name, wrapping around.
+func (c *Config) NextProfile(name string) string {
+	for i, p := range c.Profiles {
+		if p.Name == name {
+			return c.Profiles[(i+1)%len(c.Profiles)].Name
+		}
+	}
+	return c.DefaultProfile()
+}
+
+// ProfileCombos returns the combos in effect while profile is active: the
+// base combos with the profile's combos replacing or adding to them,
+// ordered by combo number. Unknown profiles yield the base combos.
+func (c *Config) ProfileCombos(profile string) []ComboConfig {
+	byCombo := make(map[uint8]ComboConfig)
+	for _, combo := range c.Combos {
+		byCombo[combo.Combo] = combo
+	}
+	if p := c.Profile(profile); p != nil {
+		for _, combo := range p.Combos {
+			byCombo[combo.Combo] = combo
+		}
+	}
+
+	combos := make([]ComboConfig, 0, len(byCombo))
+	for _, combo := range byCombo {
+		combos = append(combos, combo)
+	}
+	slices.SortFunc(combos, func(a, b ComboConfig) int {
+		return int(a.Combo) - int(b.Combo)
+	})
+	return combos
+}
+
+// CheckDevices reports combos of a profile whose selector does not resolve
+// against the given endpoints. Default role and app selectors always
+// resolve, since apps come and go.
+func (c *Config) CheckDevices(profile string, devices []audio.Device) error {
+	var errs []error
+	for _, combo := range c.ProfileCombos(profile) {
+		if combo.IsOSC() {
+			continue
+		}
+		for _, t := range combo.Gang() {
+			sel := t.Selector()
+			if sel.Validate() != nil || sel.Role() != audio.RoleNone || sel.App != "" {
+				continue
+			}
+			if _, err := audio.Match(devices, sel); err != nil {
+				errs = append(errs, fmt.Errorf("combo %d: %w", combo.Combo, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Action kinds triggered by gestures.
+const (
+	ActionNone = iota
+	ActionNextProfile
+	ActionProfile
+	ActionToggleMute
+	ActionSetDefault
+)
+
+// ActionToggleMuteName is the gesture action that toggles the combo's mute.
+const ActionToggleMuteName = "toggleMute"
+
+// Action is something a gesture does instead of setting the level.
+type Action struct {
+	Kind    int
+	Profile string
+	// Role is the default ActionSetDefault changes.
+	Role audio.Role
+}
+
+// ParseAction parses a gesture action: "nextProfile" cycles through the
+// profiles, "profile:<name>" switches to a profile, "toggleMute" mutes or
+// unmutes the combo and "setDefault" or "setDefaultCommunications" makes the
+// combo's endpoint the system default. An empty string is no action.
+func ParseAction(s string) (Action, error) {
+	switch {
+	case s == "":
+		return Action{Kind: ActionNone}, nil
+	case s == "nextProfile":
+		return Action{Kind: ActionNextProfile}, nil
+	case s == ActionToggleMuteName:
+		return Action{Kind: ActionToggleMute}, nil
+	case s == "setDefault":
+		return Action{Kind: ActionSetDefault, Role: audio.RoleConsole}, nil
+	case s == "setDefaultCommunications":
+		return Action{Kind: ActionSetDefault, Role: audio.RoleCommunications}, nil
+	case strings.HasPrefix(s, "profile:") && len(s) > len("profile:"):
+		return Action{Kind: ActionProfile, Profile: strings.TrimPrefix(s, "profile:")}, nil
+	default:
+		return Action{}, fmt.Errorf("unknown action %q", s)
+	}
+}
+
+// State is remembered across restarts.
+type State struct {
+	ActiveProfile string `yaml:"activeProfile"`
+}
+
+// DefaultStatePath is used when no state file is configured.
+func DefaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "desktop-audio-ctrl", "state.yaml")
+}
+
+// LoadState reads the state file. A missing file yields the zero State.
+func LoadState(path string) (State, error) {
+	var st State
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("reading state file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("parsing state file: %w", err)
+	}
+	return st, nil
+}
+
+// SaveState writes the state file, replacing it atomically.
+func SaveState(path string, st State) error {
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
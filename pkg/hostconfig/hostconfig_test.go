@@ -0,0 +1,503 @@
+package hostconfig
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"desktop-audio-ctrl/pkg/curve"
+	"desktop-audio-ctrl/pkg/focus"
+	"desktop-audio-ctrl/pkg/relative"
+	"desktop-audio-ctrl/protocol"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() Config {
+	return Config{
+		PortName:           "COM11",
+		BaudRate:           115200,
+		ConfigReloadPeriod: 10 * time.Minute,
+		SetEventPeriod:     5 * time.Second,
+		Combos: []ComboConfig{
+			{Combo: 0, DeviceName: "Speakers"},
+			{Combo: 1, DeviceName: "default"},
+		},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+portName: "COM11"
+baudRate: 115200
+combos:
+  - combo: 0
+    deviceName: "Speakers"
+    curve: custom
+    points:
+      - {knob: 0, level: 0}
+      - {knob: 100, level: 80}
+    mode: relative
+    step: 2
+configReloadPeriod: 10m
+setEventPeriod: 5s
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, unknown, err := Load(path)
+	if err != nil || len(unknown) > 0 {
+		t.Fatalf("Unexpected error: %v, %v", err, unknown)
+	}
+	if c.SetEventPeriod != 5*time.Second || len(c.Combos) != 1 || c.Combos[0].DeviceName != "Speakers" {
+		t.Errorf("Unexpected config: %+v", c)
+	}
+	if c.Combos[0].Curve != curve.Custom || len(c.Combos[0].Points) != 2 || c.Combos[0].Points[1].Level != 80 {
+		t.Errorf("Unexpected curve: %+v", c.Combos[0].Spec)
+	}
+	if !c.Combos[0].Turns.IsRelative() || c.Combos[0].Turns.Step != 2 {
+		t.Errorf("Unexpected turns: %+v", c.Combos[0].Turns)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("combos: [\n"), 0644)
+	if _, _, err := Load(path); err == nil {
+		t.Errorf("Expected error for broken YAML")
+	}
+
+	os.WriteFile(path, []byte("setEventPeriod: soon\n"), 0644)
+	if _, _, err := Load(path); err == nil {
+		t.Errorf("Expected error for a value of the wrong type")
+	}
+
+	// Unknown keys only warn.
+	os.WriteFile(path, []byte("setEventPeriode: 5s\nsetEventPeriod: 5s\n"), 0644)
+	c, unknown, err := Load(path)
+	if err != nil || c.SetEventPeriod != 5*time.Second {
+		t.Fatalf("Expected the config to load, got %+v, %v", c, err)
+	}
+	if len(unknown) != 1 || !strings.Contains(unknown[0], "setEventPeriode") {
+		t.Errorf("Expected a warning for the misspelled key, got %v", unknown)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := validConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c.SetEventPeriod = 0
+	c.ConfigReloadPeriod = -time.Second
+	c.MeterPeriod = time.Millisecond
+	c.Log.Format = "xml"
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 1, DeviceID: "x"},
+		ComboConfig{Combo: 2},
+		ComboConfig{Combo: 3, DevicePattern: "("},
+		ComboConfig{Combo: 4, DeviceName: "Speakers", Spec: curve.Spec{Curve: "cubic"}},
+		ComboConfig{Combo: 5, Target: TargetOSC, DeviceName: "Speakers"},
+		ComboConfig{Combo: 6, Target: "midi"},
+		ComboConfig{Combo: 7, Target: TargetOSC, OSCLevel: "fader"},
+		ComboConfig{Combo: 8, DeviceName: "Speakers", Turns: relative.Spec{Mode: "spin"}},
+		ComboConfig{Combo: 9, DeviceName: "Speakers", Name: strings.Repeat("ä", 15)},
+	)
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation errors")
+	}
+	for _, want := range []string{"setEventPeriod", "configReloadPeriod", "meterPeriod", "combo 1 is configured more than once", "combo 2", "combo 3", "combo 4: unknown curve", "combo 5: osc combos take no device selector", "combo 6: unknown target", "combo 7: osc address", "combo 8: unknown mode", "combo 9: name", "unknown log format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestComboNumbers(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{Combo: protocol.COMBOS - 1, DeviceName: "Speakers"})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// ALL_COMBOS would address every knob at once.
+	c.Combos = append(c.Combos, ComboConfig{Combo: protocol.COMBOS, DeviceName: "Speakers"})
+	c.Profiles = []Profile{{Name: "game", Combos: []ComboConfig{{Combo: protocol.ALL_COMBOS, DeviceName: "Speakers"}}}}
+	err := c.Validate()
+	for _, want := range []string{"combo 5 does not exist", `profile "game": combo 255 does not exist`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	devices := []audio.Device{
+		{ID: "a", Name: "Speakers", State: audio.StateActive},
+		{ID: "b", Name: "Headset", State: audio.StateActive},
+		{ID: "c", Name: "Headset", State: audio.StateActive},
+	}
+
+	c := validConfig()
+	if err := c.CheckDevices("", devices); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 2, DeviceName: "Headset"},
+		ComboConfig{Combo: 3, DeviceID: "gone"},
+	)
+	err := c.CheckDevices("", devices)
+	if !errors.Is(err, audio.ErrAmbiguous) || !errors.Is(err, audio.ErrNoMatch) {
+		t.Errorf("Expected ambiguous and missing device errors, got: %v", err)
+	}
+}
+
+func TestOSCAddresses(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{Combo: 3, Target: TargetOSC, OSCMute: "/ch/03/mix/on"})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	devices := []audio.Device{{ID: "a", Name: "Speakers", State: audio.StateActive}}
+	if err := c.CheckDevices("", devices); err != nil {
+		t.Errorf("Expected osc combos to need no device, got: %v", err)
+	}
+
+	level, mute := c.OSCAddresses(c.Combos[2])
+	if level != "/combo/3/level" || mute != "/ch/03/mix/on" {
+		t.Errorf("Expected default level and configured mute address, got %s and %s", level, mute)
+	}
+
+	c.OSC.Level = "/mixer/{combo}/fader"
+	if level, _ := c.OSCAddresses(c.Combos[2]); level != "/mixer/3/fader" {
+		t.Errorf("Expected /mixer/3/fader, got %s", level)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	half := 0.5
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{
+		Combo: 2,
+		Targets: []TargetConfig{
+			{DeviceName: "Game"},
+			{DeviceName: "Media", Offset: -20},
+			{DeviceName: "Chat", Scale: &half, Offset: 10},
+		},
+		Reference: 1,
+	})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	gang := c.Combos[2].Gang()
+	for _, tt := range []struct {
+		target, knob, position int
+	}{
+		{0, 60, 60},
+		{1, 60, 40},
+		{1, 10, 0},
+		{2, 60, 40},
+		{2, 100, 60},
+	} {
+		if got := gang[tt.target].Position(tt.knob); got != tt.position {
+			t.Errorf("Expected target %d at %d for knob %d, got %d", tt.target, tt.position, tt.knob, got)
+		}
+	}
+	if got := gang[1].Knob(40); got != 60 {
+		t.Errorf("Expected knob 60 for reference at 40, got %d", got)
+	}
+	if got := gang[1].Knob(90); got != 100 {
+		t.Errorf("Expected knob limited to 100, got %d", got)
+	}
+
+	// Knobs 0 to 20 all put the reference at 0, so reading it back keeps
+	// the knob where it was instead of jumping to 20.
+	combo := c.Combos[2]
+	for _, tt := range []struct {
+		position, last, want int
+	}{
+		{0, 10, 10},
+		{0, 0, 0},
+		{0, 30, 20},
+		{0, -1, 20},
+		{40, 60, 60},
+		{41, 60, 61},
+	} {
+		if got := combo.KnobNear([]int{0, tt.position, 0}, tt.last); got != tt.want {
+			t.Errorf("Expected knob %d for reference at %d after %d, got %d", tt.want, tt.position, tt.last, got)
+		}
+	}
+
+	// Plain combos are a gang of one.
+	if g := c.Combos[0].Gang(); len(g) != 1 || g[0].DeviceName != "Speakers" || g[0].Position(30) != 30 {
+		t.Errorf("Unexpected gang: %+v", g)
+	}
+
+	devices := []audio.Device{{ID: "a", Name: "Speakers", State: audio.StateActive}, {ID: "b", Name: "Game", State: audio.StateActive}}
+	if err := c.CheckDevices("", devices); !errors.Is(err, audio.ErrNoMatch) || !strings.Contains(err.Error(), "Media") {
+		t.Errorf("Expected missing targets to be reported, got: %v", err)
+	}
+
+	zero := 0.0
+	c.Combos[2].DeviceName = "Speakers"
+	c.Combos[2].Reference = 3
+	c.Combos[2].Targets[0].Scale = &zero
+	c.Combos[2].Targets[1].DeviceName = ""
+	err := c.Validate()
+	for _, want := range []string{"combo 2: a device selector cannot be combined", "reference 3", "target 0: scale", "target 1:"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	half := 0.5
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{
+		Combo:   2,
+		Type:    TypeCrossfade,
+		Targets: []TargetConfig{{DeviceName: "Game"}, {DeviceName: "Chat", Scale: &half}},
+	})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	combo := c.Combos[2]
+	if got := combo.Positions(25); got[0] != 75 || got[1] != 13 {
+		t.Errorf("Expected positions [75 13], got %v", got)
+	}
+	// Scaled targets lose precision, but no more than a position.
+	if got := combo.Knob([]int{75, 13}); got < 24 || got > 26 {
+		t.Errorf("Expected knob 25, got %d", got)
+	}
+	if got := combo.KnobNear([]int{75, 13}, 25); got != 25 {
+		t.Errorf("Expected the last knob 25 to be kept, got %d", got)
+	}
+	if got := combo.LevelTargets(); len(got) != 2 {
+		t.Errorf("Expected both targets to be read, got %v", got)
+	}
+
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 3, Type: TypeCrossfade, Law: "cubic", Targets: []TargetConfig{{DeviceName: "Game"}}},
+		ComboConfig{Combo: 4, Type: "eq", DeviceName: "Game"},
+		ComboConfig{Combo: 5, Law: curve.LawLinear, DeviceName: "Game"},
+	)
+	err := c.Validate()
+	for _, want := range []string{"combo 3: crossfade combos need exactly two targets", "combo 3: unknown crossfade law", "combo 4: unknown type", "combo 5: law requires type"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 2, DeviceName: "default", Flow: "capture"},
+		ComboConfig{Combo: 3, DeviceName: "Mic", Flow: "capture", OnClick: "nextProfile"},
+		ComboConfig{Combo: 4, Targets: []TargetConfig{{DeviceName: "Mic", Flow: "capture"}, {DeviceName: "Speakers"}}},
+	)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := c.Combos[2].ClickAction(); got != ActionToggleMuteName {
+		t.Errorf("Expected capture combo to toggle mute on click, got %q", got)
+	}
+	if got := c.Combos[3].ClickAction(); got != "nextProfile" {
+		t.Errorf("Expected configured click action, got %q", got)
+	}
+	if c.Combos[0].IsCapture() || c.Combos[4].IsCapture() {
+		t.Errorf("Expected combos with render endpoints not to be capture combos")
+	}
+	if sel := c.Combos[2].Gang()[0].Selector(); sel.DefaultFlow() != audio.FlowCapture {
+		t.Errorf("Expected the default capture device, got %s", sel)
+	}
+
+	c.Combos = append(c.Combos, ComboConfig{Combo: 5, DeviceName: "Mic", Flow: "input"})
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "combo 5: unknown flow") {
+		t.Errorf("Expected unknown flow error, got: %v", err)
+	}
+}
+
+func TestApp(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 2, App: "discord"},
+		ComboConfig{Combo: 3, Type: TypeCrossfade, Targets: []TargetConfig{{App: "spotify"}, {App: "discord"}}},
+	)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !c.Combos[2].HasApp() || !c.Combos[3].HasApp() || c.Combos[0].HasApp() {
+		t.Errorf("Expected only the app combos to have apps")
+	}
+	devices := []audio.Device{{ID: "a", Name: "Speakers", State: audio.StateActive}}
+	if err := c.CheckDevices("", devices); err != nil {
+		t.Errorf("Expected apps to be left out of device checks, got: %v", err)
+	}
+
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 4, App: "discord", DeviceName: "Speakers"},
+		ComboConfig{Combo: 5, App: "discord", Spec: curve.Spec{Curve: curve.DB}},
+	)
+	err := c.Validate()
+	for _, want := range []string{"combo 4: only one of", "combo 5: db curves do not apply to apps"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestSwitcher(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{
+		Combo:   2,
+		Type:    TypeSwitcher,
+		Role:    RoleCommunications,
+		Targets: []TargetConfig{{DeviceName: "Headset", Flow: "capture"}, {DeviceName: "Webcam", Flow: "capture"}},
+	})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := c.Combos[2].DefaultRole(); got != audio.RoleCommunications {
+		t.Errorf("Expected RoleCommunications, got %d", got)
+	}
+	if got := c.Combos[2].ClickAction(); got != "" {
+		t.Errorf("Expected switcher clicks to commit, got action %q", got)
+	}
+
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 3, Type: TypeSwitcher, OnClick: "nextProfile", Targets: []TargetConfig{{DeviceName: "Headset"}}},
+		ComboConfig{Combo: 4, Role: "multimedia", DeviceName: "Headset"},
+		ComboConfig{Combo: 5, Role: RoleConsole, DeviceName: "Headset"},
+	)
+	err := c.Validate()
+	for _, want := range []string{"combo 3: switcher combos need at least two targets", "combo 3: switcher combos commit on click", "combo 4: unknown role", "combo 5: role requires type"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestFocus(t *testing.T) {
+	c := validConfig()
+	c.Combos = append(c.Combos, ComboConfig{Combo: 2, Type: TypeFocus, Follow: focus.Loudest})
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !c.Combos[2].IsFocus() || !c.Combos[2].HasApp() {
+		t.Errorf("Expected a focus combo controlling an app")
+	}
+
+	c.Combos = append(c.Combos,
+		ComboConfig{Combo: 3, Type: TypeFocus, App: "discord"},
+		ComboConfig{Combo: 4, Type: TypeFocus, Follow: "newest"},
+		ComboConfig{Combo: 5, Follow: focus.Recent, DeviceName: "Headset"},
+	)
+	err := c.Validate()
+	for _, want := range []string{"combo 3: focus combos pick their app", "combo 4: unknown follow mode", "combo 5: follow requires type"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	c := validConfig()
+	c.Profiles = []Profile{
+		{Name: "gaming", Combos: []ComboConfig{{Combo: 1, DeviceName: "Headset", Name: "Chat"}}},
+		{Name: "music", Combos: []ComboConfig{{Combo: 2, DeviceName: "Interface", OnDoubleClick: "profile:gaming"}}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	combos := c.ProfileCombos("gaming")
+	if len(combos) != 2 || combos[1].DeviceName != "Headset" {
+		t.Errorf("Expected combo 1 replaced by the profile, got %+v", combos)
+	}
+	combos = c.ProfileCombos("music")
+	if len(combos) != 3 || combos[0].DeviceName != "Speakers" || combos[2].DeviceName != "Interface" {
+		t.Errorf("Expected combo 2 added by the profile, got %+v", combos)
+	}
+	if combos := c.ProfileCombos("missing"); len(combos) != 2 || combos[1].DeviceName != "default" {
+		t.Errorf("Expected base combos for unknown profile, got %+v", combos)
+	}
+
+	if got := c.DefaultProfile(); got != "gaming" {
+		t.Errorf("Expected default profile 'gaming', got '%s'", got)
+	}
+	if got := c.NextProfile("gaming"); got != "music" {
+		t.Errorf("Expected next profile 'music', got '%s'", got)
+	}
+	if got := c.NextProfile("music"); got != "gaming" {
+		t.Errorf("Expected next profile to wrap to 'gaming', got '%s'", got)
+	}
+
+	c.Profiles = append(c.Profiles,
+		Profile{Name: "gaming"},
+		Profile{Name: "broken", Combos: []ComboConfig{{Combo: 0, DeviceName: "x", OnClick: "profile:meeting"}}},
+	)
+	err := c.Validate()
+	for _, want := range []string{`profile "gaming" is configured more than once`, `unknown profile "meeting"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Action
+		ok   bool
+	}{
+		{"", Action{Kind: ActionNone}, true},
+		{"nextProfile", Action{Kind: ActionNextProfile}, true},
+		{"profile:music", Action{Kind: ActionProfile, Profile: "music"}, true},
+		{"toggleMute", Action{Kind: ActionToggleMute}, true},
+		{"setDefault", Action{Kind: ActionSetDefault, Role: audio.RoleConsole}, true},
+		{"setDefaultCommunications", Action{Kind: ActionSetDefault, Role: audio.RoleCommunications}, true},
+		{"profile:", Action{}, false},
+		{"mute", Action{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAction(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseAction(%q) = %+v, %v; expected %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.yaml")
+
+	st, err := LoadState(path)
+	if err != nil || st.ActiveProfile != "" {
+		t.Fatalf("Expected empty state for missing file, got %+v (%v)", st, err)
+	}
+
+	if err := SaveState(path, State{ActiveProfile: "music"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	st, err = LoadState(path)
+	if err != nil || st.ActiveProfile != "music" {
+		t.Errorf("Expected active profile 'music', got %+v (%v)", st, err)
+	}
+}
@@ -0,0 +1,409 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"desktop-audio-ctrl/pkg/audio"
+	"desktop-audio-ctrl/pkg/control"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultAddr is used when no listen address is configured.
+const DefaultAddr = "127.0.0.1:7272"
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+// Options wires the API to the daemon.
+type Options struct {
+	Controller control.Controller
+	Hub        *control.Hub
+	Devices    func() ([]audio.Device, error)
+	Link       func() control.LinkStatus
+	// Token is required as a bearer token, or as the token query parameter
+	// for WebSocket clients that cannot set headers. Empty disables
+	// authentication.
+	Token  string
+	Logger *slog.Logger
+}
+
+type api struct {
+	Options
+	upgrader websocket.Upgrader
+}
+
+// NewHandler returns the API as an http.Handler.
+func NewHandler(opts Options) http.Handler {
+	a := &api{Options: opts}
+	a.upgrader.CheckOrigin = a.checkOrigin
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/openapi.yaml", a.openAPI)
+	mux.HandleFunc("GET /api/combos", a.combos)
+	mux.HandleFunc("GET /api/combos/{combo}", a.combo)
+	mux.HandleFunc("PUT /api/combos/{combo}/level", a.setLevel)
+	mux.HandleFunc("PUT /api/combos/{combo}/mute", a.setMute)
+	mux.HandleFunc("POST /api/combos/{combo}/mute/toggle", a.toggleMute)
+	mux.HandleFunc("GET /api/devices", a.devices)
+	mux.HandleFunc("GET /api/profiles", a.profiles)
+	mux.HandleFunc("PUT /api/profiles/active", a.switchProfile)
+	mux.HandleFunc("GET /api/link", a.link)
+	mux.HandleFunc("GET /api/log/levels", a.logLevels)
+	mux.HandleFunc("PUT /api/log/levels/{subsystem}", a.setLogLevel)
+	mux.HandleFunc("GET /api/events", a.events)
+
+	return a.guard(a.authenticate(mux))
+}
+
+// Server serves the API over HTTP.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts serving the API on addr. Without a token only loopback
+// addresses are allowed.
+func Listen(addr string, opts Options) (*Server, error) {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if opts.Token == "" && !isLoopback(addr) {
+		return nil, fmt.Errorf("refusing to serve %s without a token", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		server: &http.Server{
+			Handler:           NewHandler(opts),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			opts.Logger.Error("http api stopped", "err", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and disconnects all clients.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackHost reports whether a Host header names this machine.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guard rejects requests a web page could forge. Without a token the API
+// only answers to loopback host names, so a page that rebinds its own
+// name to 127.0.0.1 cannot reach it. Changes must not come from another
+// origin and must carry JSON, which plain HTML forms cannot send.
+func (a *api) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Token == "" && !isLoopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not allowed", r.Host))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !sameOrigin(r) {
+				writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+				return
+			}
+			if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("requests must be sent as application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether a request comes from a page of the API's own
+// host, or from a client that is not a browser and sends no Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (a *api) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Token != "" {
+			token := r.URL.Query().Get("token")
+			if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				token = auth
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin lets any page connect once it proved it knows the token.
+// Without a token only pages served by the API's own host may connect, so
+// arbitrary websites cannot read the event stream.
+func (a *api) checkOrigin(r *http.Request) bool {
+	return a.Token != "" || sameOrigin(r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// reply writes v, or maps err to a status code.
+func reply(w http.ResponseWriter, v any, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, v)
+	case errors.Is(err, control.ErrUnknownCombo), errors.Is(err, control.ErrUnknownProfile),
+		errors.Is(err, control.ErrUnknownSubsystem):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, control.ErrInvalidLogLevel):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, control.ErrNoDevice):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func parseCombo(w http.ResponseWriter, r *http.Request) (uint8, bool) {
+	n, err := strconv.ParseUint(r.PathValue("combo"), 10, 8)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid combo %q", r.PathValue("combo")))
+		return 0, false
+	}
+	return uint8(n), true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func (a *api) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPI)
+}
+
+func (a *api) combos(w http.ResponseWriter, r *http.Request) {
+	statuses, err := a.Controller.Combos()
+	reply(w, statuses, err)
+}
+
+func (a *api) combo(w http.ResponseWriter, r *http.Request) {
+	combo, ok := parseCombo(w, r)
+	if !ok {
+		return
+	}
+	status, err := a.Controller.Get(combo)
+	reply(w, status, err)
+}
+
+type levelRequest struct {
+	Level *int `json:"level"`
+}
+
+func (a *api) setLevel(w http.ResponseWriter, r *http.Request) {
+	combo, ok := parseCombo(w, r)
+	if !ok {
+		return
+	}
+	var req levelRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Level == nil || *req.Level < 0 || *req.Level > 100 {
+		writeError(w, http.StatusBadRequest, errors.New("level must be within 0-100"))
+		return
+	}
+	status, err := a.Controller.SetLevel(combo, *req.Level)
+	reply(w, status, err)
+}
+
+type muteRequest struct {
+	Muted *bool `json:"muted"`
+}
+
+func (a *api) setMute(w http.ResponseWriter, r *http.Request) {
+	combo, ok := parseCombo(w, r)
+	if !ok {
+		return
+	}
+	var req muteRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Muted == nil {
+		writeError(w, http.StatusBadRequest, errors.New("muted is required"))
+		return
+	}
+	status, err := a.Controller.SetMute(combo, *req.Muted)
+	reply(w, status, err)
+}
+
+func (a *api) toggleMute(w http.ResponseWriter, r *http.Request) {
+	combo, ok := parseCombo(w, r)
+	if !ok {
+		return
+	}
+	status, err := a.Controller.ToggleMute(combo)
+	reply(w, status, err)
+}
+
+func (a *api) devices(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Devices()
+	reply(w, devices, err)
+}
+
+func (a *api) profiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := a.Controller.Profiles()
+	reply(w, profiles, err)
+}
+
+type profileRequest struct {
+	Name string `json:"name"`
+}
+
+func (a *api) switchProfile(w http.ResponseWriter, r *http.Request) {
+	var req profileRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	profiles, err := a.Controller.SwitchProfile(req.Name)
+	reply(w, profiles, err)
+}
+
+func (a *api) link(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Link())
+}
+
+func (a *api) logLevels(w http.ResponseWriter, r *http.Request) {
+	levels, err := a.Controller.LogLevels()
+	reply(w, levels, err)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func (a *api) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	levels, err := a.Controller.SetLogLevel(r.PathValue("subsystem"), req.Level)
+	reply(w, levels, err)
+}
+
+const (
+	pingPeriod = 30 * time.Second
+	writeWait  = 10 * time.Second
+)
+
+// events streams every hub update as a JSON text message.
+func (a *api) events(w http.ResponseWriter, r *http.Request) {
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	updates, cancel := a.Hub.Subscribe()
+	defer cancel()
+
+	// Clients only send control frames; reading handles them and notices
+	// when the client goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case u := <-updates:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(u); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package httpapi
+
+import (
+	"desktop-audio-ctrl/pkg/audio"
+	"desktop-audio-ctrl/pkg/control"
+	"desktop-audio-ctrl/pkg/control/controltest"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v2"
+)
+
+const testToken = "secret"
+
+func newTestServer(t *testing.T, token string) (*httptest.Server, *control.Hub) {
+	t.Helper()
+	hub := control.NewHub()
+	h := NewHandler(Options{
+		Controller: controltest.New(),
+		Hub:        hub,
+		Devices: func() ([]audio.Device, error) {
+			return []audio.Device{{ID: "a", Name: "Speakers", State: audio.StateActive}}, nil
+		},
+		Link:   func() control.LinkStatus { return control.LinkStatus{Connected: true, Port: "COM11"} },
+		Token:  token,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	return s, hub
+}
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _ := newTestServer(t, testToken)
+
+	resp, err := http.Get(s.URL + "/api/combos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(s.URL + "/api/combos?token=" + testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 with query token, got %d", resp.StatusCode)
+	}
+
+	if code, _ := do(t, "GET", s.URL+"/api/combos", ""); code != http.StatusOK {
+		t.Errorf("Expected 200 with bearer token, got %d", code)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	s, _ := newTestServer(t, testToken)
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		contains           string
+	}{
+		{"GET", "/api/combos/0", "", 200, `"level":10`},
+		{"PUT", "/api/combos/0/level", `{"level": 42}`, 200, `"level":42`},
+		{"PUT", "/api/combos/0/level", `{"level": 101}`, 400, "0-100"},
+		{"PUT", "/api/combos/0/level", `{"volume": 1}`, 400, "unknown field"},
+		{"PUT", "/api/combos/7/level", `{"level": 1}`, 404, "unknown combo"},
+		{"GET", "/api/combos/x", "", 400, "invalid combo"},
+		{"PUT", "/api/combos/0/mute", `{"muted": true}`, 200, `"muted":true`},
+		{"POST", "/api/combos/0/mute/toggle", "", 200, `"muted":false`},
+		{"GET", "/api/devices", "", 200, `"name":"Speakers"`},
+		{"GET", "/api/profiles", "", 200, `"active":true`},
+		{"PUT", "/api/profiles/active", `{"name": "music"}`, 404, "unknown profile"},
+		{"GET", "/api/link", "", 200, `"connected":true`},
+		{"GET", "/api/log/levels", "", 200, `"subsystem":"serial"`},
+		{"PUT", "/api/log/levels/serial", `{"level": "debug"}`, 200, `"level":"debug"`},
+		{"PUT", "/api/log/levels/serial", `{"level": "loud"}`, 400, "invalid log level"},
+		{"PUT", "/api/log/levels/video", `{"level": "debug"}`, 404, "unknown log subsystem"},
+		{"GET", "/api/openapi.yaml", "", 200, "openapi: 3.0.3"},
+	}
+	for _, tt := range tests {
+		code, body := do(t, tt.method, s.URL+tt.path, tt.body)
+		if code != tt.code || !strings.Contains(body, tt.contains) {
+			t.Errorf("%s %s: expected %d containing %q, got %d: %s", tt.method, tt.path, tt.code, tt.contains, code, body)
+		}
+	}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatalf("Failed to parse OpenAPI description: %v", err)
+	}
+
+	routes := []string{
+		"GET /api/openapi.yaml",
+		"GET /api/combos",
+		"GET /api/combos/{combo}",
+		"PUT /api/combos/{combo}/level",
+		"PUT /api/combos/{combo}/mute",
+		"POST /api/combos/{combo}/mute/toggle",
+		"GET /api/devices",
+		"GET /api/profiles",
+		"PUT /api/profiles/active",
+		"GET /api/link",
+		"GET /api/log/levels",
+		"PUT /api/log/levels/{subsystem}",
+		"GET /api/events",
+	}
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("Expected OpenAPI description to document %s", route)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, hub := newTestServer(t, testToken)
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/events?token=" + testToken
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	received := make(chan control.Update, 1)
+	go func() {
+		var u control.Update
+		if _, data, err := conn.ReadMessage(); err == nil && json.Unmarshal(data, &u) == nil {
+			received <- u
+		}
+	}()
+
+	// Publish until the subscription is in place.
+	deadline := time.After(2 * time.Second)
+	for {
+		hub.Publish(control.Update{Kind: control.KindEvent, Combo: 2, Level: 55, Event: "cw"})
+		select {
+		case u := <-received:
+			if u.Combo != 2 || u.Level != 55 || u.Event != "cw" {
+				t.Errorf("Unexpected update: %+v", u)
+			}
+			return
+		case <-deadline:
+			t.Fatalf("Timeout waiting for update")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestEvents_ForeignOrigin(t *testing.T) {
+	s, _ := newTestServer(t, "")
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/events"
+	header := http.Header{"Origin": []string{"https://example.com"}}
+	if conn, _, err := websocket.DefaultDialer.Dial(url, header); err == nil {
+		conn.Close()
+		t.Errorf("Expected foreign origin to be rejected without a token")
+	}
+}
+
+func TestGuard(t *testing.T) {
+	s, _ := newTestServer(t, "")
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		contentType string
+		code        int
+	}{
+		{"loopback read", "GET", "", "", "", 200},
+		{"localhost read", "GET", "localhost:7272", "", "", 200},
+		{"rebound name", "GET", "evil.example:7272", "", "", 403},
+		{"form post", "POST", "", "https://evil.example", "application/x-www-form-urlencoded", 403},
+		{"cross-origin json", "POST", "", "https://evil.example", "application/json", 403},
+		{"no content type", "POST", "", "", "", 415},
+		{"text body", "POST", "", "", "text/plain", 415},
+		{"cli", "POST", "", "", "application/json", 200},
+		{"same origin", "POST", "", "same", "application/json; charset=utf-8", 200},
+	}
+	for _, tt := range tests {
+		path := "/api/combos"
+		if tt.method == "POST" {
+			path = "/api/combos/0/mute/toggle"
+		}
+		req, err := http.NewRequest(tt.method, s.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		switch tt.origin {
+		case "":
+		case "same":
+			req.Header.Set("Origin", s.URL)
+		default:
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, resp.StatusCode)
+		}
+	}
+}
+
+func TestListen_RequiresTokenOffLoopback(t *testing.T) {
+	if _, err := Listen("0.0.0.0:0", Options{}); err == nil {
+		t.Errorf("Expected error when serving all interfaces without a token")
+	}
+}
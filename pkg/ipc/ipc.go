@@ -0,0 +1,392 @@
+package ipc
+
+import (
+	"bufio"
+	"desktop-audio-ctrl/pkg/control"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Commands understood by the server.
+const (
+	CommandList    = "list"
+	CommandGet     = "get"
+	CommandSet     = "set"
+	CommandMute    = "mute"
+	CommandMonitor = "monitor"
+	// CommandProfile lists profiles, or switches to Request.Profile.
+	CommandProfile = "profile"
+	// CommandLog lists log levels, or sets Request.Subsystem to
+	// Request.LogLevel.
+	CommandLog = "log"
+)
+
+// Request is sent by a client as a single JSON line.
+type Request struct {
+	Command string `json:"command"`
+	Combo   uint8  `json:"combo"`
+	Level   int    `json:"level,omitempty"`
+	// Muted sets the mute state; nil toggles it.
+	Muted   *bool  `json:"muted,omitempty"`
+	Profile string `json:"profile,omitempty"`
+
+	Subsystem string `json:"subsystem,omitempty"`
+	LogLevel  string `json:"logLevel,omitempty"`
+}
+
+// Response is sent by the server as a single JSON line. Monitor requests
+// receive one response per update until the connection is closed.
+type Response struct {
+	Status    []control.ComboStatus   `json:"status,omitempty"`
+	Profiles  []control.ProfileStatus `json:"profiles,omitempty"`
+	LogLevels []control.LogLevel      `json:"logLevels,omitempty"`
+	Update    *control.Update         `json:"update,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+// DefaultSocketPath is used when no control socket is configured.
+func DefaultSocketPath() string {
+	return filepath.Join(os.TempDir(), "desktop-audio-ctrl.sock")
+}
+
+// Server answers requests from local clients over a unix socket.
+type Server struct {
+	listener   net.Listener
+	controller control.Controller
+	hub        *control.Hub
+	logger     *slog.Logger
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Listen creates the socket at path and starts serving. A stale socket left
+// behind by a crashed daemon is removed; a live one is reported as an error.
+func Listen(path string, controller control.Controller, hub *control.Hub, logger *slog.Logger) (*Server, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+
+	s := &Server{
+		listener:   listener,
+		controller: controller,
+		hub:        hub,
+		logger:     logger,
+		conns:      make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the socket path the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting clients, disconnects existing ones and removes the
+// socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				s.logger.Error("control socket accept failed", "err", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	enc := json.NewEncoder(conn)
+
+	var req Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		enc.Encode(Response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	s.logger.Debug("control request", "command", req.Command, "combo", req.Combo)
+
+	if req.Command == CommandMonitor {
+		s.monitor(conn, enc)
+		return
+	}
+
+	enc.Encode(s.do(req))
+}
+
+func (s *Server) do(req Request) Response {
+	var (
+		status control.ComboStatus
+		err    error
+	)
+	switch req.Command {
+	case CommandList:
+		statuses, err := s.controller.Combos()
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{Status: statuses}
+	case CommandProfile:
+		var profiles []control.ProfileStatus
+		if req.Profile == "" {
+			profiles, err = s.controller.Profiles()
+		} else {
+			profiles, err = s.controller.SwitchProfile(req.Profile)
+		}
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{Profiles: profiles}
+	case CommandLog:
+		var levels []control.LogLevel
+		if req.Subsystem == "" {
+			levels, err = s.controller.LogLevels()
+		} else {
+			levels, err = s.controller.SetLogLevel(req.Subsystem, req.LogLevel)
+		}
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{LogLevels: levels}
+	case CommandGet:
+		status, err = s.controller.Get(req.Combo)
+	case CommandSet:
+		status, err = s.controller.SetLevel(req.Combo, req.Level)
+	case CommandMute:
+		if req.Muted == nil {
+			status, err = s.controller.ToggleMute(req.Combo)
+		} else {
+			status, err = s.controller.SetMute(req.Combo, *req.Muted)
+		}
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{Status: []control.ComboStatus{status}}
+}
+
+func (s *Server) monitor(conn net.Conn, enc *json.Encoder) {
+	updates, cancel := s.hub.Subscribe()
+	defer cancel()
+
+	// The client never sends anything after the request, so a read only
+	// returns once it hangs up.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case u := <-updates:
+			if err := enc.Encode(Response{Update: &u}); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// ErrNoDaemon is returned by Dial when no daemon is listening.
+var ErrNoDaemon = errors.New("daemon not running")
+
+// Client talks to a running daemon. It implements control.Controller so
+// commands work the same against the daemon and the backend.
+type Client struct {
+	path string
+}
+
+// Dial checks that a daemon is listening on path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoDaemon, err)
+	}
+	conn.Close()
+	return &Client{path: path}, nil
+}
+
+// Do sends a single request and waits for its response.
+func (c *Client) Do(req Request) ([]control.ComboStatus, error) {
+	resp, err := c.roundTrip(req)
+	return resp.Status, err
+}
+
+func (c *Client) roundTrip(req Request) (Response, error) {
+	conn, err := net.DialTimeout("unix", c.path, time.Second)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, err
+	}
+	if resp.Error != "" {
+		return Response{}, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (c *Client) one(req Request) (control.ComboStatus, error) {
+	status, err := c.Do(req)
+	if err != nil {
+		return control.ComboStatus{}, err
+	}
+	if len(status) != 1 {
+		return control.ComboStatus{}, fmt.Errorf("expected one status, got %d", len(status))
+	}
+	return status[0], nil
+}
+
+func (c *Client) Combos() ([]control.ComboStatus, error) {
+	return c.Do(Request{Command: CommandList})
+}
+
+func (c *Client) Get(combo uint8) (control.ComboStatus, error) {
+	return c.one(Request{Command: CommandGet, Combo: combo})
+}
+
+func (c *Client) SetLevel(combo uint8, level int) (control.ComboStatus, error) {
+	return c.one(Request{Command: CommandSet, Combo: combo, Level: level})
+}
+
+func (c *Client) SetMute(combo uint8, muted bool) (control.ComboStatus, error) {
+	return c.one(Request{Command: CommandMute, Combo: combo, Muted: &muted})
+}
+
+func (c *Client) ToggleMute(combo uint8) (control.ComboStatus, error) {
+	return c.one(Request{Command: CommandMute, Combo: combo})
+}
+
+func (c *Client) Profiles() ([]control.ProfileStatus, error) {
+	resp, err := c.roundTrip(Request{Command: CommandProfile})
+	return resp.Profiles, err
+}
+
+func (c *Client) SwitchProfile(name string) ([]control.ProfileStatus, error) {
+	resp, err := c.roundTrip(Request{Command: CommandProfile, Profile: name})
+	return resp.Profiles, err
+}
+
+func (c *Client) LogLevels() ([]control.LogLevel, error) {
+	resp, err := c.roundTrip(Request{Command: CommandLog})
+	return resp.LogLevels, err
+}
+
+func (c *Client) SetLogLevel(subsystem, level string) ([]control.LogLevel, error) {
+	resp, err := c.roundTrip(Request{Command: CommandLog, Subsystem: subsystem, LogLevel: level})
+	return resp.LogLevels, err
+}
+
+// Monitor streams updates to f until f returns false, done is closed or
+// the daemon goes away.
+func (c *Client) Monitor(done <-chan struct{}, f func(control.Update) bool) error {
+	conn, err := net.DialTimeout("unix", c.path, time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		conn.Close()
+	}()
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: CommandMonitor}); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return err
+			}
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if resp.Update != nil && !f(*resp.Update) {
+			return nil
+		}
+	}
+}
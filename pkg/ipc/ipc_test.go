@@ -0,0 +1,157 @@
+package ipc
+
+import (
+	"desktop-audio-ctrl/pkg/control"
+	"desktop-audio-ctrl/pkg/control/controltest"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T) (*Server, *control.Hub) {
+	t.Helper()
+	hub := control.NewHub()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "ctrl.sock")
+
+	s, err := Listen(path, controltest.New(), hub, logger)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, hub
+}
+
+func TestServer_Requests(t *testing.T) {
+	s, _ := startServer(t)
+
+	c, err := Dial(s.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+
+	status, err := c.Do(Request{Command: CommandSet, Combo: 1, Level: 42})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(status) != 1 || status[0].Level != 42 {
+		t.Errorf("Expected level 42, got %+v", status)
+	}
+
+	one, err := c.ToggleMute(1)
+	if err != nil || !one.Muted {
+		t.Errorf("Expected combo to be muted after toggle, got %+v (%v)", one, err)
+	}
+
+	one, err = c.SetMute(1, false)
+	if err != nil || one.Muted {
+		t.Errorf("Expected combo to be unmuted, got %+v (%v)", one, err)
+	}
+
+	status, err = c.Combos()
+	if err != nil || len(status) != 2 {
+		t.Errorf("Expected 2 combos, got %+v (%v)", status, err)
+	}
+
+	if _, err := c.Get(7); err == nil {
+		t.Errorf("Expected error for unknown combo")
+	}
+	if _, err := c.Do(Request{Command: "reboot"}); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+}
+
+func TestServer_Profiles(t *testing.T) {
+	s, _ := startServer(t)
+
+	c, err := Dial(s.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+
+	profiles, err := c.SwitchProfile("meeting")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Active || !profiles[1].Active {
+		t.Errorf("Expected 'meeting' to be active, got %+v", profiles)
+	}
+
+	profiles, err = c.Profiles()
+	if err != nil || len(profiles) != 2 || !profiles[1].Active {
+		t.Errorf("Expected 'meeting' to stay active, got %+v (%v)", profiles, err)
+	}
+
+	if _, err := c.SwitchProfile("music"); err == nil {
+		t.Errorf("Expected error for unknown profile")
+	}
+}
+
+func TestServer_LogLevels(t *testing.T) {
+	s, _ := startServer(t)
+
+	c, err := Dial(s.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+
+	levels, err := c.SetLogLevel("serial", "debug")
+	if err != nil || len(levels) != 1 || levels[0].Level != "debug" {
+		t.Errorf("Expected serial at debug, got %+v (%v)", levels, err)
+	}
+	levels, err = c.LogLevels()
+	if err != nil || len(levels) != 1 || levels[0].Level != "debug" {
+		t.Errorf("Expected serial to stay at debug, got %+v (%v)", levels, err)
+	}
+	if _, err := c.SetLogLevel("video", "debug"); err == nil {
+		t.Errorf("Expected error for unknown subsystem")
+	}
+}
+
+func TestServer_Monitor(t *testing.T) {
+	s, hub := startServer(t)
+
+	c, err := Dial(s.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+
+	received := make(chan control.Update, 1)
+	go c.Monitor(nil, func(u control.Update) bool {
+		received <- u
+		return false
+	})
+
+	// Publish until the subscription is in place.
+	deadline := time.After(2 * time.Second)
+	for {
+		hub.Publish(control.Update{Kind: control.KindVolume, Combo: 3, Level: 55})
+		select {
+		case u := <-received:
+			if u.Combo != 3 || u.Level != 55 || u.Kind != control.KindVolume {
+				t.Errorf("Unexpected update: %+v", u)
+			}
+			return
+		case <-deadline:
+			t.Fatalf("Timeout waiting for update")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestListen_SocketInUse(t *testing.T) {
+	s, _ := startServer(t)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := Listen(s.Addr(), controltest.New(), control.NewHub(), logger); err == nil {
+		t.Errorf("Expected error when socket is already served")
+	}
+}
+
+func TestDial_NoDaemon(t *testing.T) {
+	if _, err := Dial(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Errorf("Expected error without daemon")
+	}
+}
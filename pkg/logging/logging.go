@@ -0,0 +1,293 @@
+// Package logging sets up the daemon's logs: a file with rotation and/or
+// stderr, pretty or JSON, with a level per subsystem that can be changed
+// while running.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/dikkadev/prettyslog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Subsystems with their own level. Main covers everything else.
+const (
+	Main   = "main"
+	Serial = "serial"
+	Audio  = "audio"
+	Config = "config"
+)
+
+// Subsystems lists all subsystems.
+var Subsystems = []string{Main, Serial, Audio, Config}
+
+// Formats.
+const (
+	FormatPretty = "pretty"
+	FormatJSON   = "json"
+)
+
+// Options configures logging. The zero value logs pretty to stderr.
+type Options struct {
+	// File is written in addition to stderr. Empty disables it.
+	File string `yaml:"file,omitempty"`
+	// Stderr can be set to false to log to the file only.
+	Stderr *bool  `yaml:"stderr,omitempty"`
+	Format string `yaml:"format,omitempty"`
+	// The file is rotated once it exceeds MaxSizeMB, 10 by default. Rotated
+	// files are removed after MaxAgeDays or when there are more than
+	// MaxBackups of them; zero keeps them.
+	MaxSizeMB  int `yaml:"maxSizeMB,omitempty"`
+	MaxAgeDays int `yaml:"maxAgeDays,omitempty"`
+	MaxBackups int `yaml:"maxBackups,omitempty"`
+	// Level applies to subsystems without an entry in Levels.
+	Level  string            `yaml:"level,omitempty"`
+	Levels map[string]string `yaml:"levels,omitempty"`
+}
+
+// ParseLevel parses debug, info, warn or error, optionally with an offset
+// such as debug-4.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+// Validate checks the options without opening anything.
+func (o *Options) Validate() error {
+	var errs []error
+	switch o.Format {
+	case "", FormatPretty, FormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("unknown log format %q", o.Format))
+	}
+	if o.Stderr != nil && !*o.Stderr && o.File == "" {
+		errs = append(errs, errors.New("logging to neither stderr nor a file"))
+	}
+	if o.MaxSizeMB < 0 || o.MaxAgeDays < 0 || o.MaxBackups < 0 {
+		errs = append(errs, errors.New("log rotation limits must not be negative"))
+	}
+	if o.Level != "" {
+		if _, err := ParseLevel(o.Level); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for subsystem, level := range o.Levels {
+		if !slices.Contains(Subsystems, subsystem) {
+			errs = append(errs, fmt.Errorf("unknown log subsystem %q", subsystem))
+		}
+		if _, err := ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("log subsystem %s: %w", subsystem, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Logging owns the outputs and the subsystem levels.
+type Logging struct {
+	levels       map[string]*slog.LevelVar
+	defaultLevel slog.Level
+	outputs      Options
+	handler      slog.Handler
+	file         io.Closer
+}
+
+// New opens the outputs. Subsystems without a configured level log at
+// defaultLevel.
+func New(opts Options, defaultLevel slog.Level) (*Logging, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	l := &Logging{
+		levels:       make(map[string]*slog.LevelVar),
+		defaultLevel: defaultLevel,
+		outputs:      outputs(opts),
+	}
+	for _, subsystem := range Subsystems {
+		l.levels[subsystem] = new(slog.LevelVar)
+	}
+	l.setLevels(opts)
+
+	var handlers fanout
+	if opts.File != "" {
+		maxSize := opts.MaxSizeMB
+		if maxSize == 0 {
+			maxSize = 10
+		}
+		file := &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    maxSize,
+			MaxAge:     opts.MaxAgeDays,
+			MaxBackups: opts.MaxBackups,
+			LocalTime:  true,
+		}
+		l.file = file
+		handlers = append(handlers, newHandler(opts.Format, file, false))
+	}
+	if opts.Stderr == nil || *opts.Stderr {
+		handlers = append(handlers, newHandler(opts.Format, os.Stderr, true))
+	}
+	l.handler = handlers
+	return l, nil
+}
+
+// outputs returns opts without the levels.
+func outputs(opts Options) Options {
+	opts.Level, opts.Levels = "", nil
+	return opts
+}
+
+// setLevels sets every subsystem to its level in opts.
+func (l *Logging) setLevels(opts Options) {
+	defaultLevel := l.defaultLevel
+	if opts.Level != "" {
+		defaultLevel, _ = ParseLevel(opts.Level)
+	}
+	for _, subsystem := range Subsystems {
+		level := defaultLevel
+		if s, ok := opts.Levels[subsystem]; ok {
+			level, _ = ParseLevel(s)
+		}
+		l.levels[subsystem].Set(level)
+	}
+}
+
+// Reconfigure applies the levels of changed options, replacing levels set
+// while running. The outputs stay as they are; Reconfigure reports whether
+// opts change them, which takes a restart.
+func (l *Logging) Reconfigure(opts Options) (restart bool, err error) {
+	if err := opts.Validate(); err != nil {
+		return false, err
+	}
+	l.setLevels(opts)
+	return !reflect.DeepEqual(l.outputs, outputs(opts)), nil
+}
+
+// allLevels lets the outputs pass everything; the subsystem levels decide.
+var allLevels = slog.Level(-1 << 10)
+
+func newHandler(format string, w io.Writer, terminal bool) slog.Handler {
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: allLevels})
+	}
+	return prettyslog.NewPrettyslogHandler("5ac",
+		prettyslog.WithLevel(allLevels),
+		prettyslog.WithWriter(w),
+		prettyslog.WithColors(terminal),
+	)
+}
+
+// Logger returns the logger of a subsystem. Records of subsystems other
+// than Main carry a subsystem attribute.
+func (l *Logging) Logger(subsystem string) *slog.Logger {
+	level, ok := l.levels[subsystem]
+	if !ok {
+		level = l.levels[Main]
+	}
+	next := l.handler
+	if subsystem != Main {
+		next = next.WithAttrs([]slog.Attr{slog.String("subsystem", subsystem)})
+	}
+	return slog.New(&levelHandler{level: level, next: next})
+}
+
+// Levels returns the current level of every subsystem.
+func (l *Logging) Levels() map[string]slog.Level {
+	levels := make(map[string]slog.Level, len(l.levels))
+	for subsystem, level := range l.levels {
+		levels[subsystem] = level.Level()
+	}
+	return levels
+}
+
+// SetLevel changes a subsystem's level. It reports false for unknown
+// subsystems.
+func (l *Logging) SetLevel(subsystem string, level slog.Level) bool {
+	v, ok := l.levels[subsystem]
+	if ok {
+		v.Set(level)
+	}
+	return ok
+}
+
+// Close closes the log file.
+func (l *Logging) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// levelHandler filters records by a subsystem's level.
+type levelHandler struct {
+	level slog.Leveler
+	next  slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithGroup(name)}
+}
+
+// fanout writes records to several handlers.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	g := make(fanout, len(f))
+	for i, h := range f {
+		g[i] = h.WithAttrs(attrs)
+	}
+	return g
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	g := make(fanout, len(f))
+	for i, h := range f {
+		g[i] = h.WithGroup(name)
+	}
+	return g
+}
+
+// LevelName formats a level the way ParseLevel reads it.
+func LevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
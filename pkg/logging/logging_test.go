@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readRecords(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	stderr := false
+	l, err := New(Options{
+		File:   path,
+		Stderr: &stderr,
+		Format: FormatJSON,
+		Level:  "warn",
+		Levels: map[string]string{Serial: "debug"},
+	}, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer l.Close()
+
+	l.Logger(Main).Info("hidden")
+	l.Logger(Main).Warn("main warning")
+	l.Logger(Serial).Debug("serial debug")
+	l.Logger(Audio).Info("hidden")
+
+	if !l.SetLevel(Audio, slog.LevelDebug) {
+		t.Fatalf("Expected audio to be a known subsystem")
+	}
+	if l.SetLevel("video", slog.LevelDebug) {
+		t.Errorf("Expected unknown subsystem to be rejected")
+	}
+	l.Logger(Audio).Debug("audio debug")
+
+	records := readRecords(t, path)
+	var messages []string
+	for _, r := range records {
+		messages = append(messages, r["msg"].(string))
+	}
+	want := []string{"main warning", "serial debug", "audio debug"}
+	if strings.Join(messages, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected %v, got %v", want, messages)
+	}
+	if _, ok := records[0]["subsystem"]; ok {
+		t.Errorf("Expected no subsystem attribute for main")
+	}
+	if records[1]["subsystem"] != Serial {
+		t.Errorf("Expected subsystem serial, got %v", records[1]["subsystem"])
+	}
+
+	levels := l.Levels()
+	if levels[Main] != slog.LevelWarn || levels[Serial] != slog.LevelDebug || levels[Audio] != slog.LevelDebug {
+		t.Errorf("Unexpected levels: %v", levels)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	stderr := false
+	opts := Options{File: path, Stderr: &stderr, Levels: map[string]string{Serial: "debug"}}
+	l, err := New(opts, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer l.Close()
+	l.SetLevel(Audio, slog.LevelDebug)
+
+	opts.Level = "warn"
+	opts.Levels = map[string]string{Config: "error"}
+	restart, err := l.Reconfigure(opts)
+	if err != nil || restart {
+		t.Fatalf("Expected levels to apply without a restart, got %v, %v", restart, err)
+	}
+	levels := l.Levels()
+	if levels[Main] != slog.LevelWarn || levels[Serial] != slog.LevelWarn || levels[Audio] != slog.LevelWarn || levels[Config] != slog.LevelError {
+		t.Errorf("Unexpected levels: %v", levels)
+	}
+
+	// Without a level the default of New applies again.
+	opts.Level, opts.Levels = "", nil
+	l.Reconfigure(opts)
+	if level := l.Levels()[Main]; level != slog.LevelInfo {
+		t.Errorf("Expected info, got %v", level)
+	}
+
+	opts.Format = FormatJSON
+	if restart, _ := l.Reconfigure(opts); !restart {
+		t.Errorf("Expected a format change to need a restart")
+	}
+	opts.Format = "xml"
+	if _, err := l.Reconfigure(opts); err == nil {
+		t.Errorf("Expected an error for an invalid format")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	stderr := false
+	l, err := New(Options{File: filepath.Join(dir, "daemon.log"), Stderr: &stderr, MaxSizeMB: 1}, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer l.Close()
+
+	line := strings.Repeat("x", 1000)
+	for range 1100 {
+		l.Logger(Main).Info(line)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) < 2 {
+		t.Errorf("Expected the log to be rotated, got %d files", len(entries))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	stderr := false
+	o := Options{
+		Stderr:    &stderr,
+		Format:    "xml",
+		MaxSizeMB: -1,
+		Level:     "loud",
+		Levels:    map[string]string{"video": "info", Audio: "quiet"},
+	}
+	err := o.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation errors")
+	}
+	for _, want := range []string{"format", "neither stderr nor a file", "negative", "loud", "video", "audio"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{"debug": slog.LevelDebug, "WARN": slog.LevelWarn, "info+2": slog.LevelInfo + 2} {
+		if got, err := ParseLevel(s); err != nil || got != want {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", s, want, got, err)
+		}
+	}
+	if LevelName(slog.LevelWarn) != "warn" {
+		t.Errorf("Expected warn, got %s", LevelName(slog.LevelWarn))
+	}
+}
@@ -0,0 +1,208 @@
+// Package metrics exposes the daemon's health as Prometheus metrics.
+package metrics
+
+import (
+	"desktop-audio-ctrl/pkg/reliableserial"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultAddr is used when no listen address is configured.
+const DefaultAddr = "127.0.0.1:9273"
+
+const namespace = "desktop_audio_ctrl"
+
+// Backend operations.
+const (
+	OpGet = "get"
+	OpSet = "set"
+)
+
+// Metrics holds the daemon's metrics in a registry of its own.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	events         *prometheus.CounterVec
+	backendLatency *prometheus.HistogramVec
+	backendErrors  *prometheus.CounterVec
+	reloads        *prometheus.CounterVec
+	lastReload     prometheus.Gauge
+}
+
+// New creates the metrics, including the Go runtime and process ones.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_total",
+			Help:      "Events received from the device.",
+		}, []string{"type", "combo"}),
+		backendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_call_duration_seconds",
+			Help:      "Duration of audio backend volume calls.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"op", "combo", "device"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_call_errors_total",
+			Help:      "Failed audio backend volume calls.",
+		}, []string{"op", "combo", "device"}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reloads_total",
+			Help:      "Config reloads by result.",
+		}, []string{"result"}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Time of the last successful config reload.",
+		}),
+	}
+	// Results show up as zero before the first reload.
+	m.reloads.WithLabelValues("success")
+	m.reloads.WithLabelValues("failure")
+
+	m.registry.MustRegister(
+		m.events, m.backendLatency, m.backendErrors, m.reloads, m.lastReload,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func comboLabel(combo uint8) string {
+	return strconv.Itoa(int(combo))
+}
+
+// Event counts an event received from the device.
+func (m *Metrics) Event(eventType string, combo uint8) {
+	m.events.WithLabelValues(eventType, comboLabel(combo)).Inc()
+}
+
+// Backend records a backend call that started at start.
+func (m *Metrics) Backend(op string, combo uint8, device string, start time.Time, err error) {
+	labels := []string{op, comboLabel(combo), device}
+	m.backendLatency.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.backendErrors.WithLabelValues(labels...).Inc()
+	}
+}
+
+// Reload records the outcome of a config reload.
+func (m *Metrics) Reload(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	m.reloads.WithLabelValues("success").Inc()
+	m.lastReload.SetToCurrentTime()
+}
+
+// Link exports the statistics of a serial link, read on every scrape.
+func (m *Metrics) Link(stats func() reliableserial.Stats) {
+	m.registry.MustRegister(&linkCollector{stats: stats})
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	linkUp = prometheus.NewDesc(namespace+"_serial_connected",
+		"Whether the device is connected.", []string{"device"}, nil)
+	linkConnects = prometheus.NewDesc(namespace+"_serial_connects_total",
+		"Connections to the device.", []string{"device"}, nil)
+	linkReconnects = prometheus.NewDesc(namespace+"_serial_reconnects_total",
+		"Connections to the device after the first one.", []string{"device"}, nil)
+	linkBadFrames = prometheus.NewDesc(namespace+"_serial_bad_frames_total",
+		"Received frames that could not be decoded.", []string{"device"}, nil)
+	linkDropped = prometheus.NewDesc(namespace+"_serial_dropped_total",
+		"Received messages dropped because the queue was full.", []string{"device"}, nil)
+	linkQueue = prometheus.NewDesc(namespace+"_serial_queue_depth",
+		"Messages waiting to be sent or handled.", []string{"device", "direction"}, nil)
+	linkRTT = prometheus.NewDesc(namespace+"_serial_rtt_seconds",
+		"Round trip time of the last answered ping.", []string{"device"}, nil)
+	linkPingTimeouts = prometheus.NewDesc(namespace+"_serial_ping_timeouts_total",
+		"Connections dropped because the device stopped answering pings.", []string{"device"}, nil)
+)
+
+type linkCollector struct {
+	stats func() reliableserial.Stats
+}
+
+func (c *linkCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{linkUp, linkConnects, linkReconnects, linkBadFrames, linkDropped, linkQueue, linkRTT, linkPingTimeouts} {
+		ch <- d
+	}
+}
+
+func (c *linkCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	up := 0.0
+	if s.Connected {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(linkUp, prometheus.GaugeValue, up, s.Device)
+	ch <- prometheus.MustNewConstMetric(linkConnects, prometheus.CounterValue, float64(s.Connects), s.Device)
+	ch <- prometheus.MustNewConstMetric(linkReconnects, prometheus.CounterValue, float64(s.Reconnects), s.Device)
+	ch <- prometheus.MustNewConstMetric(linkBadFrames, prometheus.CounterValue, float64(s.BadFrames), s.Device)
+	ch <- prometheus.MustNewConstMetric(linkDropped, prometheus.CounterValue, float64(s.Dropped), s.Device)
+	ch <- prometheus.MustNewConstMetric(linkQueue, prometheus.GaugeValue, float64(s.SendQueue), s.Device, "send")
+	ch <- prometheus.MustNewConstMetric(linkQueue, prometheus.GaugeValue, float64(s.ReceiveQueue), s.Device, "receive")
+	ch <- prometheus.MustNewConstMetric(linkRTT, prometheus.GaugeValue, s.RTT.Seconds(), s.Device)
+	ch <- prometheus.MustNewConstMetric(linkPingTimeouts, prometheus.CounterValue, float64(s.PingTimeouts), s.Device)
+}
+
+// Server serves /metrics over HTTP.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts serving the metrics on addr.
+func (m *Metrics) Listen(addr string, logger *slog.Logger) (*Server, error) {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+	s := &Server{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server stopped", "err", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
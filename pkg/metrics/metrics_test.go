@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"desktop-audio-ctrl/pkg/reliableserial"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.Link(func() reliableserial.Stats {
+		return reliableserial.Stats{Device: "COM11", Connected: true, Connects: 3, Reconnects: 2, BadFrames: 4, SendQueue: 5, RTT: 1500 * time.Microsecond, PingTimeouts: 1}
+	})
+
+	m.Event("cw", 2)
+	m.Event("cw", 2)
+	m.Event("click", 0)
+	m.Backend(OpSet, 2, "dev-a", time.Now(), nil)
+	m.Backend(OpGet, 2, "dev-a", time.Now(), errors.New("gone"))
+	m.Reload(nil)
+	m.Reload(errors.New("invalid"))
+	m.Reload(errors.New("invalid"))
+
+	body := scrape(t, m.Handler())
+	for _, want := range []string{
+		`desktop_audio_ctrl_events_total{combo="2",type="cw"} 2`,
+		`desktop_audio_ctrl_events_total{combo="0",type="click"} 1`,
+		`desktop_audio_ctrl_backend_call_duration_seconds_count{combo="2",device="dev-a",op="set"} 1`,
+		`desktop_audio_ctrl_backend_call_errors_total{combo="2",device="dev-a",op="get"} 1`,
+		`desktop_audio_ctrl_config_reloads_total{result="success"} 1`,
+		`desktop_audio_ctrl_config_reloads_total{result="failure"} 2`,
+		`desktop_audio_ctrl_serial_connected{device="COM11"} 1`,
+		`desktop_audio_ctrl_serial_reconnects_total{device="COM11"} 2`,
+		`desktop_audio_ctrl_serial_bad_frames_total{device="COM11"} 4`,
+		`desktop_audio_ctrl_serial_queue_depth{device="COM11",direction="send"} 5`,
+		`desktop_audio_ctrl_serial_rtt_seconds{device="COM11"} 0.0015`,
+		`desktop_audio_ctrl_serial_ping_timeouts_total{device="COM11"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	s, err := New().Listen("127.0.0.1:0", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "desktop_audio_ctrl_config_reloads_total") {
+		t.Errorf("Expected metrics, got %d: %.200s", resp.StatusCode, body)
+	}
+}
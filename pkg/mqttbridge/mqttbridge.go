@@ -0,0 +1,371 @@
+package mqttbridge
+
+import (
+	"desktop-audio-ctrl/pkg/control"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Payloads used for availability and switch states.
+const (
+	Online  = "online"
+	Offline = "offline"
+	On      = "ON"
+	Off     = "OFF"
+)
+
+// Options configures a Bridge.
+type Options struct {
+	Broker   string
+	Username string
+	Password string
+	ClientID string
+	// TopicPrefix is prepended to all state and command topics.
+	TopicPrefix string
+	// DiscoveryPrefix is where Home Assistant looks for discovery
+	// payloads, "homeassistant" unless changed there.
+	DiscoveryPrefix string
+	// NodeID identifies this controller in unique IDs and discovery topics.
+	NodeID string
+}
+
+func (o *Options) setDefaults() {
+	if o.ClientID == "" {
+		o.ClientID = "desktop-audio-ctrl"
+	}
+	if o.TopicPrefix == "" {
+		o.TopicPrefix = "desktop-audio-ctrl"
+	}
+	if o.DiscoveryPrefix == "" {
+		o.DiscoveryPrefix = "homeassistant"
+	}
+	if o.NodeID == "" {
+		o.NodeID = "desktop_audio_ctrl"
+	}
+}
+
+// message is a single MQTT publication.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// Bridge mirrors combo levels and mute states to MQTT and applies commands
+// received on the command topics.
+type Bridge struct {
+	opts       Options
+	controller control.Controller
+	logger     *slog.Logger
+	client     mqtt.Client
+
+	mu sync.Mutex
+	// announced holds the combos with a discovery payload on the broker.
+	announced map[uint8]bool
+
+	cancel func()
+	done   chan struct{}
+}
+
+// New creates a bridge. It does not connect until Start is called.
+func New(opts Options, controller control.Controller, logger *slog.Logger) *Bridge {
+	opts.setDefaults()
+	return &Bridge{
+		opts:       opts,
+		controller: controller,
+		logger:     logger,
+		announced:  make(map[uint8]bool),
+	}
+}
+
+func (b *Bridge) availabilityTopic() string {
+	return b.opts.TopicPrefix + "/status"
+}
+
+func (b *Bridge) comboTopic(combo uint8, name string) string {
+	return fmt.Sprintf("%s/combo/%d/%s", b.opts.TopicPrefix, combo, name)
+}
+
+func (b *Bridge) discoveryTopic(component string, combo uint8, object string) string {
+	return fmt.Sprintf("%s/%s/%s/combo%d_%s/config", b.opts.DiscoveryPrefix, component, b.opts.NodeID, combo, object)
+}
+
+// Start connects to the broker and mirrors hub updates until Close. The
+// client reconnects by itself and republishes everything on every
+// connect, so Start only fails on invalid options.
+func (b *Bridge) Start(hub *control.Hub) error {
+	if b.opts.Broker == "" {
+		return errors.New("no mqtt broker configured")
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.opts.Broker).
+		SetClientID(b.opts.ClientID).
+		SetUsername(b.opts.Username).
+		SetPassword(b.opts.Password).
+		SetWill(b.availabilityTopic(), Offline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(mqtt.Client) {
+			b.logger.Info("mqtt connected", "broker", b.opts.Broker)
+			b.announce()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			b.logger.Warn("mqtt connection lost", "err", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	b.client.Connect()
+
+	updates, cancel := hub.Subscribe()
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		for u := range updates {
+			b.handleUpdate(u)
+		}
+	}()
+	return nil
+}
+
+// Close marks the controller offline and disconnects.
+func (b *Bridge) Close() {
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+	}
+	if b.client != nil {
+		if b.client.IsConnected() {
+			b.client.Publish(b.availabilityTopic(), 1, true, Offline).WaitTimeout(time.Second)
+		}
+		b.client.Disconnect(250)
+	}
+}
+
+func (b *Bridge) publish(messages []message) {
+	if b.client == nil || !b.client.IsConnectionOpen() {
+		return
+	}
+	for _, m := range messages {
+		// Retained so Home Assistant sees the state after restarting.
+		b.client.Publish(m.topic, 1, true, m.payload)
+	}
+}
+
+// announce subscribes to the command topics and publishes availability,
+// discovery payloads and current states.
+func (b *Bridge) announce() {
+	filters := map[string]byte{
+		b.opts.TopicPrefix + "/combo/+/level/set": 1,
+		b.opts.TopicPrefix + "/combo/+/mute/set":  1,
+	}
+	b.client.SubscribeMultiple(filters, func(_ mqtt.Client, m mqtt.Message) {
+		if err := b.handleCommand(m.Topic(), m.Payload()); err != nil {
+			b.logger.Warn("mqtt command failed", "topic", m.Topic(), "err", err)
+		}
+	})
+
+	b.publish([]message{{b.availabilityTopic(), []byte(Online)}})
+	b.refresh()
+}
+
+// refresh publishes discovery payloads and states for the current combos
+// and removes entities of combos that went away, e.g. after a profile
+// switch.
+func (b *Bridge) refresh() {
+	messages, err := b.refreshMessages()
+	if err != nil {
+		b.logger.Warn("mqtt refresh failed", "err", err)
+		return
+	}
+	b.publish(messages)
+}
+
+func (b *Bridge) refreshMessages() ([]message, error) {
+	statuses, err := b.controller.Combos()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := make(map[uint8]bool)
+	var messages []message
+	for _, s := range statuses {
+		current[s.Combo] = true
+		messages = append(messages, b.discovery(s)...)
+		if s.Error == "" {
+			messages = append(messages, b.state(s.Combo, s.Level, s.Muted)...)
+		}
+	}
+	for combo := range b.announced {
+		if !current[combo] {
+			messages = append(messages, b.removal(combo)...)
+		}
+	}
+	b.announced = current
+	return messages, nil
+}
+
+func (b *Bridge) handleUpdate(u control.Update) {
+	switch u.Kind {
+	case control.KindVolume, control.KindMute:
+		b.publish(b.state(u.Combo, u.Level, u.Muted))
+	case control.KindProfile:
+		b.refresh()
+	}
+}
+
+func (b *Bridge) state(combo uint8, level int, muted bool) []message {
+	mute := Off
+	if muted {
+		mute = On
+	}
+	return []message{
+		{b.comboTopic(combo, "level"), []byte(strconv.Itoa(level))},
+		{b.comboTopic(combo, "mute"), []byte(mute)},
+	}
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type discoveryPayload struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id"`
+	StateTopic        string          `json:"state_topic"`
+	CommandTopic      string          `json:"command_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+	Icon              string          `json:"icon,omitempty"`
+
+	// number
+	Min               *float64 `json:"min,omitempty"`
+	Max               *float64 `json:"max,omitempty"`
+	Step              *float64 `json:"step,omitempty"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	Mode              string   `json:"mode,omitempty"`
+
+	// switch
+	PayloadOn  string `json:"payload_on,omitempty"`
+	PayloadOff string `json:"payload_off,omitempty"`
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+// discovery returns the Home Assistant discovery payloads for a combo: a
+// number entity for the level and a switch for mute.
+func (b *Bridge) discovery(s control.ComboStatus) []message {
+	name := s.Name
+	if name == "" {
+		name = fmt.Sprintf("Combo %d", s.Combo)
+	}
+	device := discoveryDevice{
+		Identifiers:  []string{b.opts.NodeID},
+		Name:         "Desktop Audio Control",
+		Manufacturer: "dikkadev",
+		Model:        "desktop-audio-ctrl",
+	}
+
+	level := discoveryPayload{
+		Name:              name + " level",
+		UniqueID:          fmt.Sprintf("%s_combo%d_level", b.opts.NodeID, s.Combo),
+		ObjectID:          fmt.Sprintf("%s_combo%d_level", b.opts.NodeID, s.Combo),
+		StateTopic:        b.comboTopic(s.Combo, "level"),
+		CommandTopic:      b.comboTopic(s.Combo, "level/set"),
+		AvailabilityTopic: b.availabilityTopic(),
+		Device:            device,
+		Icon:              "mdi:volume-high",
+		Min:               ptr(0),
+		Max:               ptr(100),
+		Step:              ptr(1),
+		UnitOfMeasurement: "%",
+		Mode:              "slider",
+	}
+	mute := discoveryPayload{
+		Name:              name + " mute",
+		UniqueID:          fmt.Sprintf("%s_combo%d_mute", b.opts.NodeID, s.Combo),
+		ObjectID:          fmt.Sprintf("%s_combo%d_mute", b.opts.NodeID, s.Combo),
+		StateTopic:        b.comboTopic(s.Combo, "mute"),
+		CommandTopic:      b.comboTopic(s.Combo, "mute/set"),
+		AvailabilityTopic: b.availabilityTopic(),
+		Device:            device,
+		Icon:              "mdi:volume-off",
+		PayloadOn:         On,
+		PayloadOff:        Off,
+	}
+
+	levelJSON, _ := json.Marshal(level)
+	muteJSON, _ := json.Marshal(mute)
+	return []message{
+		{b.discoveryTopic("number", s.Combo, "level"), levelJSON},
+		{b.discoveryTopic("switch", s.Combo, "mute"), muteJSON},
+	}
+}
+
+// removal deletes a combo's entities from Home Assistant by clearing its
+// retained discovery payloads.
+func (b *Bridge) removal(combo uint8) []message {
+	return []message{
+		{b.discoveryTopic("number", combo, "level"), nil},
+		{b.discoveryTopic("switch", combo, "mute"), nil},
+	}
+}
+
+// handleCommand applies a message received on a command topic.
+func (b *Bridge) handleCommand(topic string, payload []byte) error {
+	rest, ok := strings.CutPrefix(topic, b.opts.TopicPrefix+"/combo/")
+	if !ok {
+		return fmt.Errorf("unexpected topic")
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[2] != "set" {
+		return fmt.Errorf("unexpected topic")
+	}
+	n, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid combo %q", parts[0])
+	}
+	combo := uint8(n)
+	value := strings.TrimSpace(string(payload))
+
+	switch parts[1] {
+	case "level":
+		// Home Assistant sends numbers as floats.
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 100 {
+			return fmt.Errorf("invalid level %q", value)
+		}
+		_, err = b.controller.SetLevel(combo, int(math.Round(f)))
+		return err
+	case "mute":
+		switch strings.ToUpper(value) {
+		case On:
+			_, err = b.controller.SetMute(combo, true)
+		case Off:
+			_, err = b.controller.SetMute(combo, false)
+		default:
+			return fmt.Errorf("invalid mute state %q", value)
+		}
+		return err
+	default:
+		return fmt.Errorf("unexpected topic")
+	}
+}
@@ -0,0 +1,188 @@
+package mqttbridge
+
+import (
+	"desktop-audio-ctrl/pkg/control"
+	"desktop-audio-ctrl/pkg/control/controltest"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestDiscovery(t *testing.T) {
+	b := New(Options{}, controltest.New(), testLogger())
+
+	messages := b.discovery(control.ComboStatus{Combo: 2, Name: "Chat"})
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 discovery messages, got %d", len(messages))
+	}
+	if messages[0].topic != "homeassistant/number/desktop_audio_ctrl/combo2_level/config" {
+		t.Errorf("Unexpected number topic: %s", messages[0].topic)
+	}
+	if messages[1].topic != "homeassistant/switch/desktop_audio_ctrl/combo2_mute/config" {
+		t.Errorf("Unexpected switch topic: %s", messages[1].topic)
+	}
+
+	var number map[string]any
+	if err := json.Unmarshal(messages[0].payload, &number); err != nil {
+		t.Fatalf("Invalid number payload: %v", err)
+	}
+	want := map[string]any{
+		"name":          "Chat level",
+		"command_topic": "desktop-audio-ctrl/combo/2/level/set",
+		"state_topic":   "desktop-audio-ctrl/combo/2/level",
+		"min":           float64(0),
+		"max":           float64(100),
+	}
+	for k, v := range want {
+		if number[k] != v {
+			t.Errorf("Expected number %s = %v, got %v", k, v, number[k])
+		}
+	}
+
+	var sw map[string]any
+	json.Unmarshal(messages[1].payload, &sw)
+	if sw["payload_on"] != On || sw["command_topic"] != "desktop-audio-ctrl/combo/2/mute/set" {
+		t.Errorf("Unexpected switch payload: %s", messages[1].payload)
+	}
+}
+
+func TestRefreshRemovesGoneCombos(t *testing.T) {
+	m := controltest.New()
+	b := New(Options{}, m, testLogger())
+
+	if _, err := b.refreshMessages(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	m.RemoveCombo(1)
+	messages, err := b.refreshMessages()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	removed := 0
+	for _, msg := range messages {
+		if msg.payload == nil {
+			removed++
+			if msg.topic != "homeassistant/number/desktop_audio_ctrl/combo1_level/config" &&
+				msg.topic != "homeassistant/switch/desktop_audio_ctrl/combo1_mute/config" {
+				t.Errorf("Unexpected removal: %s", msg.topic)
+			}
+		}
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 removals, got %d", removed)
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	m := controltest.New()
+	b := New(Options{}, m, testLogger())
+
+	if err := b.handleCommand("desktop-audio-ctrl/combo/1/level/set", []byte("42.4")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := m.Level(1); got != 42 {
+		t.Errorf("Expected level 42, got %d", got)
+	}
+
+	if err := b.handleCommand("desktop-audio-ctrl/combo/1/mute/set", []byte("ON")); err != nil || !m.Muted(1) {
+		t.Errorf("Expected combo 1 muted, got %v (%v)", m.Muted(1), err)
+	}
+
+	for topic, payload := range map[string]string{
+		"desktop-audio-ctrl/combo/1/level/set": "loud",
+		"desktop-audio-ctrl/combo/x/level/set": "1",
+		"desktop-audio-ctrl/combo/1/mute/set":  "maybe",
+		"desktop-audio-ctrl/combo/1/bass/set":  "1",
+		"other/combo/1/level/set":              "1",
+	} {
+		if err := b.handleCommand(topic, []byte(payload)); err == nil {
+			t.Errorf("Expected error for %s %q", topic, payload)
+		}
+	}
+}
+
+// testBroker returns the broker used by the integration test, skipping it
+// when none is reachable. Run e.g. `mosquitto -p 1883` to enable it.
+func testBroker(t *testing.T) string {
+	t.Helper()
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		broker = "tcp://127.0.0.1:1883"
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		t.Fatalf("Invalid MQTT_TEST_BROKER: %v", err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("no MQTT broker at %s: %v", broker, err)
+	}
+	conn.Close()
+	return broker
+}
+
+func TestBridge_Broker(t *testing.T) {
+	broker := testBroker(t)
+	prefix := "desktop-audio-ctrl-test-" + time.Now().Format("150405.000000")
+
+	m := controltest.New()
+	hub := control.NewHub()
+	b := New(Options{Broker: broker, ClientID: prefix + "-bridge", TopicPrefix: prefix, DiscoveryPrefix: prefix + "-ha"}, m, testLogger())
+	if err := b.Start(hub); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer b.Close()
+
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-test"))
+	if tok := c.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("Failed to connect: %v", tok.Error())
+	}
+	defer c.Disconnect(100)
+
+	received := make(chan mqtt.Message, 32)
+	c.Subscribe(prefix+"/#", 1, func(_ mqtt.Client, msg mqtt.Message) { received <- msg })
+	c.Subscribe(prefix+"-ha/#", 1, func(_ mqtt.Client, msg mqtt.Message) { received <- msg })
+
+	wait := func(topic, payload string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case msg := <-received:
+				if msg.Topic() == topic && (payload == "" || string(msg.Payload()) == payload) {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("Timeout waiting for %s %q", topic, payload)
+			}
+		}
+	}
+
+	wait(prefix+"-ha/number/desktop_audio_ctrl/combo0_level/config", "")
+	wait(prefix+"/combo/1/level", "20")
+
+	// Commands are applied and the resulting state change is mirrored.
+	c.Publish(prefix+"/combo/0/level/set", 1, false, "55")
+	deadline := time.Now().Add(5 * time.Second)
+	for level, _ := m.Level(0); level != 55; level, _ = m.Level(0) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected level command to be applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hub.Publish(control.Update{Kind: control.KindMute, Combo: 0, Level: 55, Muted: true})
+	wait(prefix+"/combo/0/mute", On)
+}
@@ -0,0 +1,198 @@
+package osc
+
+import (
+	"desktop-audio-ctrl/pkg/control"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+)
+
+// Route holds the OSC addresses of a combo. Empty addresses are not used.
+type Route struct {
+	Combo uint8
+	// Level carries the level as a float from 0 to 1.
+	Level string
+	// Mute carries the mute state as 1 or 0.
+	Mute string
+}
+
+// Options configures a Bridge.
+type Options struct {
+	// Listen is the UDP address incoming messages are read from. Empty
+	// disables input.
+	Listen string
+	// Send lists the UDP addresses that receive level and mute changes.
+	Send []string
+	// Routes returns the current routes. It is called for every message so
+	// it follows config reloads and profile switches.
+	Routes     func() []Route
+	Controller control.Controller
+	Logger     *slog.Logger
+}
+
+// Bridge sends combo changes as OSC messages and applies incoming ones.
+type Bridge struct {
+	opts    Options
+	conn    *net.UDPConn
+	targets []*net.UDPAddr
+
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+// New creates a bridge. It does not open sockets until Start is called.
+func New(opts Options) *Bridge {
+	return &Bridge{opts: opts}
+}
+
+// Start opens the sockets and runs until Close.
+func (b *Bridge) Start(hub *control.Hub) error {
+	if b.opts.Listen == "" && len(b.opts.Send) == 0 {
+		return errors.New("neither osc listen nor send addresses configured")
+	}
+	for _, addr := range b.opts.Send {
+		target, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return fmt.Errorf("invalid osc send address %q: %w", addr, err)
+		}
+		b.targets = append(b.targets, target)
+	}
+
+	// Replies go out from the listening port, which mixers that answer
+	// to the sender's port expect.
+	var laddr *net.UDPAddr
+	if b.opts.Listen != "" {
+		var err error
+		if laddr, err = net.ResolveUDPAddr("udp", b.opts.Listen); err != nil {
+			return fmt.Errorf("invalid osc listen address %q: %w", b.opts.Listen, err)
+		}
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+
+	if b.opts.Listen != "" {
+		b.wg.Add(1)
+		go b.receive()
+	}
+
+	updates, cancel := hub.Subscribe()
+	b.cancel = cancel
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for u := range updates {
+			b.handleUpdate(u)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the local address of the socket.
+func (b *Bridge) Addr() net.Addr {
+	return b.conn.LocalAddr()
+}
+
+// Close stops the bridge.
+func (b *Bridge) Close() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.wg.Wait()
+}
+
+func (b *Bridge) route(combo uint8) (Route, bool) {
+	for _, r := range b.opts.Routes() {
+		if r.Combo == combo {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+func (b *Bridge) handleUpdate(u control.Update) {
+	r, ok := b.route(u.Combo)
+	if !ok {
+		return
+	}
+	switch {
+	case u.Kind == control.KindVolume && r.Level != "":
+		b.send(Message{Address: r.Level, Args: []any{float32(u.Level) / 100}})
+	case u.Kind == control.KindMute && r.Mute != "":
+		muted := int32(0)
+		if u.Muted {
+			muted = 1
+		}
+		b.send(Message{Address: r.Mute, Args: []any{muted}})
+	}
+}
+
+func (b *Bridge) send(m Message) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		b.opts.Logger.Warn("invalid osc message", "message", m.String(), "err", err)
+		return
+	}
+	for _, target := range b.targets {
+		if _, err := b.conn.WriteToUDP(data, target); err != nil {
+			b.opts.Logger.Warn("error sending osc message", "target", target.String(), "err", err)
+		}
+	}
+}
+
+func (b *Bridge) receive() {
+	defer b.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			b.opts.Logger.Warn("error reading osc packet", "err", err)
+			continue
+		}
+		messages, err := Parse(buf[:n])
+		if err != nil {
+			b.opts.Logger.Debug("invalid osc packet", "from", from.String(), "err", err)
+			continue
+		}
+		for _, m := range messages {
+			if err := b.handleMessage(m); err != nil {
+				b.opts.Logger.Warn("osc message failed", "message", m.String(), "err", err)
+			}
+		}
+	}
+}
+
+// handleMessage applies a message addressed to one of the routes. Messages
+// for other addresses are ignored.
+func (b *Bridge) handleMessage(m Message) error {
+	for _, r := range b.opts.Routes() {
+		switch m.Address {
+		case r.Level:
+			f, ok := m.Float(0)
+			if !ok || f < 0 || f > 1 {
+				return fmt.Errorf("level must be a number within 0-1")
+			}
+			_, err := b.opts.Controller.SetLevel(r.Combo, int(math.Round(f*100)))
+			return err
+		case r.Mute:
+			muted, ok := m.Bool(0)
+			if !ok {
+				return fmt.Errorf("mute must be a boolean or number")
+			}
+			_, err := b.opts.Controller.SetMute(r.Combo, muted)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Package osc implements the parts of Open Sound Control 1.0 needed to talk
+// to DAWs and software mixers over UDP: messages with int, float, string,
+// blob and boolean arguments, and receiving bundles.
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var bundleTag = []byte("#bundle\x00")
+
+// Message is a single OSC message. Arguments are int32, int64, float32,
+// float64, string, []byte or bool.
+type Message struct {
+	Address string
+	Args    []any
+}
+
+func (m Message) String() string {
+	parts := []string{m.Address}
+	for _, a := range m.Args {
+		parts = append(parts, fmt.Sprint(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, s...)
+	// At least one terminating zero, padded to four bytes.
+	return append(b, make([]byte, 4-len(s)%4)...)
+}
+
+func appendBlob(b []byte, blob []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(blob)))
+	b = append(b, blob...)
+	return append(b, make([]byte, (4-len(blob)%4)%4)...)
+}
+
+// MarshalBinary encodes the message.
+func (m Message) MarshalBinary() ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("invalid address %q", m.Address)
+	}
+
+	tags := []byte{','}
+	var args []byte
+	for _, a := range m.Args {
+		switch v := a.(type) {
+		case int32:
+			tags = append(tags, 'i')
+			args = binary.BigEndian.AppendUint32(args, uint32(v))
+		case int:
+			tags = append(tags, 'i')
+			args = binary.BigEndian.AppendUint32(args, uint32(int32(v)))
+		case int64:
+			tags = append(tags, 'h')
+			args = binary.BigEndian.AppendUint64(args, uint64(v))
+		case float32:
+			tags = append(tags, 'f')
+			args = binary.BigEndian.AppendUint32(args, math.Float32bits(v))
+		case float64:
+			tags = append(tags, 'd')
+			args = binary.BigEndian.AppendUint64(args, math.Float64bits(v))
+		case string:
+			tags = append(tags, 's')
+			args = appendString(args, v)
+		case []byte:
+			tags = append(tags, 'b')
+			args = appendBlob(args, v)
+		case bool:
+			if v {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		default:
+			return nil, fmt.Errorf("unsupported argument type %T", a)
+		}
+	}
+
+	b := appendString(nil, m.Address)
+	b = appendString(b, string(tags))
+	return append(b, args...), nil
+}
+
+// reader consumes an OSC packet.
+type reader struct {
+	data []byte
+}
+
+var errShort = errors.New("packet too short")
+
+func (r *reader) string() (string, error) {
+	i := bytes.IndexByte(r.data, 0)
+	if i < 0 {
+		return "", errors.New("unterminated string")
+	}
+	n := (i/4 + 1) * 4
+	if n > len(r.data) {
+		return "", errShort
+	}
+	s := string(r.data[:i])
+	r.data = r.data[n:]
+	return s, nil
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data) {
+		return nil, errShort
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *reader) uint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func parseMessage(data []byte) (Message, error) {
+	r := &reader{data: data}
+	address, err := r.string()
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return Message{}, fmt.Errorf("invalid address %q", address)
+	}
+	m := Message{Address: address}
+
+	// Very old implementations omit the type tags.
+	if len(r.data) == 0 {
+		return m, nil
+	}
+	tags, err := r.string()
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return Message{}, fmt.Errorf("invalid type tags %q", tags)
+	}
+
+	for _, tag := range tags[1:] {
+		var arg any
+		switch tag {
+		case 'i':
+			var v uint32
+			v, err = r.uint32()
+			arg = int32(v)
+		case 'h':
+			var v uint64
+			v, err = r.uint64()
+			arg = int64(v)
+		case 'f':
+			var v uint32
+			v, err = r.uint32()
+			arg = math.Float32frombits(v)
+		case 'd':
+			var v uint64
+			v, err = r.uint64()
+			arg = math.Float64frombits(v)
+		case 's', 'S':
+			arg, err = r.string()
+		case 'b':
+			var n uint32
+			if n, err = r.uint32(); err == nil {
+				var blob []byte
+				if blob, err = r.next(int(n)); err == nil {
+					arg = bytes.Clone(blob)
+					_, err = r.next((4 - int(n)%4) % 4)
+				}
+			}
+		case 'T':
+			arg = true
+		case 'F':
+			arg = false
+		case 'N', 'I':
+			continue
+		default:
+			return Message{}, fmt.Errorf("unsupported type tag %q", tag)
+		}
+		if err != nil {
+			return Message{}, err
+		}
+		m.Args = append(m.Args, arg)
+	}
+	return m, nil
+}
+
+// Parse decodes a packet into its messages. Bundles are flattened and their
+// time tags ignored, so bundled messages apply immediately.
+func Parse(data []byte) ([]Message, error) {
+	if !bytes.HasPrefix(data, bundleTag) {
+		m, err := parseMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		return []Message{m}, nil
+	}
+
+	// Skip the tag and the time tag.
+	r := &reader{data: data}
+	if _, err := r.next(len(bundleTag) + 8); err != nil {
+		return nil, err
+	}
+	var messages []Message
+	for len(r.data) > 0 {
+		n, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		element, err := r.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		inner, err := Parse(element)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, inner...)
+	}
+	return messages, nil
+}
+
+// Float returns argument i as a float, accepting any numeric type.
+func (m Message) Float(i int) (float64, bool) {
+	if i >= len(m.Args) {
+		return 0, false
+	}
+	switch v := m.Args[i].(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Bool returns argument i as a boolean, accepting booleans and numbers.
+func (m Message) Bool(i int) (bool, bool) {
+	if i < len(m.Args) {
+		if v, ok := m.Args[i].(bool); ok {
+			return v, true
+		}
+	}
+	f, ok := m.Float(i)
+	return f != 0, ok
+}
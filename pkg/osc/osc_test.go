@@ -0,0 +1,172 @@
+package osc
+
+import (
+	"bytes"
+	"desktop-audio-ctrl/pkg/control"
+	"desktop-audio-ctrl/pkg/control/controltest"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	m := Message{Address: "/combo/2/level", Args: []any{float32(0.5)}}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []byte("/combo/2/level\x00\x00,f\x00\x00\x3f\x00\x00\x00")
+	if !bytes.Equal(data, want) {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+
+	if _, err := (Message{Address: "combo"}).MarshalBinary(); err == nil {
+		t.Errorf("Expected error for address without leading slash")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := Message{
+		Address: "/mixer/ch1",
+		Args:    []any{int32(-3), int64(1 << 40), float32(0.25), 0.125, "abcd", []byte{1, 2, 3}, true, false},
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(data)%4 != 0 {
+		t.Errorf("Expected packet aligned to 4 bytes, got %d bytes", len(data))
+	}
+
+	messages, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(messages) != 1 || !reflect.DeepEqual(messages[0], m) {
+		t.Errorf("Expected %v, got %v", m, messages)
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	var bundle []byte
+	bundle = append(bundle, bundleTag...)
+	bundle = append(bundle, 0, 0, 0, 0, 0, 0, 0, 1)
+	for _, m := range []Message{
+		{Address: "/a", Args: []any{int32(1)}},
+		{Address: "/b", Args: []any{"x"}},
+	} {
+		data, _ := m.MarshalBinary()
+		bundle = append(bundle, 0, 0, 0, byte(len(data)))
+		bundle = append(bundle, data...)
+	}
+
+	messages, err := Parse(bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Address != "/a" || messages[1].Args[0] != "x" {
+		t.Errorf("Unexpected messages: %v", messages)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("/abc"),
+		[]byte("abc\x00"),
+		[]byte("/a\x00\x00,f\x00\x00\x00"),
+		[]byte("/a\x00\x00,x\x00\x00"),
+		[]byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x08abcd"),
+		append(append([]byte{}, bundleTag...), 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 9),
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	hub := control.NewHub()
+	c := controltest.New()
+	b := New(Options{
+		Listen: "127.0.0.1:0",
+		Send:   []string{peer.LocalAddr().String()},
+		Routes: func() []Route {
+			return []Route{{Combo: 1, Level: "/combo/1/level", Mute: "/combo/1/mute"}}
+		},
+		Controller: c,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := b.Start(hub); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer b.Close()
+
+	// Outgoing: level changes of routed combos only.
+	hub.Publish(control.Update{Kind: control.KindVolume, Combo: 0, Level: 10})
+	hub.Publish(control.Update{Kind: control.KindVolume, Combo: 1, Level: 42})
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, from, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Expected an OSC message: %v", err)
+	}
+	messages, err := Parse(buf[:n])
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Unexpected packet %q: %v", buf[:n], err)
+	}
+	if f, _ := messages[0].Float(0); messages[0].Address != "/combo/1/level" || f != float64(float32(0.42)) {
+		t.Errorf("Expected /combo/1/level 0.42, got %v", messages[0])
+	}
+
+	// Incoming: levels from 0 to 1, mute as boolean or number.
+	for _, m := range []Message{
+		{Address: "/combo/1/level", Args: []any{float32(0.555)}},
+		{Address: "/combo/1/mute", Args: []any{int32(1)}},
+		{Address: "/combo/0/level", Args: []any{float32(1)}},
+	} {
+		data, _ := m.MarshalBinary()
+		peer.WriteToUDP(data, from)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		level, _ := c.Level(1)
+		muted := c.Muted(1)
+		if level == 56 && muted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected level 56 and muted, got %d and %v", level, muted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if level, _ := c.Level(0); level != 10 {
+		t.Errorf("Expected unrouted combo to be ignored, got level %d", level)
+	}
+}
+
+func TestHandleMessage_Invalid(t *testing.T) {
+	b := New(Options{
+		Routes:     func() []Route { return []Route{{Combo: 0, Level: "/l", Mute: "/m"}} },
+		Controller: controltest.New(),
+	})
+	for _, m := range []Message{
+		{Address: "/l", Args: []any{float32(1.5)}},
+		{Address: "/l", Args: []any{"loud"}},
+		{Address: "/l"},
+		{Address: "/m", Args: []any{"on"}},
+	} {
+		if err := b.handleMessage(m); err == nil {
+			t.Errorf("Expected error for %v", m)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package relative applies knob turns as steps against the live level on
+// the host, instead of taking the position the firmware computed.
+package relative
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Modes.
+const (
+	// Absolute applies the knob position sent by the device.
+	Absolute = "absolute"
+	// Relative steps the live level by the detents turned.
+	Relative = "relative"
+)
+
+const (
+	// DefaultAcceleration matches the firmware's acceleration.
+	DefaultAcceleration = 0.8
+	// FastInterval is the time between detents below which turns speed up.
+	FastInterval = 60 * time.Millisecond
+	// MaxSpeed bounds the acceleration as a multiple of the step.
+	MaxSpeed = 8
+)
+
+// Spec is the configured handling of a combo's turns.
+type Spec struct {
+	// Mode is Absolute or Relative. Empty means Absolute.
+	Mode string `yaml:"mode,omitempty"`
+	// Step is the change per detent in knob positions, 1 by default.
+	Step int `yaml:"step,omitempty"`
+	// Acceleration is added to the speed for every fast detent, up to
+	// MaxSpeed times the step. It defaults to DefaultAcceleration; 0
+	// disables it.
+	Acceleration *float64 `yaml:"acceleration,omitempty"`
+}
+
+// IsRelative reports whether turns are applied on the host.
+func (s *Spec) IsRelative() bool {
+	return s.Mode == Relative
+}
+
+// Validate checks the spec.
+func (s *Spec) Validate() error {
+	switch s.Mode {
+	case "", Absolute, Relative:
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+	if s.Step < 0 {
+		return fmt.Errorf("step must not be negative, got %d", s.Step)
+	}
+	if s.Acceleration != nil && *s.Acceleration < 0 {
+		return fmt.Errorf("acceleration must not be negative, got %g", *s.Acceleration)
+	}
+	if !s.IsRelative() && (s.Step != 0 || s.Acceleration != nil) {
+		return fmt.Errorf("step and acceleration require mode: %s", Relative)
+	}
+	return nil
+}
+
+func (s *Spec) step() int {
+	if s.Step == 0 {
+		return 1
+	}
+	return s.Step
+}
+
+func (s *Spec) acceleration() float64 {
+	if s.Acceleration == nil {
+		return DefaultAcceleration
+	}
+	return *s.Acceleration
+}
+
+// Accelerator keeps the speed of each combo's turns.
+type Accelerator struct {
+	mu    sync.Mutex
+	speed map[uint8]float64
+}
+
+// NewAccelerator creates an accelerator with every combo at rest.
+func NewAccelerator() *Accelerator {
+	return &Accelerator{speed: make(map[uint8]float64)}
+}
+
+// Delta returns the change in knob positions for turning a combo by
+// detents, negative for counter-clockwise, interval after its previous
+// turn.
+func (a *Accelerator) Delta(combo uint8, s Spec, detents int, interval time.Duration) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	speed := 1.0
+	if interval < FastInterval {
+		speed = min(max(a.speed[combo], 1)+s.acceleration(), MaxSpeed)
+	}
+	a.speed[combo] = speed
+	return detents * s.step() * int(math.Round(speed))
+}
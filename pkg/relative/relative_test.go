@@ -0,0 +1,73 @@
+package relative
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestDelta(t *testing.T) {
+	a := NewAccelerator()
+	spec := Spec{Mode: Relative, Step: 2}
+
+	if got := a.Delta(0, spec, 1, time.Second); got != 2 {
+		t.Errorf("Expected a slow detent to move one step, got %d", got)
+	}
+	if got := a.Delta(0, spec, -3, time.Second); got != -6 {
+		t.Errorf("Expected three detents back to move three steps, got %d", got)
+	}
+
+	// Fast turns speed up until MaxSpeed.
+	var got []int
+	for range 12 {
+		got = append(got, a.Delta(0, spec, 1, 10*time.Millisecond))
+	}
+	want := []int{4, 6, 6, 8, 10, 12, 14, 14, 16, 16, 16, 16}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+
+	// Other combos are not affected, and a pause resets the speed.
+	if got := a.Delta(1, spec, 1, 10*time.Millisecond); got != 4 {
+		t.Errorf("Expected combo 1 to start at rest, got %d", got)
+	}
+	if got := a.Delta(0, spec, 1, time.Second); got != 2 {
+		t.Errorf("Expected the speed to reset, got %d", got)
+	}
+}
+
+func TestDelta_NoAcceleration(t *testing.T) {
+	a := NewAccelerator()
+	spec := Spec{Mode: Relative, Acceleration: ptr(0)}
+	for range 5 {
+		if got := a.Delta(0, spec, 1, time.Millisecond); got != 1 {
+			t.Fatalf("Expected no acceleration, got %d", got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []Spec{{}, {Mode: Absolute}, {Mode: Relative, Step: 5, Acceleration: ptr(0)}} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", s, err)
+		}
+	}
+
+	for want, s := range map[string]Spec{
+		"unknown mode":      {Mode: "sideways"},
+		"step must not":     {Mode: Relative, Step: -1},
+		"acceleration must": {Mode: Relative, Acceleration: ptr(-1)},
+		"require mode":      {Step: 2},
+	} {
+		err := s.Validate()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got %v", want, err)
+		}
+	}
+}
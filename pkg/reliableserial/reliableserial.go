@@ -8,6 +8,7 @@ import (
 	"io"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.bug.st/serial"
@@ -35,6 +36,43 @@ type SerialConfig struct {
 	BaudRate int
 }
 
+// Stats describes the link since the ReliableSerial was created.
+type Stats struct {
+	// Device is the port of the current or last connection.
+	Device    string
+	Connected bool
+	// Connects counts successful connections, Reconnects those after the
+	// first one.
+	Connects   uint64
+	Reconnects uint64
+	// BadFrames counts received frames that failed to deserialize.
+	BadFrames uint64
+	// Dropped counts received messages dropped because nobody read them.
+	Dropped uint64
+	// SendQueue and ReceiveQueue are the messages waiting in the channels.
+	SendQueue    int
+	ReceiveQueue int
+	// RTT is the round trip time of the last answered ping, and
+	// PingTimeouts counts connections dropped because pings went
+	// unanswered. See Heartbeat.
+	RTT          time.Duration
+	PingTimeouts uint64
+}
+
+// Heartbeat makes a ReliableSerial ping the device and drop the connection
+// once it stops answering, even while the port stays open.
+type Heartbeat struct {
+	// Interval is the time between pings. After Misses pings in a row go
+	// unanswered the link counts as dead.
+	Interval time.Duration
+	Misses   int
+	// Ping creates a ping carrying stamp, and Pong returns the stamp a
+	// received message echoes if it answers a ping. Pongs are not passed
+	// on to the receive channel.
+	Ping func(stamp uint32) Serializable
+	Pong func(msg Serializable) (stamp uint32, ok bool)
+}
+
 // ReliableSerial manages reliable communication over a serial port.
 type ReliableSerial struct {
 	sendCh    chan Serializable
@@ -45,8 +83,10 @@ type ReliableSerial struct {
 	logger        *slog.Logger
 
 	deviceConnected chan DeviceInfo
+	connectCh       chan DeviceInfo
 
-	serialPort io.ReadWriteCloser
+	// writeMu keeps pings from interleaving with other messages.
+	writeMu sync.Mutex
 
 	// Internal synchronization
 	mu           sync.Mutex
@@ -61,6 +101,21 @@ type ReliableSerial struct {
 	serializableFactory func() Serializable
 
 	serialPortOpener func(name string, mode *serial.Mode) (io.ReadWriteCloser, error)
+
+	device    string
+	connects  atomic.Uint64
+	badFrames atomic.Uint64
+	dropped   atomic.Uint64
+
+	// progress is guarded by mu.
+	progress func()
+
+	// heartbeat is guarded by mu. Stamps count microseconds since start.
+	heartbeat    *Heartbeat
+	start        time.Time
+	unanswered   atomic.Int32
+	rtt          atomic.Int64
+	pingTimeouts atomic.Uint64
 }
 
 // NewReliableSerial creates a new ReliableSerial instance.
@@ -82,12 +137,14 @@ func NewReliableSerial(
 		logger:        logger,
 
 		deviceConnected: make(chan DeviceInfo),
+		connectCh:       make(chan DeviceInfo, 1),
 
 		ctx:    ctx,
 		cancel: cancel,
 
 		delimiterFunc:       delimiterFunc,
 		serializableFactory: serializableFactory,
+		start:               time.Now(),
 	}
 
 	if len(opener) > 0 && opener[0] != nil {
@@ -114,6 +171,34 @@ func (rs *ReliableSerial) ReceiveChannel() <-chan Serializable {
 	return rs.receiveCh
 }
 
+// SetHeartbeat enables pings for the connections made from now on.
+func (rs *ReliableSerial) SetHeartbeat(hb Heartbeat) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.heartbeat = &hb
+}
+
+func (rs *ReliableSerial) heartbeatConfig() *Heartbeat {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.heartbeat
+}
+
+// SetProgress makes the device monitor call report every time it checks
+// for devices, connected or not, so callers can tell it still runs.
+func (rs *ReliableSerial) SetProgress(report func()) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.progress = report
+}
+
+// ConnectChannel receives the device after every successful connection, so
+// callers can restore state the device lost. A notification is dropped
+// while an earlier one is still pending.
+func (rs *ReliableSerial) ConnectChannel() <-chan DeviceInfo {
+	return rs.connectCh
+}
+
 // Close stops all operations and closes the serial port.
 func (rs *ReliableSerial) Close() {
 	rs.cancel()
@@ -133,6 +218,25 @@ func (rs *ReliableSerial) IsRunning() bool {
 	return rs.isRunning
 }
 
+// Stats returns the current link statistics.
+func (rs *ReliableSerial) Stats() Stats {
+	rs.mu.Lock()
+	s := Stats{Device: rs.device, Connected: rs.isRunning}
+	rs.mu.Unlock()
+
+	s.Connects = rs.connects.Load()
+	if s.Connects > 1 {
+		s.Reconnects = s.Connects - 1
+	}
+	s.BadFrames = rs.badFrames.Load()
+	s.Dropped = rs.dropped.Load()
+	s.SendQueue = len(rs.sendCh)
+	s.ReceiveQueue = len(rs.receiveCh)
+	s.RTT = time.Duration(rs.rtt.Load())
+	s.PingTimeouts = rs.pingTimeouts.Load()
+	return s
+}
+
 // runDeviceMonitor monitors for connected devices matching the DeviceMatcher.
 func (rs *ReliableSerial) runDeviceMonitor() {
 	rs.logger.Info("Starting device monitor")
@@ -147,8 +251,12 @@ func (rs *ReliableSerial) runDeviceMonitor() {
 		case <-ticker.C:
 			rs.mu.Lock()
 			isRunning := rs.isRunning
+			progress := rs.progress
 			rs.mu.Unlock()
 
+			if progress != nil {
+				progress()
+			}
 			if isRunning {
 				continue
 			}
@@ -206,20 +314,20 @@ func (rs *ReliableSerial) handleDeviceConnection(deviceInfo DeviceInfo) {
 	}
 	rs.logger.Debug("Serial port opened", "device", deviceInfo)
 
-	rs.serialPort = port
+	// The loops of this connection end it through deviceCancel, which
+	// Close reaches through rs.deviceCancel.
+	deviceCtx, deviceCancel := context.WithCancel(rs.ctx)
+	defer deviceCancel()
 
 	rs.mu.Lock()
 	rs.isRunning = true
+	rs.device = deviceInfo.Name
+	rs.deviceCancel = deviceCancel
 	rs.mu.Unlock()
-
-	deviceCtx, deviceCancel := context.WithCancel(rs.ctx)
-
-	// Wrap deviceCancel in a sync.Once to avoid multiple calls
-	deviceCancelOnce := &sync.Once{}
-	rs.deviceCancel = func() {
-		deviceCancelOnce.Do(func() {
-			deviceCancel()
-		})
+	rs.connects.Add(1)
+	select {
+	case rs.connectCh <- deviceInfo:
+	default:
 	}
 
 	var wg sync.WaitGroup
@@ -227,30 +335,36 @@ func (rs *ReliableSerial) handleDeviceConnection(deviceInfo DeviceInfo) {
 
 	go func() {
 		defer wg.Done()
-		rs.sendLoop(deviceCtx)
+		rs.sendLoop(deviceCtx, deviceCancel, port)
 	}()
 
 	go func() {
 		defer wg.Done()
-		rs.receiveLoop(deviceCtx)
+		rs.receiveLoop(deviceCtx, deviceCancel, port)
 	}()
 
+	if hb := rs.heartbeatConfig(); hb != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rs.heartbeatLoop(deviceCtx, deviceCancel, *hb, port)
+		}()
+	}
+
 	wg.Wait()
 
 	rs.mu.Lock()
 	rs.isRunning = false
-	rs.mu.Unlock()
-
 	rs.deviceCancel = nil
+	rs.mu.Unlock()
 
-	rs.serialPort.Close()
-	rs.serialPort = nil
+	port.Close()
 
 	rs.logger.Info("Device disconnected", "device", deviceInfo)
 }
 
 // sendLoop reads from send channel, serializes data, and writes to the device.
-func (rs *ReliableSerial) sendLoop(ctx context.Context) {
+func (rs *ReliableSerial) sendLoop(ctx context.Context, cancel context.CancelFunc, port io.Writer) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -262,25 +376,67 @@ func (rs *ReliableSerial) sendLoop(ctx context.Context) {
 				continue
 			}
 			// rs.logger.Debug("Sending data", "data", hex.EncodeToString(serializedData))
-			// Append delimiter
-			delimiter := rs.delimiterFunc()
-			serializedData = append(serializedData, delimiter...)
-			_, err = rs.serialPort.Write(serializedData)
+			err = rs.write(port, serializedData)
 			if err != nil {
 				rs.logger.Error("Failed to write to serial port", "error", err)
-				// rs.deviceCancelOnce.Do(rs.deviceCancel)
-				if rs.deviceCancel != nil {
-					rs.deviceCancel()
-					rs.deviceCancel = nil
-				}
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// write sends one serialized message and its delimiter.
+func (rs *ReliableSerial) write(port io.Writer, data []byte) error {
+	rs.writeMu.Lock()
+	defer rs.writeMu.Unlock()
+	_, err := port.Write(append(data, rs.delimiterFunc()...))
+	return err
+}
+
+// heartbeatLoop pings the device until the connection ends, and ends it
+// itself with cancel once too many pings went unanswered.
+func (rs *ReliableSerial) heartbeatLoop(ctx context.Context, cancel context.CancelFunc, hb Heartbeat, port io.ReadWriteCloser) {
+	rs.unanswered.Store(0)
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if int(rs.unanswered.Load()) >= hb.Misses {
+				rs.logger.Warn("Device stopped answering pings", "misses", hb.Misses)
+				rs.pingTimeouts.Add(1)
+				cancel()
+				// A hung device leaves the receive loop blocked in a read
+				// that only closing the port ends.
+				port.Close()
+				return
+			}
+			data, err := hb.Ping(rs.stamp()).Serialize()
+			if err != nil {
+				rs.logger.Error("Serialization error", "error", err)
+				continue
+			}
+			rs.unanswered.Add(1)
+			if err := rs.write(port, data); err != nil {
+				rs.logger.Error("Failed to write ping", "error", err)
+				cancel()
 				return
 			}
 		}
 	}
 }
 
-func (rs *ReliableSerial) receiveLoop(ctx context.Context) {
-	reader := bufio.NewReader(rs.serialPort)
+// stamp returns the current time for pings.
+func (rs *ReliableSerial) stamp() uint32 {
+	return uint32(time.Since(rs.start).Microseconds())
+}
+
+func (rs *ReliableSerial) receiveLoop(ctx context.Context, cancel context.CancelFunc, port io.Reader) {
+	reader := bufio.NewReader(port)
 	scanner := bufio.NewScanner(reader)
 
 	// Custom split function
@@ -325,17 +481,10 @@ func (rs *ReliableSerial) receiveLoop(ctx context.Context) {
 				if err == nil {
 					// EOF reached
 					rs.logger.Info("EOF reached, treating as device disconnection")
-					if rs.deviceCancel != nil {
-						rs.deviceCancel()
-						rs.deviceCancel = nil
-					}
 				} else {
 					rs.logger.Error("Scanner error", "error", err)
-					if rs.deviceCancel != nil {
-						rs.deviceCancel()
-						rs.deviceCancel = nil
-					}
 				}
+				cancel()
 				return
 			}
 		}
@@ -378,14 +527,28 @@ func (rs *ReliableSerial) handleReceivedData(data []byte) {
 	// rs.logger.Debug("Deserializing message", "data", data)
 	if err := message.Deserialize(data); err != nil {
 		rs.logger.Error("Failed to deserialize message", "error", err, "data", data)
+		rs.badFrames.Add(1)
 		return
 	}
 
+	if hb := rs.heartbeatConfig(); hb != nil && hb.Pong != nil {
+		if stamp, ok := hb.Pong(message); ok {
+			// Stamps wrap around after an hour, which the subtraction
+			// survives.
+			rtt := time.Duration(rs.stamp()-stamp) * time.Microsecond
+			rs.rtt.Store(int64(rtt))
+			rs.unanswered.Store(0)
+			rs.logger.Debug("Received pong", "rtt", rtt)
+			return
+		}
+	}
+
 	// Send message to receive channel
 	select {
 	case rs.receiveCh <- message:
 	default:
 		rs.logger.Warn("Receive channel is full, dropping message")
+		rs.dropped.Add(1)
 	}
 }
 
@@ -1,8 +1,13 @@
 package reliableserial
 
 import (
+	"errors"
 	"io"
 	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,6 +33,9 @@ func (ms *MockSerializable) Serialize() ([]byte, error) {
 }
 
 func (ms *MockSerializable) Deserialize(data []byte) error {
+	if string(data) == "garbage" {
+		return errors.New("invalid message")
+	}
 	ms.Content = string(data)
 	return nil
 }
@@ -36,36 +44,35 @@ func (ms *MockSerializable) Deserialize(data []byte) error {
 type MockSerialPort struct {
 	readCh  chan []byte
 	writeCh chan []byte
-	closed  bool
+	// closed is closed by Close, which ends pending reads and writes.
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func NewMockSerialPort() *MockSerialPort {
 	return &MockSerialPort{
 		readCh:  make(chan []byte, 10),
 		writeCh: make(chan []byte, 10),
+		closed:  make(chan struct{}),
 	}
 }
 
 func (msp *MockSerialPort) Read(p []byte) (n int, err error) {
-	if msp.closed {
-		return 0, io.EOF
-	}
-	data, ok := <-msp.readCh
-	if !ok {
+	select {
+	case <-msp.closed:
 		return 0, io.EOF
+	case data := <-msp.readCh:
+		return copy(p, data), nil
 	}
-	n = copy(p, data)
-	return n, nil
 }
 
 func (msp *MockSerialPort) Close() error {
-	if msp.closed {
-		return io.ErrClosedPipe
-	}
-	msp.closed = true
-	close(msp.readCh)
-	close(msp.writeCh)
-	return nil
+	err := io.ErrClosedPipe
+	msp.closeOnce.Do(func() {
+		close(msp.closed)
+		err = nil
+	})
+	return err
 }
 
 // func (msp *MockSerialPort) Read(p []byte) (n int, err error) {
@@ -81,13 +88,19 @@ func (msp *MockSerialPort) Close() error {
 // }
 
 func (msp *MockSerialPort) Write(p []byte) (n int, err error) {
-	if msp.closed {
+	select {
+	case <-msp.closed:
 		return 0, io.ErrClosedPipe
+	default:
 	}
 	data := make([]byte, len(p))
 	copy(data, p)
-	msp.writeCh <- data
-	return len(p), nil
+	select {
+	case msp.writeCh <- data:
+		return len(p), nil
+	case <-msp.closed:
+		return 0, io.ErrClosedPipe
+	}
 }
 
 // func (msp *MockSerialPort) Close() error {
@@ -573,3 +586,162 @@ func TestReliableSerial_ExtremeDisconnects(t *testing.T) {
 		}
 	}
 }
+
+func TestReliableSerial_Stats(t *testing.T) {
+	ports := make(chan *MockSerialPort, 2)
+	serialPortOpener := func(name string, mode *serial.Mode) (io.ReadWriteCloser, error) {
+		port := NewMockSerialPort()
+		ports <- port
+		return port, nil
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rs := NewReliableSerial(
+		&MockDeviceMatcher{deviceName: "COM1"},
+		SerialConfig{BaudRate: 9600},
+		logger,
+		func() []byte { return []byte{'\n'} },
+		func() Serializable { return &MockSerializable{} },
+		serialPortOpener,
+	)
+	defer rs.Close()
+
+	rs.deviceConnected <- DeviceInfo{Name: "COM1", ID: "COM1"}
+	port := <-ports
+	port.readCh <- []byte("garbage\nHello\n")
+	time.Sleep(200 * time.Millisecond)
+
+	s := rs.Stats()
+	if !s.Connected || s.Device != "COM1" || s.Connects != 1 || s.Reconnects != 0 {
+		t.Errorf("Unexpected connection stats: %+v", s)
+	}
+	if s.BadFrames != 1 || s.ReceiveQueue != 1 {
+		t.Errorf("Expected 1 bad frame and 1 queued message, got %+v", s)
+	}
+
+	port.Close()
+	time.Sleep(200 * time.Millisecond)
+	rs.deviceConnected <- DeviceInfo{Name: "COM1", ID: "COM1"}
+	time.Sleep(200 * time.Millisecond)
+
+	if s := rs.Stats(); s.Reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %+v", s)
+	}
+}
+
+func TestReliableSerial_ConnectChannel(t *testing.T) {
+	ports := make(chan *MockSerialPort, 2)
+	serialPortOpener := func(name string, mode *serial.Mode) (io.ReadWriteCloser, error) {
+		port := NewMockSerialPort()
+		ports <- port
+		return port, nil
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rs := NewReliableSerial(
+		&MockDeviceMatcher{deviceName: "COM1"},
+		SerialConfig{BaudRate: 9600},
+		logger,
+		func() []byte { return []byte{'\n'} },
+		func() Serializable { return &MockSerializable{} },
+		serialPortOpener,
+	)
+	defer rs.Close()
+
+	for i := 0; i < 2; i++ {
+		rs.deviceConnected <- DeviceInfo{Name: "COM1", ID: "COM1"}
+		port := <-ports
+		select {
+		case info := <-rs.ConnectChannel():
+			if info.Name != "COM1" {
+				t.Errorf("Expected COM1, got %+v", info)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a connect notification, connection %d", i)
+		}
+		port.Close()
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestReliableSerial_Heartbeat(t *testing.T) {
+	ports := make(chan *MockSerialPort, 1)
+	serialPortOpener := func(name string, mode *serial.Mode) (io.ReadWriteCloser, error) {
+		port := NewMockSerialPort()
+		ports <- port
+		return port, nil
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rs := NewReliableSerial(
+		&MockDeviceMatcher{deviceName: "COM1"},
+		SerialConfig{BaudRate: 9600},
+		logger,
+		func() []byte { return []byte{'\n'} },
+		func() Serializable { return &MockSerializable{} },
+		serialPortOpener,
+	)
+	defer rs.Close()
+	rs.SetHeartbeat(Heartbeat{
+		Interval: 20 * time.Millisecond,
+		Misses:   3,
+		Ping: func(stamp uint32) Serializable {
+			return &MockSerializable{Content: "ping " + strconv.FormatUint(uint64(stamp), 10)}
+		},
+		Pong: func(msg Serializable) (uint32, bool) {
+			stamp, ok := strings.CutPrefix(msg.(*MockSerializable).Content, "pong ")
+			if !ok {
+				return 0, false
+			}
+			n, err := strconv.ParseUint(stamp, 10, 32)
+			return uint32(n), err == nil
+		},
+	})
+
+	rs.deviceConnected <- DeviceInfo{Name: "COM1", ID: "COM1"}
+	port := <-ports
+
+	// The device answers pings a little later, until it hangs.
+	var answering atomic.Bool
+	answering.Store(true)
+	device := make(chan struct{})
+	go func() {
+		defer close(device)
+		for {
+			select {
+			case <-port.closed:
+				return
+			case data := <-port.writeCh:
+				stamp, ok := strings.CutPrefix(strings.TrimSuffix(string(data), "\n"), "ping ")
+				if ok && answering.Load() {
+					time.Sleep(2 * time.Millisecond)
+					port.readCh <- []byte("pong " + stamp + "\n")
+				}
+			}
+		}
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	stats := rs.Stats()
+	if !stats.Connected || stats.RTT < 2*time.Millisecond || stats.PingTimeouts != 0 {
+		t.Errorf("Expected a live link with a round trip time, got %+v", stats)
+	}
+	select {
+	case msg := <-rs.ReceiveChannel():
+		t.Errorf("Expected pongs not to be passed on, got %+v", msg)
+	default:
+	}
+
+	answering.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	stats = rs.Stats()
+	if stats.Connected || stats.PingTimeouts != 1 {
+		t.Errorf("Expected the hung device to be dropped, got %+v", stats)
+	}
+	// Dropping the link closed the port, which ends the device.
+	select {
+	case <-device:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the device to stop with the closed port")
+	}
+}
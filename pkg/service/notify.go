@@ -0,0 +1,101 @@
+package service
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// States sent to systemd.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends a state to the service manager over the socket named by
+// NOTIFY_SOCKET. It reports false without error when there is none, i.e.
+// when not started by systemd with Type=notify.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading @ names an abstract socket, which net handles itself.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a watchdog
+// notification, or zero if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// ReportPeriod is how often loops that wait for work should report
+// progress anyway.
+const ReportPeriod = time.Second
+
+// Progress records when a loop last made progress.
+type Progress struct {
+	last atomic.Int64
+}
+
+// Report records progress now.
+func (p *Progress) Report() {
+	p.last.Store(time.Now().UnixNano())
+}
+
+// within reports whether progress was reported in the d before now.
+func (p *Progress) within(d time.Duration, now time.Time) bool {
+	last := p.last.Load()
+	return last != 0 && now.Sub(time.Unix(0, last)) <= d
+}
+
+// RunWatchdog notifies the watchdog at half its interval until stop is
+// closed, but only while every loop reported progress within the interval,
+// so the service manager restarts a daemon that hangs. It returns at once
+// if the watchdog is not enabled.
+func RunWatchdog(stop <-chan struct{}, logger *slog.Logger, loops ...*Progress) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			stalled := slices.IndexFunc(loops, func(p *Progress) bool {
+				return !p.within(interval, now)
+			})
+			if stalled >= 0 {
+				logger.Warn("not notifying watchdog, a loop stopped making progress", "loop", stalled)
+				continue
+			}
+			if _, err := Notify(Watchdog); err != nil {
+				logger.Warn("error notifying watchdog", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package service supports running the daemon in the background: a
+// single-instance lock, a PID file, systemd readiness and watchdog
+// notifications, and generating a systemd user unit.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/flock"
+)
+
+// ErrAlreadyRunning is returned by Lock when another instance holds the lock.
+var ErrAlreadyRunning = errors.New("another instance is already running")
+
+// DefaultLockPath is used when no lock file is configured.
+func DefaultLockPath() string {
+	return filepath.Join(os.TempDir(), "desktop-audio-ctrl.lock")
+}
+
+// DefaultPIDPath is used when no PID file is configured.
+func DefaultPIDPath() string {
+	return filepath.Join(os.TempDir(), "desktop-audio-ctrl.pid")
+}
+
+// Lock is held by the running instance. The operating system releases it
+// when the process exits, so a crash never leaves a stale lock behind.
+type Lock struct {
+	lock *flock.Flock
+}
+
+// Acquire takes the lock at path without waiting.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	l := flock.New(path)
+	ok, err := l.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if !ok {
+		return nil, ErrAlreadyRunning
+	}
+	return &Lock{lock: l}, nil
+}
+
+// Release gives the lock up.
+func (l *Lock) Release() error {
+	return l.lock.Unlock()
+}
+
+// WritePIDFile writes the current process ID to path.
+func WritePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+// ReadPIDFile returns the process ID written to path.
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID file %s: %w", path, err)
+	}
+	return pid, nil
+}
+
+// RemovePIDFile removes the PID file if it still holds the current process
+// ID.
+func RemovePIDFile(path string) error {
+	pid, err := ReadPIDFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil || pid != os.Getpid() {
+		return err
+	}
+	return os.Remove(path)
+}
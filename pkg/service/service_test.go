@@ -0,0 +1,251 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotify stands in for systemd's notify socket.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected a notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotify(t)
+
+	for _, state := range []string{Ready, Stopping} {
+		sent, err := Notify(state)
+		if err != nil || !sent {
+			t.Fatalf("Expected %s to be sent, got %v (%v)", state, sent, err)
+		}
+		if got := receive(t, conn); got != state {
+			t.Errorf("Expected %s, got %s", state, got)
+		}
+	}
+}
+
+func TestNotify_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Expected nothing to be sent, got %v (%v)", sent, err)
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(stop, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+	for range 2 {
+		if got := receive(t, conn); got != Watchdog {
+			t.Errorf("Expected %s, got %s", Watchdog, got)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestRunWatchdog_StalledLoop(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	var alive, stalled Progress
+	stalled.Report()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(stop, slog.New(slog.NewTextHandler(io.Discard, nil)), &alive, &stalled)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// Both loops report for a while, then one stops.
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		alive.Report()
+		stalled.Report()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := receive(t, conn); got != Watchdog {
+		t.Errorf("Expected %s, got %s", Watchdog, got)
+	}
+
+	quiet := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(quiet) {
+		alive.Report()
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Drain what was sent while the stalled loop was still recent.
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	for {
+		if _, err := conn.Read(make([]byte, 256)); err != nil {
+			break
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 256)); err == nil {
+		t.Errorf("Expected no notification for a stalled loop, got %d bytes", n)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", got)
+	}
+
+	// The watchdog is meant for another process.
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("Expected no watchdog, got %v", got)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("Expected no watchdog, got %v", got)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.lock")
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Acquire(path); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	l, err = Acquire(path)
+	if err != nil {
+		t.Fatalf("Expected the released lock to be free, got %v", err)
+	}
+	l.Release()
+}
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "daemon.pid")
+
+	if err := WritePIDFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pid, err := ReadPIDFile(path); err != nil || pid != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d (%v)", os.Getpid(), pid, err)
+	}
+	if err := RemovePIDFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the PID file to be removed, got %v", err)
+	}
+
+	// A PID file written by another instance is left alone.
+	os.WriteFile(path, []byte("1\n"), 0o644)
+	RemovePIDFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the PID file to be kept, got %v", err)
+	}
+}
+
+func TestUnit(t *testing.T) {
+	unit := Unit{
+		Exe:         "/opt/audio ctrl/host",
+		Args:        []string{"-port", "/dev/ttyACM0"},
+		Dir:         "/home/me/.config/desktop-audio-ctrl",
+		WatchdogSec: 30,
+	}.String()
+
+	for _, want := range []string{
+		"Type=notify\n",
+		`ExecStart="/opt/audio ctrl/host" -port /dev/ttyACM0 service` + "\n",
+		"WorkingDirectory=/home/me/.config/desktop-audio-ctrl\n",
+		"Restart=on-failure\n",
+		"WatchdogSec=30\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Expected unit to contain %q, got:\n%s", want, unit)
+		}
+	}
+
+	unit = Unit{Exe: "/usr/bin/host", Dir: "/home/me/My Config/100%"}.String()
+	if want := "WorkingDirectory=/home/me/My Config/100%%\n"; !strings.Contains(unit, want) {
+		t.Errorf("Expected unit to contain %q, got:\n%s", want, unit)
+	}
+
+	if got := quote("100%$"); got != "100%%$$" {
+		t.Errorf("Expected specifiers to be escaped, got %s", got)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "systemd", "user")
+
+	path, err := Install(dir, Unit{Exe: "/usr/bin/host"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "ExecStart=/usr/bin/host service") {
+		t.Errorf("Expected the unit to be written, got %q (%v)", data, err)
+	}
+
+	for _, wd := range []string{"config", "/home/me/a\nb"} {
+		if _, err := Install(dir, Unit{Exe: "/usr/bin/host", Dir: wd}); err == nil {
+			t.Errorf("Expected error for working directory %q", wd)
+		}
+	}
+
+	if _, err := Uninstall(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the unit to be removed, got %v", err)
+	}
+	if _, err := Uninstall(dir); err != nil {
+		t.Errorf("Expected uninstalling twice to succeed, got %v", err)
+	}
+}
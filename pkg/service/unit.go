@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// UnitName is the name of the generated systemd user unit.
+const UnitName = "desktop-audio-ctrl.service"
+
+// Unit describes the systemd user unit running the daemon.
+type Unit struct {
+	// Exe is the absolute path of the host binary.
+	Exe string
+	// Args follow Exe, e.g. flags such as -port.
+	Args []string
+	// Dir is where the daemon finds config.yaml.
+	Dir string
+	// WatchdogSec restarts the daemon if it stops responding. Zero disables
+	// the watchdog.
+	WatchdogSec int
+}
+
+// String returns the unit file.
+func (u Unit) String() string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Desktop Audio Control\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=notify\n")
+	b.WriteString("NotifyAccess=main\n")
+	exec := []string{quote(u.Exe)}
+	for _, arg := range u.Args {
+		exec = append(exec, quote(arg))
+	}
+	exec = append(exec, "service")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(exec, " "))
+	if u.Dir != "" {
+		// WorkingDirectory takes a plain path rather than a command line, so
+		// only specifiers are escaped.
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", strings.ReplaceAll(u.Dir, "%", "%%"))
+	}
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n")
+	if u.WatchdogSec > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", u.WatchdogSec)
+	}
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+// quote quotes a word for systemd, escaping specifiers and variables.
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+	if s == "" || strings.ContainsAny(s, " \t'") || strings.Contains(s, `\`) {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// UserUnitDir returns the directory of systemd user units.
+func UserUnitDir() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", errors.New("user units need systemd, which is only available on Linux")
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// Install writes the unit to dir and returns its path.
+func Install(dir string, u Unit) (string, error) {
+	if u.Dir != "" && (!strings.HasPrefix(u.Dir, "/") || strings.ContainsAny(u.Dir, "\n\r")) {
+		return "", fmt.Errorf("systemd cannot use %q as working directory", u.Dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, UnitName)
+	return path, os.WriteFile(path, []byte(u.String()), 0o644)
+}
+
+// Uninstall removes the unit from dir and returns its path. A missing unit
+// is not an error.
+func Uninstall(dir string) (string, error) {
+	path := filepath.Join(dir, UnitName)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return path, nil
+}
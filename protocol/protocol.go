@@ -1,34 +1,139 @@
 package protocol
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
+	"unicode/utf8"
 )
 
 type EventType uint8
 
 const (
-	// device -> host
+	// device -> host, Data optionally carries the combo's version (see
+	// Version) and for turns how the knob was turned (see Turn)
 	EVENT_TYPE_CW EventType = iota + 1
 	EVENT_TYPE_CCW
 	EVENT_TYPE_CLICK
 	EVENT_TYPE_DOUBLE_CLICK
 
-	// host -> device
+	// host -> device, Data optionally carries the last version the host had
+	// seen
 	EVENT_TYPE_SET
 
 	EVENT_TYPE_ACK
+
+	// host -> device, State carries one of the NOTIFY_* codes
+	EVENT_TYPE_NOTIFY
+
+	// host -> device, Data carries the combo's name; empty restores the
+	// firmware default
+	EVENT_TYPE_LABEL
+
+	// host -> device, State carries one of the STYLE_* codes
+	EVENT_TYPE_STYLE
+
+	// host -> device, State is 1 while the combo's endpoint is muted and 0
+	// otherwise
+	EVENT_TYPE_MUTE
+
+	// host -> device every HEARTBEAT_PERIOD with Combo ALL_COMBOS, so the
+	// device can tell when the host is gone. Data carries a timestamp (see
+	// NewPing).
+	EVENT_TYPE_PING
+
+	// device -> host in answer to a PING, with the PING's Data, so the host
+	// can tell when the device hangs and measure the round trip
+	EVENT_TYPE_PONG
+
+	// host -> device with Combo ALL_COMBOS, Data carries the meter level of
+	// every combo from 0 on (see NewMeter)
+	EVENT_TYPE_METER
+)
+
+const (
+	// HEARTBEAT_PERIOD is how often the host sends EVENT_TYPE_PING.
+	HEARTBEAT_PERIOD = time.Second
+	// HEARTBEAT_MISSES is how many periods either side waits for the other
+	// before it counts the link as lost.
+	HEARTBEAT_MISSES = 3
+)
+
+// Notification codes shown on the device screens.
+const (
+	NOTIFY_CONFIG_RELOADED uint8 = iota + 1
+	NOTIFY_CONFIG_ERROR
+)
+
+// Styles of drawing a combo's level.
+const (
+	// STYLE_BAR fills a bar from one end, the firmware default.
+	STYLE_BAR uint8 = iota
+	// STYLE_BALANCE fills a bar from its center towards either end, for
+	// crossfades.
+	STYLE_BALANCE
+	// STYLE_SWITCHER shows the label over several lines in place of a bar,
+	// for switchers. The host picks what is shown, so turns leave the state
+	// alone, and State 1 marks the shown endpoint as the current default.
+	STYLE_SWITCHER
 )
 
+// ALL_COMBOS addresses every combo in host -> device events.
+const ALL_COMBOS uint8 = 0xFF
+
+// COMBOS is the number of combos on the device, numbered from 0.
+const COMBOS = 5
+
+func (t EventType) String() string {
+	switch t {
+	case EVENT_TYPE_CW:
+		return "cw"
+	case EVENT_TYPE_CCW:
+		return "ccw"
+	case EVENT_TYPE_CLICK:
+		return "click"
+	case EVENT_TYPE_DOUBLE_CLICK:
+		return "doubleClick"
+	case EVENT_TYPE_SET:
+		return "set"
+	case EVENT_TYPE_ACK:
+		return "ack"
+	case EVENT_TYPE_NOTIFY:
+		return "notify"
+	case EVENT_TYPE_LABEL:
+		return "label"
+	case EVENT_TYPE_STYLE:
+		return "style"
+	case EVENT_TYPE_MUTE:
+		return "mute"
+	case EVENT_TYPE_PING:
+		return "ping"
+	case EVENT_TYPE_PONG:
+		return "pong"
+	case EVENT_TYPE_METER:
+		return "meter"
+	default:
+		return "unknown"
+	}
+}
+
 const (
 	SIGNATURE uint8 = 0x69
+
+	// DELIMITER ends every frame on the wire. Inside a frame DELIMITER and
+	// ESCAPE are sent as ESCAPE followed by 0x00 or 0x01 respectively.
+	DELIMITER uint8 = 0xF0
+	ESCAPE    uint8 = 0xF1
 )
 
 type Event struct {
 	Type  EventType
 	Combo uint8
 	State uint8
+	// Data is an optional variable length payload.
+	Data []byte
 }
 
 func (e *Event) Serialize() ([]byte, error) {
@@ -45,16 +150,47 @@ func (e *Event) Deserialize(inp []byte) error {
 	e.Type = ev.Type
 	e.Combo = ev.Combo
 	e.State = ev.State
+	e.Data = ev.Data
 	return nil
 }
 
+// Marshal encodes an event without the trailing DELIMITER. Events without
+// Data encode to five bytes as they always have.
 func Marshal(e Event) []byte {
-	return []byte{SIGNATURE, SIGNATURE, uint8(e.Type), e.Combo, e.State}
+	frame := []byte{SIGNATURE, SIGNATURE, uint8(e.Type), e.Combo, e.State}
+	for _, b := range e.Data {
+		switch b {
+		case DELIMITER:
+			frame = append(frame, ESCAPE, 0x00)
+		case ESCAPE:
+			frame = append(frame, ESCAPE, 0x01)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	return frame
+}
+
+// unescape reverses the escaping done by Marshal.
+func unescape(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != ESCAPE {
+			out = append(out, data[i])
+			continue
+		}
+		i++
+		if i == len(data) || data[i] > 0x01 {
+			return nil, false
+		}
+		out = append(out, DELIMITER+data[i])
+	}
+	return out, true
 }
 
 func Unmarshal(data []byte) (Event, bool) {
 	// println("Unmarshalling event data: '", hex.EncodeToString(data), "'; length:", len(data))
-	if len(data) != 5 {
+	if len(data) < 5 {
 		println("Invalid event data length")
 		return Event{}, false
 	}
@@ -65,13 +201,114 @@ func Unmarshal(data []byte) (Event, bool) {
 	// fmt.Println("type byte:", data[2])
 	// fmt.Println("combo byte:", data[3])
 	// fmt.Println("state byte:", data[4])
-	return Event{Type: EventType(data[2]), Combo: data[3], State: data[4]}, true
+	ev := Event{Type: EventType(data[2]), Combo: data[3], State: data[4]}
+	if len(data) > 5 {
+		payload, ok := unescape(data[5:])
+		if !ok {
+			println("Invalid event data escape")
+			return Event{}, false
+		}
+		ev.Data = payload
+	}
+	return ev, true
+}
+
+// Version returns the version a knob or SET event carries, used to tell
+// stale SETs apart (see package reconcile). Events from older firmware or
+// hosts carry none.
+func (e *Event) Version() (uint8, bool) {
+	switch e.Type {
+	case EVENT_TYPE_CW, EVENT_TYPE_CCW, EVENT_TYPE_CLICK, EVENT_TYPE_DOUBLE_CLICK, EVENT_TYPE_SET:
+	default:
+		return 0, false
+	}
+	if len(e.Data) == 0 {
+		return 0, false
+	}
+	return e.Data[0], true
+}
+
+// MAX_TURN_INTERVAL is the longest time between turns a turn event carries.
+const MAX_TURN_INTERVAL = 255 * time.Millisecond
+
+// Turn returns how a CW or CCW event's knob was turned: the number of
+// detents and the time since its previous turn, capped at
+// MAX_TURN_INTERVAL. Events from older firmware carry neither.
+func (e *Event) Turn() (detents uint8, interval time.Duration, ok bool) {
+	if (e.Type != EVENT_TYPE_CW && e.Type != EVENT_TYPE_CCW) || len(e.Data) < 3 {
+		return 0, 0, false
+	}
+	return e.Data[1], time.Duration(e.Data[2]) * time.Millisecond, true
+}
+
+// TurnData returns the Data of a turn event, see Version and Turn.
+func TurnData(version uint8, detents int, interval time.Duration) []byte {
+	ms := min(interval, MAX_TURN_INTERVAL).Milliseconds()
+	return []byte{version, uint8(min(max(detents, 0), 255)), uint8(max(ms, 0))}
 }
 
 func NewEvent(t EventType, c, s uint8) *Event {
 	return &Event{Type: t, Combo: c, State: s}
 }
 
+// MAX_LABEL_LENGTH bounds label payloads in bytes, so a LABEL frame stays
+// within the firmware's 64 byte limit even with every byte escaped.
+const MAX_LABEL_LENGTH = 29
+
+// NewLabel creates a LABEL event, cutting label to MAX_LABEL_LENGTH bytes
+// at a character boundary.
+func NewLabel(c uint8, label string) *Event {
+	if len(label) > MAX_LABEL_LENGTH {
+		n := MAX_LABEL_LENGTH
+		for n > 0 && !utf8.RuneStart(label[n]) {
+			n--
+		}
+		label = label[:n]
+	}
+	return &Event{Type: EVENT_TYPE_LABEL, Combo: c, Data: []byte(label)}
+}
+
+// NewPing creates a PING event carrying stamp, a time in microseconds that
+// only the host interprets.
+func NewPing(stamp uint32) *Event {
+	return &Event{Type: EVENT_TYPE_PING, Combo: ALL_COMBOS, Data: binary.LittleEndian.AppendUint32(nil, stamp)}
+}
+
+// NewPong creates the PONG answering ping.
+func NewPong(ping *Event) *Event {
+	return &Event{Type: EVENT_TYPE_PONG, Combo: ping.Combo, Data: ping.Data}
+}
+
+// Stamp returns the timestamp a PING or PONG event carries.
+func (e *Event) Stamp() (uint32, bool) {
+	if (e.Type != EVENT_TYPE_PING && e.Type != EVENT_TYPE_PONG) || len(e.Data) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(e.Data), true
+}
+
+// MAX_METER is the meter level of a full scale peak. Levels never need
+// escaping, so a METER frame takes one byte per combo.
+const MAX_METER = 100
+
+// NewMeter creates a METER event with the meter levels of the combos from
+// 0 on, capped at MAX_METER.
+func NewMeter(levels []uint8) *Event {
+	data := make([]byte, len(levels))
+	for i, level := range levels {
+		data[i] = min(level, MAX_METER)
+	}
+	return &Event{Type: EVENT_TYPE_METER, Combo: ALL_COMBOS, Data: data}
+}
+
+// Meters returns the levels a METER event carries, one per combo from 0.
+func (e *Event) Meters() ([]uint8, bool) {
+	if e.Type != EVENT_TYPE_METER {
+		return nil, false
+	}
+	return e.Data, true
+}
+
 func (e *Event) String() string {
 	var state string
 	if e.State < 10 {
@@ -97,6 +334,20 @@ func (e *Event) String() string {
 		return "DblClck" + combo + " " + state
 	case EVENT_TYPE_SET:
 		return "Set   " + combo + " " + state
+	case EVENT_TYPE_NOTIFY:
+		return "Notify" + combo + " " + state
+	case EVENT_TYPE_LABEL:
+		return "Label " + combo + " " + string(e.Data)
+	case EVENT_TYPE_STYLE:
+		return "Style " + combo + " " + state
+	case EVENT_TYPE_MUTE:
+		return "Mute  " + combo + " " + state
+	case EVENT_TYPE_PING:
+		return "Ping  " + combo + " " + state
+	case EVENT_TYPE_PONG:
+		return "Pong  " + combo + " " + state
+	case EVENT_TYPE_METER:
+		return "Meter " + combo + " " + state
 	default:
 		return "Unknown" + combo + " " + state
 	}
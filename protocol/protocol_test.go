@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalFixedLength(t *testing.T) {
+	got := Marshal(Event{Type: EVENT_TYPE_SET, Combo: 2, State: 42})
+	want := []byte{SIGNATURE, SIGNATURE, byte(EVENT_TYPE_SET), 2, 42}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %x, got %x", want, got)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	// 0xF0 starts four byte UTF-8 sequences, so names can contain it.
+	e := Event{Type: EVENT_TYPE_LABEL, Combo: 1, Data: []byte("Gäme 🎮\xf1")}
+
+	frame := Marshal(e)
+	if bytes.IndexByte(frame, DELIMITER) >= 0 {
+		t.Fatalf("Expected no delimiter inside frame, got %x", frame)
+	}
+
+	got, ok := Unmarshal(frame)
+	if !ok {
+		t.Fatalf("Expected frame %x to unmarshal", frame)
+	}
+	if got.Type != e.Type || got.Combo != e.Combo || !bytes.Equal(got.Data, e.Data) {
+		t.Errorf("Expected %+v, got %+v", e, got)
+	}
+}
+
+func TestNewLabel(t *testing.T) {
+	if got := string(NewLabel(1, "Chat").Data); got != "Chat" {
+		t.Errorf("Expected Chat, got %q", got)
+	}
+
+	// The ä would take the 29th and 30th byte.
+	long := strings.Repeat("a", 28) + "äbc"
+	e := NewLabel(1, long)
+	if got := string(e.Data); got != strings.Repeat("a", 28) {
+		t.Errorf("Expected the label cut before the ä, got %q", got)
+	}
+	if frame := Marshal(*NewLabel(1, strings.Repeat("\xf0", 64))); len(frame) > 64 {
+		t.Errorf("Expected a frame within 64 bytes, got %d", len(frame))
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{SIGNATURE, SIGNATURE, 1, 0},
+		{0x00, SIGNATURE, 1, 0, 0},
+		{SIGNATURE, SIGNATURE, byte(EVENT_TYPE_LABEL), 0, 0, 'a', ESCAPE},
+		{SIGNATURE, SIGNATURE, byte(EVENT_TYPE_LABEL), 0, 0, ESCAPE, 0x02},
+	} {
+		if _, ok := Unmarshal(data); ok {
+			t.Errorf("Expected %x to be rejected", data)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// Versions go through escaping like any other payload.
+	for _, v := range []uint8{0, 7, DELIMITER, ESCAPE, 0xFF} {
+		frame := Marshal(Event{Type: EVENT_TYPE_SET, Combo: 1, State: 50, Data: []byte{v}})
+		e, ok := Unmarshal(frame)
+		if !ok {
+			t.Fatalf("Expected frame %x to unmarshal", frame)
+		}
+		if got, ok := e.Version(); !ok || got != v {
+			t.Errorf("Expected version %d, got %d (%v)", v, got, ok)
+		}
+	}
+
+	unversioned := []Event{
+		{Type: EVENT_TYPE_CW, State: 3},
+		{Type: EVENT_TYPE_LABEL, Data: []byte("A")},
+	}
+	for _, e := range unversioned {
+		if _, ok := e.Version(); ok {
+			t.Errorf("Expected no version in %s", e.String())
+		}
+	}
+}
+
+func TestTurn(t *testing.T) {
+	e := Event{Type: EVENT_TYPE_CCW, Combo: 1, State: 40, Data: TurnData(9, 3, 20*time.Millisecond)}
+	got, ok := Unmarshal(Marshal(e))
+	if !ok {
+		t.Fatalf("Expected turn event to unmarshal")
+	}
+	if v, ok := got.Version(); !ok || v != 9 {
+		t.Errorf("Expected version 9, got %d (%v)", v, ok)
+	}
+	detents, interval, ok := got.Turn()
+	if !ok || detents != 3 || interval != 20*time.Millisecond {
+		t.Errorf("Expected 3 detents after 20ms, got %d after %v (%v)", detents, interval, ok)
+	}
+
+	// Long pauses are capped.
+	e.Data = TurnData(0, 1, time.Hour)
+	if _, interval, _ := e.Turn(); interval != MAX_TURN_INTERVAL {
+		t.Errorf("Expected interval %v, got %v", MAX_TURN_INTERVAL, interval)
+	}
+
+	for _, e := range []Event{
+		{Type: EVENT_TYPE_CW, Data: []byte{1}},
+		{Type: EVENT_TYPE_CLICK, Data: TurnData(1, 1, 0)},
+	} {
+		if _, _, ok := e.Turn(); ok {
+			t.Errorf("Expected no turn in %s", e.String())
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	// 0xF0 in the stamp goes through escaping.
+	ping := NewPing(0x12F0F134)
+	var got Event
+	if err := got.Deserialize(Marshal(*ping)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pong := NewPong(&got)
+	if stamp, ok := pong.Stamp(); !ok || stamp != 0x12F0F134 || pong.Type != EVENT_TYPE_PONG {
+		t.Errorf("Expected a pong echoing 0x12F0F134, got %x, %v, %v", stamp, ok, pong.Type)
+	}
+	if _, ok := NewEvent(EVENT_TYPE_PONG, ALL_COMBOS, 0).Stamp(); ok {
+		t.Errorf("Expected no stamp without Data")
+	}
+	if _, ok := NewLabel(1, "Chat").Stamp(); ok {
+		t.Errorf("Expected no stamp on a label")
+	}
+}
+
+func TestMeter(t *testing.T) {
+	frame := Marshal(*NewMeter([]uint8{0, 50, 255, 100, 7}))
+	if len(frame) != 10 {
+		t.Errorf("Expected a 10 byte frame for five combos, got %d", len(frame))
+	}
+	e, _ := Unmarshal(frame)
+	levels, ok := e.Meters()
+	if !ok || string(levels) != string([]uint8{0, 50, MAX_METER, 100, 7}) {
+		t.Errorf("Expected the levels capped at MAX_METER, got %v, %v", levels, ok)
+	}
+	if _, ok := NewPing(1).Meters(); ok {
+		t.Errorf("Expected no meters on a ping")
+	}
+}
@@ -0,0 +1,104 @@
+// Package reconcile keeps the knob positions on the device and the levels on
+// the host from overwriting each other.
+//
+// Every local change on the device bumps the combo's version, which travels
+// with the knob event. The host stamps each SET with the last version it had
+// seen before it read the level, so the device can tell a SET that was
+// computed before its latest local change and ignore it. Right after a local
+// change both sides also hold off periodic synchronization for a moment,
+// giving the change time to settle.
+//
+// The package is shared by the firmware and the host.
+package reconcile
+
+import (
+	"sync"
+	"time"
+)
+
+// HoldOff is how long periodic synchronization leaves a combo alone after
+// it was turned.
+const HoldOff = 500 * time.Millisecond
+
+// Newer reports whether version a is more recent than b. Versions wrap
+// around, so the comparison only holds for versions less than 128 apart.
+func Newer(a, b uint8) bool {
+	return int8(a-b) > 0
+}
+
+// Knob is the device side of a combo.
+type Knob struct {
+	version   uint8
+	touched   bool
+	lastLocal time.Time
+}
+
+// Local records a local change and returns the version to send with it.
+func (k *Knob) Local(now time.Time) uint8 {
+	k.version++
+	k.touched = true
+	k.lastLocal = now
+	return k.version
+}
+
+// Accept reports whether a SET from the host should be applied.
+//
+// A versioned SET is applied unless the knob changed after the version the
+// host had seen; it then also adopts the host's version, which keeps both
+// sides in step after the device restarted. An unversioned SET, from a host
+// that does not track versions, is only held off for a moment after a local
+// change.
+func (k *Knob) Accept(version uint8, versioned bool, now time.Time) bool {
+	if !versioned {
+		return !k.touched || now.Sub(k.lastLocal) >= HoldOff
+	}
+	if k.touched && Newer(k.version, version) {
+		return false
+	}
+	k.version = version
+	return true
+}
+
+// Tracker is the host side: the latest version seen for each combo and when
+// it was seen.
+type Tracker struct {
+	mu     sync.Mutex
+	combos map[uint8]seen
+}
+
+type seen struct {
+	version uint8
+	at      time.Time
+}
+
+// NewTracker creates an empty tracker.
+func NewTracker() *Tracker {
+	return &Tracker{combos: make(map[uint8]seen)}
+}
+
+// Local records a knob event carrying version.
+func (t *Tracker) Local(combo, version uint8, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.combos[combo] = seen{version: version, at: now}
+}
+
+// Version returns the version to stamp a SET with. It must be taken before
+// the level is read, so a knob event handled in between makes the SET
+// stale rather than current. It reports false for combos without knob
+// events, whose SETs go out unversioned.
+func (t *Tracker) Version(combo uint8) (uint8, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.combos[combo]
+	return s.version, ok
+}
+
+// Held reports whether periodic synchronization should skip the combo
+// because it was turned moments ago.
+func (t *Tracker) Held(combo uint8, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.combos[combo]
+	return ok && now.Sub(s.at) < HoldOff
+}
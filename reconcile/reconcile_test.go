@@ -0,0 +1,287 @@
+package reconcile
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestNewer(t *testing.T) {
+	for _, c := range []struct {
+		a, b uint8
+		want bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, 255, true},
+		{255, 0, false},
+		{100, 250, true},
+	} {
+		if got := Newer(c.a, c.b); got != c.want {
+			t.Errorf("Expected Newer(%d, %d) = %v, got %v", c.a, c.b, c.want, got)
+		}
+	}
+}
+
+func TestKnob_StaleSet(t *testing.T) {
+	now := time.Now()
+	var k Knob
+
+	v := k.Local(now)
+	if !k.Accept(v, true, now) {
+		t.Errorf("Expected a SET for the current version to be applied")
+	}
+	k.Local(now)
+	if k.Accept(v, true, now.Add(time.Hour)) {
+		t.Errorf("Expected a SET for an older version to be ignored")
+	}
+}
+
+func TestKnob_Wraparound(t *testing.T) {
+	now := time.Now()
+	var k Knob
+	k.Accept(254, true, now)
+
+	old := k.Local(now)
+	if v := k.Local(now); v != 0 {
+		t.Fatalf("Expected version to wrap to 0, got %d", v)
+	}
+	if k.Accept(old, true, now) {
+		t.Errorf("Expected version %d to be stale after wrapping", old)
+	}
+	if !k.Accept(0, true, now) {
+		t.Errorf("Expected the current version to be applied")
+	}
+}
+
+func TestKnob_Restart(t *testing.T) {
+	now := time.Now()
+
+	// The host still knows a version from before the device restarted.
+	var k Knob
+	if !k.Accept(200, true, now) {
+		t.Fatalf("Expected an untouched knob to apply any SET")
+	}
+	if v := k.Local(now); v != 201 {
+		t.Errorf("Expected the host's version to be adopted, got %d", v)
+	}
+}
+
+func TestKnob_Unversioned(t *testing.T) {
+	now := time.Now()
+	var k Knob
+	if !k.Accept(0, false, now) {
+		t.Errorf("Expected an untouched knob to apply unversioned SETs")
+	}
+	k.Local(now)
+	if k.Accept(0, false, now.Add(HoldOff/2)) {
+		t.Errorf("Expected unversioned SETs to be held off after a local change")
+	}
+	if !k.Accept(0, false, now.Add(HoldOff)) {
+		t.Errorf("Expected unversioned SETs to be applied after the hold-off")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Now()
+	tr := NewTracker()
+
+	if _, ok := tr.Version(1); ok {
+		t.Errorf("Expected no version before any knob event")
+	}
+	if tr.Held(1, now) {
+		t.Errorf("Expected combo without knob events not to be held")
+	}
+
+	tr.Local(1, 9, now)
+	if v, ok := tr.Version(1); !ok || v != 9 {
+		t.Errorf("Expected version 9, got %d (%v)", v, ok)
+	}
+	if !tr.Held(1, now.Add(HoldOff/2)) || tr.Held(1, now.Add(HoldOff)) {
+		t.Errorf("Expected combo to be held for %v", HoldOff)
+	}
+	if tr.Held(2, now) {
+		t.Errorf("Expected other combos not to be held")
+	}
+}
+
+// message is a knob event or SET in flight over the serial link.
+type message struct {
+	state     uint8
+	version   uint8
+	versioned bool
+}
+
+// sim runs a device and a host connected by in-order queues. Each step of
+// the host's synchronization and each delivery is a separate action, so
+// tests can interleave them the way the serial link and the goroutines of
+// the host might.
+type sim struct {
+	t   *testing.T
+	now time.Time
+
+	knob    Knob
+	display uint8
+	toHost  []message
+
+	tracker *Tracker
+	level   uint8
+	toKnob  []message
+
+	// captured is a SET the host prepared but has not sent yet.
+	captured *message
+}
+
+// newSim starts with both sides at level and the host knowing the knob's
+// version. Before the host has seen a knob event its SETs are unversioned
+// and only the hold-off protects the knob, see TestKnob_Unversioned.
+func newSim(t *testing.T, level uint8) *sim {
+	s := &sim{t: t, now: time.Now(), display: level, level: level, tracker: NewTracker()}
+	s.turn(level)
+	s.deliverToHost()
+	s.now = s.now.Add(HoldOff)
+	return s
+}
+
+// turn changes the knob on the device.
+func (s *sim) turn(state uint8) {
+	s.display = state
+	v := s.knob.Local(s.now)
+	s.toHost = append(s.toHost, message{state: state, version: v, versioned: true})
+}
+
+// capture starts a periodic synchronization on the host: it takes the
+// version and then reads the level, like setEventSender.
+func (s *sim) capture() {
+	if s.tracker.Held(0, s.now) {
+		return
+	}
+	v, ok := s.tracker.Version(0)
+	s.captured = &message{state: s.level, version: v, versioned: ok}
+}
+
+// send finishes the synchronization started by capture.
+func (s *sim) send() {
+	if s.captured != nil {
+		s.toKnob = append(s.toKnob, *s.captured)
+		s.captured = nil
+	}
+}
+
+// deliverToHost handles the oldest knob event on the host.
+func (s *sim) deliverToHost() {
+	if len(s.toHost) == 0 {
+		return
+	}
+	m := s.toHost[0]
+	s.toHost = s.toHost[1:]
+	s.tracker.Local(0, m.version, s.now)
+	s.level = m.state
+}
+
+// deliverToKnob handles the oldest SET on the device and returns what the
+// knob showed before.
+func (s *sim) deliverToKnob() uint8 {
+	before := s.display
+	if len(s.toKnob) == 0 {
+		return before
+	}
+	m := s.toKnob[0]
+	s.toKnob = s.toKnob[1:]
+	if s.knob.Accept(m.version, m.versioned, s.now) {
+		s.display = m.state
+	}
+	return before
+}
+
+func TestSim_SyncDuringTurn(t *testing.T) {
+	s := newSim(t, 41)
+
+	// The host reads 41, the user turns to 50 and the SET for 41 arrives
+	// after the turn.
+	s.capture()
+	s.turn(50)
+	s.send()
+	s.deliverToKnob()
+	if s.display != 50 {
+		t.Fatalf("Expected the knob to stay at 50, got %d", s.display)
+	}
+
+	s.deliverToHost()
+	if s.level != 50 {
+		t.Fatalf("Expected the host to follow the knob to 50, got %d", s.level)
+	}
+
+	// Once the hold-off passed, synchronization goes on as usual.
+	s.capture()
+	s.send()
+	if len(s.toKnob) != 0 {
+		t.Errorf("Expected the sync right after the turn to be held off")
+	}
+	s.now = s.now.Add(HoldOff)
+	s.capture()
+	s.send()
+	s.deliverToKnob()
+	if s.display != 50 || s.level != 50 {
+		t.Errorf("Expected both sides at 50, got knob %d and host %d", s.display, s.level)
+	}
+}
+
+func TestSim_HostChange(t *testing.T) {
+	s := newSim(t, 40)
+
+	// A level set on the host, e.g. over the API, carries the version the
+	// host has seen and so reaches the knob.
+	s.level = 70
+	s.capture()
+	s.send()
+	s.deliverToKnob()
+	if s.display != 70 {
+		t.Errorf("Expected the host's level to be shown, got %d", s.display)
+	}
+}
+
+func TestSim_RandomInterleavings(t *testing.T) {
+	for seed := range uint64(500) {
+		rng := rand.New(rand.NewPCG(seed, 1))
+		s := newSim(t, 50)
+
+		for range 200 {
+			switch rng.IntN(6) {
+			case 0:
+				s.turn(uint8(rng.IntN(101)))
+			case 1:
+				s.capture()
+			case 2:
+				s.send()
+			case 3:
+				s.deliverToHost()
+			case 4:
+				// The host only changes levels by following the knob, so an
+				// applied SET must never move the knob away from where the
+				// user left it.
+				if before := s.deliverToKnob(); s.display != before {
+					t.Fatalf("Seed %d: knob snapped back from %d to %d", seed, before, s.display)
+				}
+			case 5:
+				s.now = s.now.Add(time.Duration(rng.IntN(300)) * time.Millisecond)
+			}
+		}
+
+		// Let everything in flight arrive, then synchronize once more.
+		s.send()
+		for len(s.toHost) > 0 || len(s.toKnob) > 0 {
+			s.deliverToHost()
+			s.deliverToKnob()
+		}
+		s.now = s.now.Add(HoldOff)
+		s.capture()
+		s.send()
+		s.deliverToKnob()
+
+		if s.display != s.level {
+			t.Fatalf("Seed %d: expected knob and host to agree, got %d and %d", seed, s.display, s.level)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package store keeps the firmware's state across power cycles in a few
+// erase blocks of flash.
+//
+// Records are written to fixed size slots one after the other, wrapping
+// around at the end of the region, so all blocks wear evenly. A block is
+// only erased right before its first slot is written, which leaves older
+// records in the other blocks to fall back on if power fails mid-write.
+// Every record carries its format version, a sequence number and a CRC;
+// loading picks the valid record with the highest sequence number.
+//
+// The package is used by the firmware and tested on the host.
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"unicode/utf8"
+)
+
+// BlockDevice is flash memory as exposed by machine.Flash. Offsets are in
+// bytes, erase blocks are counted in blocks.
+type BlockDevice interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Size() int64
+	WriteBlockSize() int64
+	EraseBlockSize() int64
+	EraseBlocks(start, len int64) error
+}
+
+const (
+	// SlotSize is the space each record takes.
+	SlotSize = 256
+	// Version is the record format written by Save.
+	Version = 1
+	// MaxName bounds the saved names in bytes.
+	MaxName = 32
+
+	magic      = 0x4443
+	headerSize = 8
+	crcSize    = 4
+	maxPayload = SlotSize - headerSize - crcSize
+)
+
+var (
+	ErrTooLarge = errors.New("state does not fit a record")
+	ErrGeometry = errors.New("flash geometry does not fit the record layout")
+
+	errVersion   = errors.New("unknown record version")
+	errTruncated = errors.New("record is truncated")
+)
+
+// Combo is the saved state of one combo.
+type Combo struct {
+	Level uint8
+	Style uint8
+	Muted bool
+	Name  string
+}
+
+// State is everything saved.
+type State struct {
+	Combos []Combo
+}
+
+// Store saves States to the first blocks of a BlockDevice.
+type Store struct {
+	dev      BlockDevice
+	slots    int64
+	perBlock int64
+
+	// next is the slot to write next and seq the sequence number of the
+	// last record.
+	next int64
+	seq  uint32
+	// last is the payload of the last record, so saving it again can be
+	// skipped.
+	last []byte
+}
+
+// New creates a Store over the first blocks erase blocks of dev. It needs
+// at least two, so there is always an older record to fall back on.
+func New(dev BlockDevice, blocks int64) (*Store, error) {
+	eraseSize := dev.EraseBlockSize()
+	switch {
+	case blocks < 2:
+		return nil, errors.New("store needs at least two erase blocks")
+	case blocks*eraseSize > dev.Size():
+		return nil, errors.New("store does not fit the flash")
+	case eraseSize%SlotSize != 0 || SlotSize%dev.WriteBlockSize() != 0:
+		return nil, ErrGeometry
+	}
+	return &Store{
+		dev:      dev,
+		slots:    blocks * eraseSize / SlotSize,
+		perBlock: eraseSize / SlotSize,
+	}, nil
+}
+
+// Load returns the newest state that can be read, and false if there is
+// none, such as on first boot. It also finds where Save continues, so it
+// has to be called first.
+func (s *Store) Load() (State, bool, error) {
+	var (
+		st     State
+		found  bool
+		stSeq  uint32
+		latest = int64(-1)
+	)
+	buf := make([]byte, SlotSize)
+	for slot := int64(0); slot < s.slots; slot++ {
+		if _, err := s.dev.ReadAt(buf, slot*SlotSize); err != nil {
+			return State{}, false, err
+		}
+		version, seq, payload, ok := parse(buf)
+		if !ok {
+			continue
+		}
+		if latest < 0 || newer(seq, s.seq) {
+			latest, s.seq = slot, seq
+		}
+		// Records of a format this firmware does not know are skipped,
+		// but still count for the sequence.
+		decoded, err := decode(version, payload)
+		if err == nil && (!found || newer(seq, stSeq)) {
+			st, found, stSeq = decoded, true, seq
+			s.last = append(s.last[:0], payload...)
+		}
+	}
+	if latest >= 0 {
+		s.next = (latest + 1) % s.slots
+	}
+	if !found || stSeq != s.seq {
+		s.last = nil
+	}
+	return st, found, nil
+}
+
+// Save writes st as a new record unless it equals the last one.
+func (s *Store) Save(st State) error {
+	payload, err := encode(st)
+	if err != nil {
+		return err
+	}
+	if s.last != nil && string(payload) == string(s.last) {
+		return nil
+	}
+
+	slot, err := s.freeSlot()
+	if err != nil {
+		return err
+	}
+
+	rec := make([]byte, SlotSize)
+	for i := range rec {
+		rec[i] = 0xFF
+	}
+	binary.LittleEndian.PutUint16(rec, magic)
+	rec[2] = Version
+	rec[3] = uint8(len(payload))
+	binary.LittleEndian.PutUint32(rec[4:], s.seq+1)
+	copy(rec[headerSize:], payload)
+	end := headerSize + len(payload)
+	binary.LittleEndian.PutUint32(rec[end:], crc32.ChecksumIEEE(rec[:end]))
+
+	if _, err := s.dev.WriteAt(rec, slot*SlotSize); err != nil {
+		return err
+	}
+	s.seq++
+	s.next = (slot + 1) % s.slots
+	s.last = payload
+	return nil
+}
+
+// freeSlot returns the next slot that can be written, erasing its block if
+// it is the block's first. Slots left half written by a power failure are
+// skipped, as flash cannot be written twice without an erase.
+func (s *Store) freeSlot() (int64, error) {
+	buf := make([]byte, SlotSize)
+	for tries := int64(0); tries < s.slots; tries++ {
+		slot := s.next
+		if slot%s.perBlock == 0 {
+			return slot, s.dev.EraseBlocks(slot/s.perBlock, 1)
+		}
+		if _, err := s.dev.ReadAt(buf, slot*SlotSize); err != nil {
+			return 0, err
+		}
+		if erased(buf) {
+			return slot, nil
+		}
+		s.next = (slot + 1) % s.slots
+	}
+	return 0, errors.New("no free slot")
+}
+
+func erased(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// newer reports whether sequence number a is more recent than b.
+func newer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// parse checks a slot's record and returns its version, sequence number
+// and payload.
+func parse(rec []byte) (version uint8, seq uint32, payload []byte, ok bool) {
+	if binary.LittleEndian.Uint16(rec) != magic {
+		return 0, 0, nil, false
+	}
+	version = rec[2]
+	end := headerSize + int(rec[3])
+	if end+crcSize > len(rec) {
+		return 0, 0, nil, false
+	}
+	if binary.LittleEndian.Uint32(rec[end:]) != crc32.ChecksumIEEE(rec[:end]) {
+		return 0, 0, nil, false
+	}
+	return version, binary.LittleEndian.Uint32(rec[4:]), rec[headerSize:end], true
+}
+
+// encode lays out a State in the current Version: the number of combos,
+// then per combo its level, style, flags, name length and name.
+func encode(st State) ([]byte, error) {
+	payload := []byte{uint8(len(st.Combos))}
+	for _, c := range st.Combos {
+		name := c.Name
+		if len(name) > MaxName {
+			// Cut at a rune boundary so the name stays valid UTF-8.
+			n := MaxName
+			for n > 0 && !utf8.RuneStart(name[n]) {
+				n--
+			}
+			name = name[:n]
+		}
+		var flags uint8
+		if c.Muted {
+			flags |= 1
+		}
+		payload = append(payload, c.Level, c.Style, flags, uint8(len(name)))
+		payload = append(payload, name...)
+	}
+	if len(payload) > maxPayload || len(st.Combos) > 255 {
+		return nil, ErrTooLarge
+	}
+	return payload, nil
+}
+
+// decode reads a payload written in version.
+func decode(version uint8, payload []byte) (State, error) {
+	if version != 1 {
+		return State{}, errVersion
+	}
+	if len(payload) < 1 {
+		return State{}, errTruncated
+	}
+	st := State{Combos: make([]Combo, payload[0])}
+	p := payload[1:]
+	for i := range st.Combos {
+		if len(p) < 4 || len(p) < 4+int(p[3]) {
+			return State{}, errTruncated
+		}
+		st.Combos[i] = Combo{
+			Level: p[0],
+			Style: p[1],
+			Muted: p[2]&1 != 0,
+			Name:  string(p[4 : 4+int(p[3])]),
+		}
+		p = p[4+int(p[3]):]
+	}
+	return st, nil
+}
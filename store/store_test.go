@@ -0,0 +1,228 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flash is a BlockDevice that behaves like NOR flash: writes can only clear
+// bits and erases set whole blocks back to 0xFF.
+type flash struct {
+	data   []byte
+	erases []int
+	// failAfter cuts the next write short after that many bytes, like a
+	// power failure.
+	failAfter int
+}
+
+func newFlash(blocks int) *flash {
+	f := &flash{data: make([]byte, blocks*4096), erases: make([]int, blocks), failAfter: -1}
+	for i := range f.data {
+		f.data[i] = 0xFF
+	}
+	return f
+}
+
+func (f *flash) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *flash) WriteAt(p []byte, off int64) (int, error) {
+	n := len(p)
+	if f.failAfter >= 0 {
+		n, f.failAfter = f.failAfter, -1
+	}
+	for i := 0; i < n; i++ {
+		f.data[off+int64(i)] &= p[i]
+	}
+	if n < len(p) {
+		return n, errors.New("power lost")
+	}
+	return n, nil
+}
+
+func (f *flash) Size() int64           { return int64(len(f.data)) }
+func (f *flash) WriteBlockSize() int64 { return 256 }
+func (f *flash) EraseBlockSize() int64 { return 4096 }
+
+func (f *flash) EraseBlocks(start, n int64) error {
+	for b := start; b < start+n; b++ {
+		f.erases[b]++
+		for i := b * 4096; i < (b+1)*4096; i++ {
+			f.data[i] = 0xFF
+		}
+	}
+	return nil
+}
+
+func testState(level uint8) State {
+	return State{Combos: []Combo{
+		{Level: level, Name: "Game"},
+		{Level: 50, Style: 2, Muted: true, Name: "Discord"},
+		{},
+	}}
+}
+
+func open(t *testing.T, f *flash) (*Store, State, bool) {
+	t.Helper()
+	s, err := New(f, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	st, ok, err := s.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return s, st, ok
+}
+
+func TestLoadEmpty(t *testing.T) {
+	if _, _, ok := open(t, newFlash(4)); ok {
+		t.Errorf("Expected no state on blank flash")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	if err := s.Save(testState(10)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Save(testState(20)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, st, ok := open(t, f)
+	if !ok || !reflect.DeepEqual(st, testState(20)) {
+		t.Errorf("Expected %+v, got %+v, %v", testState(20), st, ok)
+	}
+}
+
+func TestSaveSkipsUnchanged(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	s.Save(testState(10))
+
+	s, _, _ = open(t, f)
+	s.Save(testState(10))
+	if s.seq != 1 {
+		t.Errorf("Expected the unchanged state not to be written again, got sequence %d", s.seq)
+	}
+}
+
+func TestWearLevelling(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	// 16 slots per block, so 640 saves go around the region ten times.
+	for i := 0; i < 640; i++ {
+		if err := s.Save(testState(uint8(i % 101))); err != nil {
+			t.Fatalf("Save %d: unexpected error: %v", i, err)
+		}
+		// Reopen now and then, as a reboot would.
+		if i%97 == 0 {
+			s, _, _ = open(t, f)
+		}
+	}
+	for b, n := range f.erases {
+		if n != 10 {
+			t.Errorf("Expected block %d to be erased 10 times, got %d", b, n)
+		}
+	}
+	if _, st, _ := open(t, f); st.Combos[0].Level != 639%101 {
+		t.Errorf("Expected the last level %d, got %d", 639%101, st.Combos[0].Level)
+	}
+}
+
+func TestPowerFailure(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	s.Save(testState(10))
+
+	f.failAfter = 20
+	if err := s.Save(testState(20)); err == nil {
+		t.Fatalf("Expected the cut write to fail")
+	}
+
+	s, st, ok := open(t, f)
+	if !ok || st.Combos[0].Level != 10 {
+		t.Errorf("Expected the previous state, got %+v, %v", st, ok)
+	}
+	if err := s.Save(testState(30)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, st, _ := open(t, f); st.Combos[0].Level != 30 {
+		t.Errorf("Expected level 30 after the half written slot, got %d", st.Combos[0].Level)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	s.Save(testState(10))
+	s.Save(testState(20))
+
+	// Flip a payload bit of the second record.
+	f.data[SlotSize+headerSize+2] ^= 1
+	if _, st, ok := open(t, f); !ok || st.Combos[0].Level != 10 {
+		t.Errorf("Expected the CRC to reject the corrupt record, got %+v, %v", st, ok)
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	s.Save(testState(10))
+	s.Save(testState(20))
+
+	// Turn the second record into one of a future version with a valid CRC.
+	rec := f.data[SlotSize : 2*SlotSize]
+	rec[2] = Version + 1
+	end := headerSize + int(rec[3])
+	binary.LittleEndian.PutUint32(rec[end:], crc32.ChecksumIEEE(rec[:end]))
+
+	s, st, ok := open(t, f)
+	if !ok || st.Combos[0].Level != 10 {
+		t.Errorf("Expected the record of the known version, got %+v, %v", st, ok)
+	}
+	if s.seq != 2 || s.next != 2 {
+		t.Errorf("Expected saving to continue after the unknown record, got sequence %d, slot %d", s.seq, s.next)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(newFlash(4), 1); err == nil {
+		t.Errorf("Expected error for a single block")
+	}
+	if _, err := New(newFlash(4), 5); err == nil {
+		t.Errorf("Expected error for a region larger than the flash")
+	}
+}
+
+func TestLongName(t *testing.T) {
+	f := newFlash(4)
+	s, _, _ := open(t, f)
+	prefix := strings.Repeat("a", MaxName-1)
+	if err := s.Save(State{Combos: []Combo{{Name: prefix + "é"}}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, st, ok := open(t, f)
+	if !ok || len(st.Combos) != 1 || st.Combos[0].Name != prefix {
+		t.Errorf("Expected the name to be cut before the split rune, got %+v, %v", st, ok)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	s, _, _ := open(t, newFlash(4))
+	st := State{Combos: make([]Combo, 10)}
+	for i := range st.Combos {
+		st.Combos[i].Name = "a name that is far too long to be kept whole"
+	}
+	if err := s.Save(st); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
@@ -1,338 +1,2567 @@
 package main
 
 import (
+	"desktop-audio-ctrl/meter"
+	"desktop-audio-ctrl/pkg/audio"
+	"desktop-audio-ctrl/pkg/audio/pulse"
+	"desktop-audio-ctrl/pkg/audio/wasapi"
+	"desktop-audio-ctrl/pkg/control"
+	"desktop-audio-ctrl/pkg/curve"
+	"desktop-audio-ctrl/pkg/focus"
+	"desktop-audio-ctrl/pkg/hostconfig"
+	"desktop-audio-ctrl/pkg/httpapi"
+	"desktop-audio-ctrl/pkg/ipc"
+	"desktop-audio-ctrl/pkg/logging"
+	"desktop-audio-ctrl/pkg/metrics"
+	"desktop-audio-ctrl/pkg/mqttbridge"
+	"desktop-audio-ctrl/pkg/osc"
+	"desktop-audio-ctrl/pkg/relative"
 	"desktop-audio-ctrl/pkg/reliableserial"
+	"desktop-audio-ctrl/pkg/service"
 	"desktop-audio-ctrl/protocol"
+	"desktop-audio-ctrl/reconcile"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"log/slog"
+	"math"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/dikkadev/prettyslog"
-	"github.com/go-ole/go-ole"
-	"github.com/moutend/go-wca/pkg/wca"
+	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v2"
 )
 
-type ComboConfig struct {
-	Combo    uint8  `yaml:"combo"`
-	DeviceID string `yaml:"deviceID"`
-}
-
-type Config struct {
-	PortName           string        `yaml:"portName"`
-	BaudRate           int           `yaml:"baudRate"`
-	Combos             []ComboConfig `yaml:"combos"`
-	ConfigReloadPeriod time.Duration `yaml:"configReloadPeriod"`
-	SetEventPeriod     time.Duration `yaml:"setEventPeriod"`
-}
+type (
+	Config      = hostconfig.Config
+	ComboConfig = hostconfig.ComboConfig
+)
 
 var (
 	config     Config
 	configFile = "config.yaml"
 	configLock sync.RWMutex
-	oleLock    sync.Mutex
+	// activeProfile and activeCombos are guarded by configLock and always
+	// match config.
+	activeProfile string
+	activeCombos  []ComboConfig
+
+	backend audio.Backend
+
+	hub   = control.NewHub()
+	stats = metrics.New()
+
+	// logs is set once logging is configured, together with the loggers
+	// of the subsystems whose level can be changed separately.
+	logs      *logging.Logging
+	serialLog = slog.Default()
+	audioLog  = slog.Default()
+	configLog = slog.Default()
+	// deviceChan is set while the daemon runs so level changes made through
+	// the control interfaces also reach the device screens.
+	deviceChan chan<- reliableserial.Serializable
+	// versions tracks the knob versions seen so SETs computed before the
+	// latest turn are ignored by the device.
+	versions = reconcile.NewTracker()
+	// accelerator keeps the speed of turns of relative combos.
+	accelerator = relative.NewAccelerator()
+	// mainProgress and serialProgress are reported by the config watcher
+	// and the device monitor; the watchdog is only fed while both are.
+	mainProgress   service.Progress
+	serialProgress service.Progress
+
+	published     = make(map[uint8]control.ComboStatus)
+	publishedLock sync.Mutex
 
-	mmde     *wca.IMMDeviceEnumerator
-	mmdeOnce sync.Once
+	configListeners     []chan struct{}
+	configListenersLock sync.Mutex
+
+	resolved     = make(map[targetKey]*resolution)
+	resolvedLock sync.Mutex
+
+	// switchers holds the target each switcher combo shows.
+	switchers     = make(map[uint8]int)
+	switchersLock sync.Mutex
+
+	// focused holds the tracker of every focus combo, keyed by follow mode
+	// too so a changed mode starts over.
+	focused     = make(map[focusKey]*focus.Tracker)
+	focusedLock sync.Mutex
 
 	writeChan    = make(chan protocol.Event, 100)
 	eventChan    = make(chan protocol.Event, 100)
 	shutdownChan = make(chan struct{})
 )
 
-func loadConfig() {
+// readConfig loads and validates the config file and returns the keys it
+// ignored. The -port flag always overrides the configured port.
+func readConfig() (Config, []string, error) {
+	newConfig, unknown, err := hostconfig.Load(configFile)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if *portName != "" {
+		newConfig.PortName = *portName
+	}
+	if err := newConfig.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return newConfig, unknown, nil
+}
+
+// warnUnknownKeys logs the config keys readConfig ignored.
+func warnUnknownKeys(unknown []string) {
+	for _, key := range unknown {
+		configLog.Warn("ignoring unknown config key", "key", key)
+	}
+}
+
+// applyConfig swaps in a validated config and lets everything that depends
+// on it know. The active profile is kept if the new config still has it.
+func applyConfig(newConfig Config) {
 	configLock.Lock()
-	defer configLock.Unlock()
+	config = newConfig
+	activeProfile = profileFor(&newConfig, activeProfile)
+	activeCombos = newConfig.ProfileCombos(activeProfile)
+	configLock.Unlock()
+
+	signalConfigChanged()
+}
+
+// profileFor returns profile if c has it, and c's default profile otherwise.
+func profileFor(c *Config, profile string) string {
+	if c.Profile(profile) != nil {
+		return profile
+	}
+	return c.DefaultProfile()
+}
+
+func signalConfigChanged() {
+	configListenersLock.Lock()
+	defer configListenersLock.Unlock()
+	for _, ch := range configListeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
 
-	data, err := os.ReadFile(configFile)
+// configChanged returns a channel that receives a value after every applied
+// config change or profile switch.
+func configChanged() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	configListenersLock.Lock()
+	configListeners = append(configListeners, ch)
+	configListenersLock.Unlock()
+	return ch
+}
+
+// reloadConfig re-reads the config file while the daemon runs. A config
+// that fails validation is rejected as a whole and the previous one stays
+// in effect. Missing devices only cause a warning, as at startup. The
+// outcome is logged and shown on the device screens.
+func reloadConfig(reason string) {
+	newConfig, unknown, err := readConfig()
+	stats.Reload(err)
 	if err != nil {
-		slog.Warn("error reading config file", "err", err)
+		configLog.Error("configuration rejected, keeping previous configuration", "reason", reason, "err", err)
+		notifyDevice(protocol.NOTIFY_CONFIG_ERROR)
+		return
+	}
+
+	configLock.RLock()
+	unchanged := reflect.DeepEqual(config, newConfig)
+	logChanged := !reflect.DeepEqual(config.Log, newConfig.Log)
+	configLock.RUnlock()
+	if unchanged {
+		configLog.Debug("configuration unchanged", "reason", reason)
 		return
 	}
 
-	var newConfig Config
-	err = yaml.Unmarshal(data, &newConfig)
+	applyConfig(newConfig)
+	warnUnknownKeys(unknown)
+	if logChanged {
+		// Levels changed through the API stay until the log section changes.
+		restart, err := logs.Reconfigure(newConfig.Log)
+		if err != nil {
+			configLog.Error("error applying log levels", "err", err)
+		} else if restart {
+			configLog.Warn("log file, format and rotation changes take effect after a restart")
+		}
+	}
+	configLog.Info("configuration reloaded", "reason", reason)
+	notifyDevice(protocol.NOTIFY_CONFIG_RELOADED)
+	warnMissingDevices()
+	warnUnmeteredApps()
+}
+
+// warnMissingDevices logs combos of the active profile whose devices are
+// absent. Devices may legitimately be missing, e.g. virtual devices of an
+// application that has not started yet or an unplugged headset, so the
+// combos stay configured and bind once the device appears.
+func warnMissingDevices() {
+	devices, err := backend.Devices()
 	if err != nil {
-		slog.Warn("error parsing config file", "err", err)
+		audioLog.Warn("error listing devices", "err", err)
 		return
 	}
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if err := config.CheckDevices(activeProfile, devices); err != nil {
+		audioLog.Warn("some combos do not resolve to a device", "err", err)
+	}
+}
 
-	config = newConfig
-	slog.Info("configuration reloaded")
+// warnUnmeteredApps logs the combos that rely on peaks of apps when the
+// backend cannot measure them: focus combos following the loudest app then
+// follow the most recent one, and the meters of app combos stay empty.
+func warnUnmeteredApps() {
+	sb, err := sessions()
+	if err != nil || audio.MetersSessions(sb) {
+		return
+	}
+	configLock.RLock()
+	defer configLock.RUnlock()
+	combos := slices.Clone(config.Combos)
+	for _, p := range config.Profiles {
+		combos = append(combos, p.Combos...)
+	}
+	for _, c := range combos {
+		switch {
+		case c.IsFocus() && c.Follow == focus.Loudest:
+			audioLog.Warn("backend cannot meter apps, focus combo follows the most recent app instead", "combo", c.Combo, "backend", backend.Name())
+		case c.HasApp() && config.MeterPeriod > 0:
+			audioLog.Warn("backend cannot meter apps, combo shows no meter", "combo", c.Combo, "backend", backend.Name())
+		}
+	}
 }
 
 func getComboConfig(combo uint8) *ComboConfig {
 	configLock.RLock()
 	defer configLock.RUnlock()
 
-	for _, c := range config.Combos {
+	for _, c := range activeCombos {
 		if c.Combo == combo {
+			bindFocus(&c)
 			return &c
 		}
 	}
 	return nil
 }
 
-func getCurrentVolume(deviceID string) (int, error) {
-	vol, err := oleInvoke(deviceID, func(aev *wca.IAudioEndpointVolume) (interface{}, error) {
-		var level float32
-		err := aev.GetMasterVolumeLevelScalar(&level)
-		if err != nil {
-			return nil, err
-		}
-		vol := int(level * 100.0)
-		return vol, nil
-	})
-	if err != nil {
-		return 0, err
-	}
-	return vol.(int), nil
-}
+// liveCombos returns the active combos with focus combos bound to the apps
+// they follow.
+func liveCombos() []ComboConfig {
+	configLock.RLock()
+	combos := slices.Clone(activeCombos)
+	configLock.RUnlock()
 
-func setVolume(deviceID string, volumeLevel float32) error {
-	_, err := oleInvoke(deviceID, func(aev *wca.IAudioEndpointVolume) (interface{}, error) {
-		err := aev.SetMasterVolumeLevelScalar(volumeLevel, nil)
-		if err != nil {
-			return nil, fmt.Errorf("SetMasterVolumeLevelScalar failed: %w", err)
-		}
-		return nil, nil
-	})
-	return err
+	for i := range combos {
+		bindFocus(&combos[i])
+	}
+	return combos
 }
 
-func getDeviceEnumerator() (*wca.IMMDeviceEnumerator, error) {
-	var err error
-	mmdeOnce.Do(func() {
-		err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde)
-		if err != nil {
-			slog.Error("failed to create IMMDeviceEnumerator", "err", err)
-		}
-	})
-	return mmde, err
+// statePath returns where runtime state is kept.
+func statePath() string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if config.StateFile != "" {
+		return config.StateFile
+	}
+	return hostconfig.DefaultStatePath()
 }
 
-func oleInvoke(deviceID string, f func(aev *wca.IAudioEndpointVolume) (interface{}, error)) (interface{}, error) {
-	oleLock.Lock()
-	defer oleLock.Unlock()
-
-	mmde, err := getDeviceEnumerator()
+// restoreProfile activates the profile that was active when the host last
+// ran, if the config still has it.
+func restoreProfile() {
+	st, err := hostconfig.LoadState(statePath())
 	if err != nil {
-		return nil, err
+		slog.Warn("error loading state", "err", err)
+		return
 	}
 
-	var mmd *wca.IMMDevice
-	if err = mmde.GetDevice(deviceID, &mmd); err != nil {
-		return nil, fmt.Errorf("GetDevice failed: %w", err)
+	configLock.Lock()
+	if config.Profile(st.ActiveProfile) != nil {
+		activeProfile = st.ActiveProfile
+		activeCombos = config.ProfileCombos(activeProfile)
 	}
-	defer mmd.Release()
+	configLock.Unlock()
+}
 
-	var ps *wca.IPropertyStore
-	if err = mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
-		return nil, fmt.Errorf("OpenPropertyStore failed: %w", err)
+// switchProfile rebinds the combos to another profile, remembers it for the
+// next start and pushes the new names and levels to the device.
+func switchProfile(name string) error {
+	configLock.Lock()
+	if config.Profile(name) == nil {
+		configLock.Unlock()
+		return fmt.Errorf("%w %q", control.ErrUnknownProfile, name)
 	}
-	defer ps.Release()
+	changed := name != activeProfile
+	activeProfile = name
+	activeCombos = config.ProfileCombos(name)
+	configLock.Unlock()
 
-	var aev *wca.IAudioEndpointVolume
-	if err = mmd.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &aev); err != nil {
-		return nil, fmt.Errorf("Activate IAudioEndpointVolume failed: %w", err)
+	if !changed {
+		return nil
 	}
-	defer aev.Release()
+	slog.Info("switched profile", "profile", name)
+	hub.Publish(control.Update{Kind: control.KindProfile, Profile: name})
+	signalConfigChanged()
 
-	return f(aev)
+	if err := hostconfig.SaveState(statePath(), hostconfig.State{ActiveProfile: name}); err != nil {
+		slog.Warn("error saving state", "err", err)
+	}
+	return nil
 }
 
-func handleEvent(event protocol.Event) {
-	slog.Info("received event", "event", event.String())
-
-	comboConfig := getComboConfig(event.Combo)
-	if comboConfig == nil {
-		slog.Warn("no configuration found for combo", "combo", event.Combo)
-		return
+// runAction performs a gesture action. It reports false if there is none,
+// in which case the gesture sets the level like a turn.
+func runAction(combo uint8, gesture string) bool {
+	action, err := hostconfig.ParseAction(gesture)
+	if err != nil || action.Kind == hostconfig.ActionNone {
+		return false
 	}
 
-	state := event.State
-	if state < 0 {
-		state = 0
-	} else if state > 100 {
-		state = 100
+	if action.Kind == hostconfig.ActionToggleMute {
+		status, err := hostController{}.ToggleMute(combo)
+		if err != nil {
+			audioLog.Error("error toggling mute", "combo", combo, "err", err)
+			return true
+		}
+		// The click zeroed the knob on the device, so put the level back.
+		pushLevel(combo, status.Level)
+		return true
 	}
+	if action.Kind == hostconfig.ActionSetDefault {
+		c := getComboConfig(combo)
+		if c == nil || c.IsOSC() {
+			slog.Warn("combo has no endpoint to make the default", "combo", combo)
+			return true
+		}
+		deviceID, err := resolveDevice(c)
+		if err != nil {
+			audioLog.Debug("combo has no device", "combo", combo, "err", err)
+			return true
+		}
+		if err := setDefaultDevice(deviceID, action.Role); err != nil {
+			audioLog.Error("error setting default device", "combo", combo, "err", err)
+			return true
+		}
+		audioLog.Info("set default device", "combo", combo, "device", deviceID)
+		return true
+	}
+	if action.Kind == hostconfig.ActionNextProfile {
+		configLock.RLock()
+		action.Profile = config.NextProfile(activeProfile)
+		configLock.RUnlock()
+		if action.Profile == "" {
+			slog.Warn("no profiles configured", "combo", combo)
+			return true
+		}
+	}
+	if err := switchProfile(action.Profile); err != nil {
+		slog.Error("error switching profile", "combo", combo, "err", err)
+	}
+	return true
+}
 
-	volumeLevel := float32(state) / 100.0
+// targetKey identifies one target of a combo.
+type targetKey struct {
+	combo  uint8
+	target int
+}
 
-	err := setVolume(comboConfig.DeviceID, volumeLevel)
-	if err != nil {
-		slog.Error("error setting volume", "deviceID", comboConfig.DeviceID, "err", err)
-	} else {
-		slog.Info("set volume", "state", state, "deviceID", comboConfig.DeviceID)
-	}
+// focusKey identifies the tracker of a focus combo.
+type focusKey struct {
+	combo  uint8
+	follow string
 }
 
-func configReloader(shutdownChan <-chan struct{}) {
-	configLock.RLock()
-	period := config.ConfigReloadPeriod
-	configLock.RUnlock()
+// focusPeriod is how often focus combos look at the playing streams.
+const focusPeriod = 500 * time.Millisecond
 
-	ticker := time.NewTicker(period)
-	defer ticker.Stop()
+var errNoFocus = errors.New("no app has played yet")
 
-	for {
-		select {
-		case <-ticker.C:
-			loadConfig()
-		case <-shutdownChan:
-			slog.Info("configuration reloader shutting down")
-			return
-		}
-	}
+type resolution struct {
+	selector audio.Selector
+	deviceID string
+	err      error
+	stale    bool
 }
 
-func setEventSender(writeChan chan<- reliableserial.Serializable, shutdownChan <-chan struct{}) {
-	// Initialize OLE for this goroutine
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+// resolveDevice returns the endpoint ID of the combo's reference target,
+// the one its level is read from.
+func resolveDevice(c *ComboConfig) (string, error) {
+	return resolveTarget(c, c.Reference)
+}
 
-	err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
-	if err != nil {
-		log.Fatalf("Failed to initialize COM in setEventSender: %v", err)
+// resolveTarget returns the endpoint ID a combo's target is currently bound
+// to. The result is cached until the selector changes or the device set
+// changes.
+func resolveTarget(c *ComboConfig, target int) (string, error) {
+	if c.IsFocus() && c.App == "" {
+		return "", errNoFocus
 	}
-	defer ole.CoUninitialize()
+	sel := c.Gang()[target].Selector()
+	key := targetKey{combo: c.Combo, target: target}
 
-	sendSetEvents := func() {
-		slog.Info("sending set events to synchronize device state")
-		configLock.RLock()
-		combos := config.Combos
-		configLock.RUnlock()
+	resolvedLock.Lock()
+	defer resolvedLock.Unlock()
 
-		for _, combo := range combos {
-			// Retrieve the current volume level
-			currentVolume, err := getCurrentVolume(combo.DeviceID)
-			if err != nil {
-				slog.Error("error getting current volume", "deviceID", combo.DeviceID, "err", err)
-				continue
-			}
+	// Apps start and stop without any device changing, so they are looked
+	// up every time.
+	prev, ok := resolved[key]
+	if ok && !prev.stale && prev.selector == sel && sel.App == "" {
+		return prev.deviceID, prev.err
+	}
 
-			// Create a set event
-			event := &protocol.Event{
-				Type:  protocol.EVENT_TYPE_SET,
-				Combo: combo.Combo,
-				State: uint8(currentVolume),
-			}
+	deviceID, err := lookupDevice(sel)
+	resolved[key] = &resolution{selector: sel, deviceID: deviceID, err: err}
 
-			// Send the packet to writeChan
-			select {
-			case writeChan <- event:
-			case <-shutdownChan:
-				slog.Info("set event sender received shutdown signal")
-				return
-			}
+	// Only log when the binding actually changed to keep periodic syncs quiet.
+	if !ok || prev.selector != sel || prev.deviceID != deviceID || fmt.Sprint(prev.err) != fmt.Sprint(err) {
+		var ambiguous *audio.AmbiguousError
+		switch {
+		case errors.As(err, &ambiguous):
+			audioLog.Warn("device selector is ambiguous", "combo", c.Combo, "selector", sel.String(), "candidates", len(ambiguous.Candidates), "err", err)
+		case errors.Is(err, audio.ErrNoMatch):
+			audioLog.Warn("no device matches selector", "combo", c.Combo, "selector", sel.String())
+		case errors.Is(err, audio.ErrNotRunning):
+			audioLog.Info("app is not running", "combo", c.Combo, "selector", sel.String())
+		case err != nil:
+			audioLog.Error("error resolving device", "combo", c.Combo, "selector", sel.String(), "err", err)
+		default:
+			audioLog.Info("combo bound to device", "combo", c.Combo, "selector", sel.String(), "deviceID", deviceID)
 		}
 	}
 
-	// Initial synchronization at startup
-	sendSetEvents()
+	return deviceID, err
+}
 
-	// Periodic synchronization based on SetEventPeriod
-	configLock.RLock()
-	period := config.SetEventPeriod
-	configLock.RUnlock()
+// invalidateDevices marks all resolved bindings stale so the next lookup
+// re-resolves them against the current device set.
+func invalidateDevices(reason string) {
+	resolvedLock.Lock()
+	defer resolvedLock.Unlock()
 
-	ticker := time.NewTicker(period)
-	defer ticker.Stop()
+	audioLog.Debug("invalidating device bindings", "reason", reason)
+	for _, r := range resolved {
+		r.stale = true
+	}
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			sendSetEvents()
-		case <-shutdownChan:
-			slog.Info("set event sender shutting down")
-			return
-		}
+func lookupDevice(sel audio.Selector) (string, error) {
+	d, err := audio.Resolve(backend, sel)
+	if err != nil {
+		return "", err
 	}
+	return d.ID, nil
 }
 
-type DeviceMatcher struct{}
+// sessions returns the backend's control of application streams.
+func sessions() (audio.SessionBackend, error) {
+	sb, ok := backend.(audio.SessionBackend)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend cannot control apps", backend.Name())
+	}
+	return sb, nil
+}
 
-func (DeviceMatcher) Match(info reliableserial.DeviceInfo) (_ bool) {
-	return info.Name == config.PortName
+// targetVolume reads the volume of an endpoint, or of an app for IDs from
+// audio.AppID.
+func targetVolume(id string) (float32, error) {
+	if app, ok := audio.AppOf(id); ok {
+		sb, err := sessions()
+		if err != nil {
+			return 0, err
+		}
+		return audio.AppVolume(sb, app)
+	}
+	return backend.Volume(id)
 }
 
-func main() {
-	logger := slog.New(prettyslog.NewPrettyslogHandler("5ac",
-		prettyslog.WithLevel(slog.LevelDebug),
-		// prettyslog.WithWriter(file),
-	))
+// setTargetVolume sets the volume of an endpoint or app, see targetVolume.
+func setTargetVolume(id string, level float32) error {
+	if app, ok := audio.AppOf(id); ok {
+		sb, err := sessions()
+		if err != nil {
+			return err
+		}
+		return audio.SetAppVolume(sb, app, level)
+	}
+	return backend.SetVolume(id, level)
+}
 
-	slog.SetDefault(logger)
-	portName := flag.String("port", "", "Serial port name (e.g., COM3)")
-	flag.Parse()
+// targetMute reads the mute state of an endpoint or app, see targetVolume.
+func targetMute(id string) (bool, error) {
+	if app, ok := audio.AppOf(id); ok {
+		sb, err := sessions()
+		if err != nil {
+			return false, err
+		}
+		return audio.AppMute(sb, app)
+	}
+	return backend.Mute(id)
+}
 
-	loadConfig()
+// setTargetMute mutes or unmutes an endpoint or app, see targetVolume.
+func setTargetMute(id string, muted bool) error {
+	if app, ok := audio.AppOf(id); ok {
+		sb, err := sessions()
+		if err != nil {
+			return err
+		}
+		return audio.SetAppMute(sb, app, muted)
+	}
+	return backend.SetMute(id, muted)
+}
 
-	if *portName != "" {
-		configLock.Lock()
-		config.PortName = *portName
-		configLock.Unlock()
+func getCurrentVolume(deviceID string) (int, error) {
+	level, err := backend.Volume(deviceID)
+	if err != nil {
+		return 0, err
 	}
+	return int(math.Round(float64(level) * 100.0)), nil
+}
 
-	if config.PortName == "" {
-		log.Fatal("No serial port specified. Use the -port flag to specify the serial port.")
+// comboCurve builds a combo's volume curve for the device it is bound to.
+func comboCurve(c *ComboConfig, deviceID string) (curve.Curve, error) {
+	if !c.Decibel() {
+		return c.Build(0, 0), nil
+	}
+	db, ok := backend.(audio.DecibelBackend)
+	if !ok {
+		return curve.Curve{}, fmt.Errorf("%s backend does not support db curves", backend.Name())
+	}
+	minDB, maxDB, err := db.VolumeRange(deviceID)
+	if err != nil {
+		return curve.Curve{}, err
+	}
+	return c.Build(float64(minDB), float64(maxDB)), nil
+}
+
+// getComboLevel returns the knob position matching the current volumes of a
+// combo's targets, preferring the last published one where several match.
+// If they have no device, the error wraps control.ErrNoDevice.
+func getComboLevel(c *ComboConfig) (int, error) {
+	positions := make([]int, len(c.Gang()))
+	for _, i := range c.LevelTargets() {
+		deviceID, err := resolveTarget(c, i)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", control.ErrNoDevice, err)
+		}
+		if positions[i], err = getTargetLevel(c, deviceID); err != nil {
+			return 0, fmt.Errorf("%s: %w", deviceID, err)
+		}
 	}
+	return c.KnobNear(positions, lastLevel(c.Combo)), nil
+}
 
-	// initLogging(config.LogFile)
+// lastLevel returns the level last published for a combo, or -1.
+func lastLevel(combo uint8) int {
+	publishedLock.Lock()
+	defer publishedLock.Unlock()
+	if status, ok := published[combo]; ok {
+		return status.Level
+	}
+	return -1
+}
 
-	go configReloader(shutdownChan)
+// getTargetLevel returns the position on a combo's curve matching the
+// current volume of one of its devices.
+func getTargetLevel(c *ComboConfig, deviceID string) (int, error) {
+	cv, err := comboCurve(c, deviceID)
+	if err != nil {
+		return 0, err
+	}
 
-	rs := reliableserial.NewReliableSerial(
-		DeviceMatcher{},
-		reliableserial.SerialConfig{
-			BaudRate: config.BaudRate,
-		},
-		logger,
-		func() []byte {
-			return []byte{0xF0}
-		},
-		func() reliableserial.Serializable { return &protocol.Event{} },
-	)
-	defer rs.Close()
+	var level float32
+	start := time.Now()
+	if c.Decibel() {
+		level, err = backend.(audio.DecibelBackend).VolumeDB(deviceID)
+	} else {
+		level, err = targetVolume(deviceID)
+	}
+	stats.Backend(metrics.OpGet, c.Combo, deviceID, start, err)
+	if err != nil {
+		return 0, err
+	}
+	return cv.Position(float64(level)), nil
+}
 
-	go setEventSender(rs.SendChannel(), shutdownChan)
+// setComboLevel sets the volume of one of a combo's devices from a position
+// on its curve.
+func setComboLevel(c *ComboConfig, deviceID string, position int) error {
+	cv, err := comboCurve(c, deviceID)
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
+	level := float32(cv.Level(position))
+	start := time.Now()
+	if c.Decibel() {
+		err = backend.(audio.DecibelBackend).SetVolumeDB(deviceID, level)
+	} else {
+		err = setTargetVolume(deviceID, level)
+	}
+	stats.Backend(metrics.OpSet, c.Combo, deviceID, start, err)
+	return err
+}
 
-		err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED)
+// setComboLevels moves every target of a combo to match a knob position.
+// Targets without a device are skipped; if none has one, the error wraps
+// control.ErrNoDevice.
+func setComboLevels(c *ComboConfig, knob int) error {
+	var missing, errs []error
+	for i, position := range c.Positions(knob) {
+		deviceID, err := resolveTarget(c, i)
 		if err != nil {
-			log.Fatalf("Failed to initialize COM in setEventSender: %v", err)
+			missing = append(missing, err)
+			continue
 		}
-		defer ole.CoUninitialize()
-
-		for msg := range rs.ReceiveChannel() {
-			if m, ok := msg.(*protocol.Event); ok {
-				handleEvent(*m)
-			}
+		if err := setComboLevel(c, deviceID, position); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", deviceID, err))
 		}
-	}()
+	}
+	if len(missing) == len(c.Gang()) {
+		return fmt.Errorf("%w: %v", control.ErrNoDevice, errors.Join(missing...))
+	}
+	return errors.Join(errs...)
+}
 
-	// Wait for interrupt signal to gracefully shutdown
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+// setComboMute mutes or unmutes every target of a combo, like
+// setComboLevels.
+func setComboMute(c *ComboConfig, muted bool) error {
+	var missing, errs []error
+	for i := range c.Gang() {
+		deviceID, err := resolveTarget(c, i)
+		if err != nil {
+			missing = append(missing, err)
+			continue
+		}
+		if err := setTargetMute(deviceID, muted); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", deviceID, err))
+		}
+	}
+	if len(missing) == len(c.Gang()) {
+		return fmt.Errorf("%w: %v", control.ErrNoDevice, errors.Join(missing...))
+	}
+	return errors.Join(errs...)
+}
 
-	slog.Info("application is running. press Ctrl+C to exit")
-	<-sigs
-	slog.Info("interrupt signal received. initiating shutdown")
+// getComboMute reads the mute state of a combo's reference target.
+func getComboMute(c *ComboConfig) (bool, error) {
+	deviceID, err := resolveDevice(c)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", control.ErrNoDevice, err)
+	}
+	return targetMute(deviceID)
+}
 
-	// Signal all goroutines to stop
-	close(shutdownChan)
+// newBackend opens the configured audio backend, picking the platform's
+// native one when none is configured.
+func newBackend(name string) (audio.Backend, error) {
+	switch name {
+	case "", "auto":
+		if runtime.GOOS == "windows" {
+			return wasapi.New()
+		}
+		return pulse.New()
+	case "wasapi":
+		return wasapi.New()
+	case "pulse":
+		return pulse.New()
+	default:
+		return nil, fmt.Errorf("unknown audio backend %q", name)
+	}
+}
 
-	// Allow some time for goroutines to finish
+func handleEvent(event protocol.Event) {
+	if event.Type == protocol.EVENT_TYPE_ACK {
+		return
+	}
+	if version, ok := event.Version(); ok {
+		versions.Local(event.Combo, version, time.Now())
+	}
+
+	serialLog.Info("received event", "event", event.String())
+	stats.Event(event.Type.String(), event.Combo)
+	hub.Publish(control.Update{
+		Kind:  control.KindEvent,
+		Combo: event.Combo,
+		Level: int(event.State),
+		Event: event.Type.String(),
+	})
+
+	comboConfig := getComboConfig(event.Combo)
+	if comboConfig == nil {
+		slog.Warn("no configuration found for combo", "combo", event.Combo)
+		return
+	}
+
+	switch event.Type {
+	case protocol.EVENT_TYPE_CLICK:
+		if runAction(event.Combo, comboConfig.ClickAction()) {
+			return
+		}
+	case protocol.EVENT_TYPE_DOUBLE_CLICK:
+		if runAction(event.Combo, comboConfig.OnDoubleClick) {
+			return
+		}
+	}
+
+	if comboConfig.IsSwitcher() {
+		handleSwitcher(comboConfig, event)
+		return
+	}
+
+	if comboConfig.Turns.IsRelative() {
+		if detents, interval, ok := event.Turn(); ok {
+			applyTurn(comboConfig, event, int(detents), interval)
+			return
+		}
+	}
+
+	state := min(int(event.State), curve.Steps)
+
+	if comboConfig.IsOSC() {
+		slog.Info("set osc level", "state", state, "combo", event.Combo)
+		publishLevel(event.Combo, state)
+		return
+	}
+
+	err := setComboLevels(comboConfig, state)
+	switch {
+	case errors.Is(err, control.ErrNoDevice):
+		audioLog.Debug("combo has no device", "combo", event.Combo, "err", err)
+	case err != nil:
+		audioLog.Error("error setting volume", "combo", event.Combo, "err", err)
+	default:
+		audioLog.Info("set volume", "state", state, "combo", event.Combo)
+		publishLevel(event.Combo, state)
+	}
+}
+
+// applyTurn steps a relative combo's live level by a turn. The firmware only
+// guessed the new level, so the result is sent back to it.
+func applyTurn(c *ComboConfig, event protocol.Event, detents int, interval time.Duration) {
+	delta := accelerator.Delta(event.Combo, c.Turns, detents, interval)
+	if event.Type == protocol.EVENT_TYPE_CCW {
+		delta = -delta
+	}
+
+	if c.IsOSC() {
+		level := min(max(oscStatus(c).Level+delta, 0), curve.Steps)
+		slog.Info("set osc level", "state", level, "combo", event.Combo, "delta", delta)
+		publishLevel(event.Combo, level)
+		pushLevel(event.Combo, level)
+		return
+	}
+
+	level, err := getComboLevel(c)
+	if errors.Is(err, control.ErrNoDevice) {
+		audioLog.Debug("combo has no device", "combo", event.Combo, "err", err)
+		return
+	}
+	if err != nil {
+		audioLog.Error("error getting current volume", "combo", event.Combo, "err", err)
+		return
+	}
+	level = min(max(level+delta, 0), curve.Steps)
+	if err := setComboLevels(c, level); err != nil {
+		audioLog.Error("error setting volume", "combo", event.Combo, "err", err)
+		return
+	}
+	audioLog.Info("set volume", "state", level, "combo", event.Combo, "delta", delta)
+	publishLevel(event.Combo, level)
+	pushLevel(event.Combo, level)
+}
+
+// comboLabel returns the name shown on a combo's screen, or "" for the
+// firmware default. Switchers show their endpoint, and combos bound to an
+// app show it, or "offline" while it is not running. Focus combos always
+// show the app they follow once there is one.
+func comboLabel(c *ComboConfig) string {
+	switch {
+	case c.IsSwitcher():
+		label, _ := switcherView(c)
+		return label
+	case c.IsFocus() && c.App == "":
+	case c.HasApp():
+		if _, err := resolveDevice(c); errors.Is(err, audio.ErrNotRunning) {
+			return "offline"
+		}
+		if c.Name == "" || c.IsFocus() {
+			return c.Gang()[c.Reference].App
+		}
+	}
+	return c.Name
+}
+
+// bindFocus binds a focus combo to the app it follows, if one has played
+// yet.
+func bindFocus(c *ComboConfig) {
+	if !c.IsFocus() {
+		return
+	}
+	focusedLock.Lock()
+	defer focusedLock.Unlock()
+	if t, ok := focused[focusKey{combo: c.Combo, follow: c.Follow}]; ok {
+		c.App = t.Current()
+	}
+}
+
+// updateFocus shows the playing streams to the trackers of the active focus
+// combos and returns the combos that follow another app now, bound to it.
+func updateFocus(sb audio.SessionBackend) ([]ComboConfig, error) {
+	configLock.RLock()
+	combos := activeCombos
+	configLock.RUnlock()
+	if !slices.ContainsFunc(combos, func(c ComboConfig) bool { return c.IsFocus() }) {
+		return nil, nil
+	}
+
+	streams, err := sb.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	focusedLock.Lock()
+	defer focusedLock.Unlock()
+	var moved []ComboConfig
+	for _, c := range combos {
+		if !c.IsFocus() {
+			continue
+		}
+		key := focusKey{combo: c.Combo, follow: c.Follow}
+		t, ok := focused[key]
+		if !ok {
+			t = focus.NewTracker(c.Follow)
+			focused[key] = t
+		}
+		if app, changed := t.Update(streams, now); changed {
+			c.App = app
+			moved = append(moved, c)
+		}
+	}
+	return moved, nil
+}
+
+// focusWatcher rebinds focus combos as apps start and stop playing and shows
+// the new app and its level on their screens.
+func focusWatcher(shutdownChan <-chan struct{}) {
+	sb, err := sessions()
+	if err != nil {
+		if slices.ContainsFunc(liveCombos(), func(c ComboConfig) bool { return c.IsFocus() }) {
+			audioLog.Warn("focus combos cannot follow apps", "err", err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(focusPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			moved, err := updateFocus(sb)
+			if err != nil {
+				audioLog.Debug("error listing sessions", "err", err)
+				continue
+			}
+			for _, c := range moved {
+				showFocus(&c)
+			}
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// showFocus pushes the app a focus combo follows now, with its level and
+// mute state, to the device and the control interfaces.
+func showFocus(c *ComboConfig) {
+	audioLog.Info("focus combo follows app", "combo", c.Combo, "app", c.App)
+	pushLabel(c.Combo, comboLabel(c))
+
+	level, err := getComboLevel(c)
+	if err != nil {
+		audioLog.Debug("error getting focused app level", "combo", c.Combo, "err", err)
+		return
+	}
+	publishLevel(c.Combo, level)
+	pushLevel(c.Combo, level)
+
+	if muted, err := getComboMute(c); err == nil {
+		publishMute(c.Combo, muted)
+		pushMute(c.Combo, muted)
+	}
+}
+
+// comboPeak returns the current peak of what a combo's level is read from:
+// the meter of its endpoint or the loudest stream of its app.
+func comboPeak(c *ComboConfig) (float32, error) {
+	id, err := resolveDevice(c)
+	if err != nil {
+		return 0, err
+	}
+	if app, ok := audio.AppOf(id); ok {
+		sb, err := sessions()
+		if err != nil {
+			return 0, err
+		}
+		return audio.AppPeak(sb, app)
+	}
+	mb, ok := backend.(audio.MeterBackend)
+	if !ok {
+		return 0, fmt.Errorf("the %s backend cannot meter devices", backend.Name())
+	}
+	return mb.Peak(id)
+}
+
+// meterLevels samples the meters of the live combos, indexed by combo.
+// Combos without a meter, such as switchers, read 0.
+func meterLevels() []uint8 {
+	var levels []uint8
+	for _, c := range liveCombos() {
+		if c.IsOSC() || c.IsSwitcher() {
+			continue
+		}
+		peak, err := comboPeak(&c)
+		if err != nil {
+			continue
+		}
+		for len(levels) <= int(c.Combo) {
+			levels = append(levels, 0)
+		}
+		levels[c.Combo] = meter.Level(peak)
+	}
+	return levels
+}
+
+// meterStreamer samples the combos' meters every meterPeriod while the
+// device is connected and sends them whenever they change.
+func meterStreamer(writeChan chan<- reliableserial.Serializable, link func() reliableserial.Stats, shutdownChan <-chan struct{}) {
+	changed := configChanged()
+
+	// last holds the levels last sent on the connection counted by
+	// connects, so unchanged ones are not sent again. A device that
+	// reconnects has cleared its meters and gets everything anew.
+	var last []uint8
+	var connects uint64
+	send := func(levels []uint8) {
+		// Combos no longer metered drop to zero.
+		for len(levels) < len(last) {
+			levels = append(levels, 0)
+		}
+		if slices.Equal(levels, last) {
+			return
+		}
+		// Meters are dropped rather than queued, the next sample is due
+		// soon.
+		select {
+		case writeChan <- protocol.NewMeter(levels):
+			last = levels
+		default:
+		}
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	configure := func() {
+		configLock.RLock()
+		period := config.MeterPeriod
+		configLock.RUnlock()
+		if period > 0 {
+			ticker.Reset(period)
+			return
+		}
+		ticker.Stop()
+		send(nil)
+	}
+	configure()
+
+	for {
+		select {
+		case <-ticker.C:
+			s := link()
+			if !s.Connected {
+				continue
+			}
+			if s.Connects != connects {
+				connects, last = s.Connects, nil
+			}
+			send(meterLevels())
+		case <-changed:
+			configure()
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// setDefaultDevice makes an endpoint the system default for role.
+func setDefaultDevice(deviceID string, role audio.Role) error {
+	setter, ok := backend.(audio.DefaultSetter)
+	if !ok {
+		return fmt.Errorf("the %s backend cannot change default devices", backend.Name())
+	}
+	return setter.SetDefaultDevice(deviceID, role)
+}
+
+// handleSwitcher turns a switcher through its targets and makes the shown
+// one the default on click. Each turn event moves by one target, however
+// far the knob went.
+func handleSwitcher(c *ComboConfig, event protocol.Event) {
+	switch event.Type {
+	case protocol.EVENT_TYPE_CW, protocol.EVENT_TYPE_CCW:
+		step := 1
+		if event.Type == protocol.EVENT_TYPE_CCW {
+			step = len(c.Targets) - 1
+		}
+		i := (switcherPosition(c) + step) % len(c.Targets)
+		switchersLock.Lock()
+		switchers[c.Combo] = i
+		switchersLock.Unlock()
+	case protocol.EVENT_TYPE_CLICK:
+		d, err := switcherTarget(c, switcherPosition(c))
+		if err != nil {
+			audioLog.Warn("switcher target has no device", "combo", c.Combo, "err", err)
+			break
+		}
+		if err := setDefaultDevice(d.ID, c.DefaultRole()); err != nil {
+			audioLog.Error("error setting default device", "combo", c.Combo, "err", err)
+			break
+		}
+		audioLog.Info("set default device", "combo", c.Combo, "device", d.Name)
+	default:
+		return
+	}
+
+	label, current := switcherView(c)
+	pushLabel(c.Combo, label)
+	pushLevel(c.Combo, int(boolState(current)))
+}
+
+// switcherTarget returns the endpoint of one of a switcher's targets.
+func switcherTarget(c *ComboConfig, target int) (audio.Device, error) {
+	deviceID, err := resolveTarget(c, target)
+	if err != nil {
+		return audio.Device{}, err
+	}
+	devices, err := backend.Devices()
+	if err != nil {
+		return audio.Device{}, err
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return d, nil
+		}
+	}
+	return audio.Device{}, fmt.Errorf("%s: %w", deviceID, audio.ErrNoMatch)
+}
+
+// switcherPosition returns the target a switcher shows. Switchers start out
+// on the current default.
+func switcherPosition(c *ComboConfig) int {
+	switchersLock.Lock()
+	i, ok := switchers[c.Combo]
+	switchersLock.Unlock()
+	if ok && i < len(c.Targets) {
+		return i
+	}
+
+	i = 0
+	for target := range c.Targets {
+		if d, err := switcherTarget(c, target); err == nil && isDefault(d, c.DefaultRole()) {
+			i = target
+			break
+		}
+	}
+	switchersLock.Lock()
+	switchers[c.Combo] = i
+	switchersLock.Unlock()
+	return i
+}
+
+// switcherView returns the name of the endpoint a switcher shows and
+// whether it is the default already.
+func switcherView(c *ComboConfig) (label string, current bool) {
+	d, err := switcherTarget(c, switcherPosition(c))
+	if err != nil {
+		return "missing", false
+	}
+	return d.Name, isDefault(d, c.DefaultRole())
+}
+
+// isDefault reports whether d is the default endpoint of its flow for role.
+func isDefault(d audio.Device, role audio.Role) bool {
+	def, err := backend.DefaultDevice(d.Flow, role)
+	return err == nil && def.ID == d.ID
+}
+
+// publishLevel announces a combo's level on the hub if it changed since the
+// last announcement.
+func publishLevel(combo uint8, level int) {
+	publishedLock.Lock()
+	status, ok := published[combo]
+	changed := !ok || status.Level != level
+	status.Level = level
+	published[combo] = status
+	publishedLock.Unlock()
+
+	if changed {
+		hub.Publish(control.Update{Kind: control.KindVolume, Combo: combo, Level: level, Muted: status.Muted})
+	}
+}
+
+// publishMute announces a combo's mute state on the hub if it changed since
+// the last announcement.
+func publishMute(combo uint8, muted bool) {
+	publishedLock.Lock()
+	status, ok := published[combo]
+	changed := !ok || status.Muted != muted
+	status.Muted = muted
+	published[combo] = status
+	publishedLock.Unlock()
+
+	if changed {
+		hub.Publish(control.Update{Kind: control.KindMute, Combo: combo, Level: status.Level, Muted: muted})
+	}
+}
+
+// notifyDevice shows a notification on all device screens while the daemon
+// runs.
+func notifyDevice(code uint8) {
+	if deviceChan == nil {
+		return
+	}
+	select {
+	case deviceChan <- protocol.NewEvent(protocol.EVENT_TYPE_NOTIFY, protocol.ALL_COMBOS, code):
+	default:
+		serialLog.Warn("send queue full, dropping notification", "code", code)
+	}
+}
+
+// pushLevel sends a combo's level to the device screen while the daemon runs.
+func pushLevel(combo uint8, level int) {
+	if deviceChan == nil {
+		return
+	}
+	// The host changed the level itself, so it wins over every turn it has
+	// seen so far.
+	version, versioned := versions.Version(combo)
+	select {
+	case deviceChan <- newSetEvent(combo, level, version, versioned):
+	default:
+		serialLog.Warn("send queue full, dropping set event", "combo", combo)
+	}
+}
+
+// pushMute shows a combo's mute state on the device screen while the daemon
+// runs.
+func pushMute(combo uint8, muted bool) {
+	if deviceChan == nil {
+		return
+	}
+	select {
+	case deviceChan <- newMuteEvent(combo, muted):
+	default:
+		serialLog.Warn("send queue full, dropping mute event", "combo", combo)
+	}
+}
+
+func newMuteEvent(combo uint8, muted bool) *protocol.Event {
+	return protocol.NewEvent(protocol.EVENT_TYPE_MUTE, combo, boolState(muted))
+}
+
+// boolState encodes a boolean as an event state.
+func boolState(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pushLabel shows a label on a combo's screen while the daemon runs.
+func pushLabel(combo uint8, label string) {
+	if deviceChan == nil {
+		return
+	}
+	select {
+	case deviceChan <- protocol.NewLabel(combo, label):
+	default:
+		serialLog.Warn("send queue full, dropping label", "combo", combo)
+	}
+}
+
+// newSetEvent creates a SET, stamped with the knob version the level was
+// read at if there is one.
+func newSetEvent(combo uint8, level int, version uint8, versioned bool) *protocol.Event {
+	event := protocol.NewEvent(protocol.EVENT_TYPE_SET, combo, uint8(level))
+	if versioned {
+		event.Data = []byte{version}
+	}
+	return event
+}
+
+// hostController implements control.Controller on top of the configured
+// combos and the audio backend. It is served to clients by the daemon and
+// used directly by commands when no daemon is running.
+type hostController struct{}
+
+// oscStatus returns the state of a combo bound to OSC. It only lives in the
+// daemon, as the last level and mute state published for the combo.
+func oscStatus(c *ComboConfig) control.ComboStatus {
+	publishedLock.Lock()
+	defer publishedLock.Unlock()
+	status := published[c.Combo]
+	return control.ComboStatus{Combo: c.Combo, Name: c.Name, Level: status.Level, Muted: status.Muted}
+}
+
+// oscRoutes returns the OSC addresses of the active combos.
+func oscRoutes() []osc.Route {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	routes := make([]osc.Route, 0, len(activeCombos))
+	for _, c := range activeCombos {
+		level, mute := config.OSCAddresses(c)
+		routes = append(routes, osc.Route{Combo: c.Combo, Level: level, Mute: mute})
+	}
+	return routes
+}
+
+func comboStatus(c *ComboConfig) (control.ComboStatus, error) {
+	if c.IsOSC() {
+		return oscStatus(c), nil
+	}
+	status := control.ComboStatus{Combo: c.Combo, Name: c.Name}
+	if c.IsSwitcher() {
+		// Switchers have no level of their own; report the endpoint shown.
+		d, err := switcherTarget(c, switcherPosition(c))
+		if err != nil {
+			return status, fmt.Errorf("%w: %v", control.ErrNoDevice, err)
+		}
+		status.DeviceID = d.ID
+		return status, nil
+	}
+
+	deviceID, err := resolveDevice(c)
+	if err != nil {
+		return status, fmt.Errorf("%w: %v", control.ErrNoDevice, err)
+	}
+	status.DeviceID = deviceID
+
+	if status.Level, err = getComboLevel(c); err != nil {
+		return status, err
+	}
+	if status.Muted, err = targetMute(deviceID); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+func lookupCombo(combo uint8) (*ComboConfig, error) {
+	c := getComboConfig(combo)
+	if c == nil {
+		return nil, fmt.Errorf("%w %d", control.ErrUnknownCombo, combo)
+	}
+	return c, nil
+}
+
+func (hostController) Combos() ([]control.ComboStatus, error) {
+	combos := liveCombos()
+
+	statuses := make([]control.ComboStatus, 0, len(combos))
+	for _, c := range combos {
+		status, err := comboStatus(&c)
+		if err != nil {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (hostController) Get(combo uint8) (control.ComboStatus, error) {
+	c, err := lookupCombo(combo)
+	if err != nil {
+		return control.ComboStatus{}, err
+	}
+	return comboStatus(c)
+}
+
+func (hostController) SetLevel(combo uint8, level int) (control.ComboStatus, error) {
+	if level < 0 || level > curve.Steps {
+		return control.ComboStatus{}, fmt.Errorf("level %d out of range 0-%d", level, curve.Steps)
+	}
+	c, err := lookupCombo(combo)
+	if err != nil {
+		return control.ComboStatus{}, err
+	}
+	if c.IsSwitcher() {
+		return control.ComboStatus{}, fmt.Errorf("%w: combo %d is a switcher", control.ErrNoDevice, combo)
+	}
+	if c.IsOSC() {
+		pushLevel(combo, level)
+		publishLevel(combo, level)
+		return oscStatus(c), nil
+	}
+	if err := setComboLevels(c, level); err != nil {
+		return control.ComboStatus{}, err
+	}
+	audioLog.Info("set volume", "state", level, "combo", combo, "source", "control")
+	pushLevel(combo, level)
+	publishLevel(combo, level)
+
+	return comboStatus(c)
+}
+
+func (hostController) SetMute(combo uint8, muted bool) (control.ComboStatus, error) {
+	c, err := lookupCombo(combo)
+	if err != nil {
+		return control.ComboStatus{}, err
+	}
+	if c.IsSwitcher() {
+		return control.ComboStatus{}, fmt.Errorf("%w: combo %d is a switcher", control.ErrNoDevice, combo)
+	}
+	if c.IsOSC() {
+		pushMute(combo, muted)
+		publishMute(combo, muted)
+		return oscStatus(c), nil
+	}
+	if err := setComboMute(c, muted); err != nil {
+		return control.ComboStatus{}, err
+	}
+	audioLog.Info("set mute", "muted", muted, "combo", combo, "source", "control")
+	pushMute(combo, muted)
+	publishMute(combo, muted)
+
+	return comboStatus(c)
+}
+
+func (h hostController) ToggleMute(combo uint8) (control.ComboStatus, error) {
+	status, err := h.Get(combo)
+	if err != nil {
+		return status, err
+	}
+	return h.SetMute(combo, !status.Muted)
+}
+
+func (hostController) Profiles() ([]control.ProfileStatus, error) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	profiles := make([]control.ProfileStatus, 0, len(config.Profiles))
+	for _, p := range config.Profiles {
+		profiles = append(profiles, control.ProfileStatus{Name: p.Name, Active: p.Name == activeProfile})
+	}
+	return profiles, nil
+}
+
+func logLevels() []control.LogLevel {
+	current := logs.Levels()
+	levels := make([]control.LogLevel, 0, len(logging.Subsystems))
+	for _, subsystem := range logging.Subsystems {
+		levels = append(levels, control.LogLevel{Subsystem: subsystem, Level: logging.LevelName(current[subsystem])})
+	}
+	return levels
+}
+
+func (hostController) LogLevels() ([]control.LogLevel, error) {
+	return logLevels(), nil
+}
+
+// SetLogLevel changes the level of a subsystem until the daemon restarts.
+func (hostController) SetLogLevel(subsystem, level string) ([]control.LogLevel, error) {
+	l, err := logging.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", control.ErrInvalidLogLevel, level)
+	}
+	if !logs.SetLevel(subsystem, l) {
+		return nil, fmt.Errorf("%w %q", control.ErrUnknownSubsystem, subsystem)
+	}
+	slog.Info("changed log level", "target", subsystem, "newLevel", logging.LevelName(l))
+	return logLevels(), nil
+}
+
+func (h hostController) SwitchProfile(name string) ([]control.ProfileStatus, error) {
+	if err := switchProfile(name); err != nil {
+		return nil, err
+	}
+	return h.Profiles()
+}
+
+// configWatcher reloads the config file when it changes on disk, and
+// every ConfigReloadPeriod as a fallback for file systems without change
+// notifications.
+func configWatcher(shutdownChan <-chan struct{}) {
+	changed := configChanged()
+
+	path, err := filepath.Abs(configFile)
+	if err != nil {
+		path = configFile
+	}
+
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+	)
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		// Watch the directory since editors often replace the file instead
+		// of writing to it.
+		err = watcher.Add(filepath.Dir(path))
+	}
+	if err != nil {
+		configLog.Warn("config file watching unavailable, relying on periodic reload", "err", err)
+	} else {
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	configLock.RLock()
+	period := config.ConfigReloadPeriod
+	configLock.RUnlock()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	progress := time.NewTicker(service.ReportPeriod)
+	defer progress.Stop()
+	mainProgress.Report()
+
+	// Saving a file often shows up as several events, so reloads wait for
+	// them to settle.
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-progress.C:
+			mainProgress.Report()
+		case ev := <-events:
+			if ev.Name == path && !ev.Has(fsnotify.Chmod) {
+				debounce = time.After(250 * time.Millisecond)
+			}
+		case err := <-errs:
+			configLog.Warn("config file watcher error", "err", err)
+		case <-debounce:
+			debounce = nil
+			reloadConfig("file changed")
+		case <-ticker.C:
+			reloadConfig("periodic")
+		case <-changed:
+			configLock.RLock()
+			period = config.ConfigReloadPeriod
+			configLock.RUnlock()
+			ticker.Reset(period)
+		case <-shutdownChan:
+			configLog.Info("configuration watcher shutting down")
+			return
+		}
+	}
+}
+
+func setEventSender(writeChan chan<- reliableserial.Serializable, connected <-chan reliableserial.DeviceInfo, shutdownChan <-chan struct{}) {
+	changed := configChanged()
+
+	// labels holds the names last sent to the device; combos missing from
+	// it show their firmware default.
+	labels := make(map[uint8]string)
+
+	// sendLabels sends the labels that changed, or with all those of every
+	// combo, as the device may have restored others from flash.
+	sendLabels := func(all bool) {
+		combos := liveCombos()
+
+		want := make(map[uint8]string)
+		for _, combo := range combos {
+			if label := comboLabel(&combo); label != "" {
+				want[combo.Combo] = label
+			}
+		}
+		for combo := range labels {
+			if _, ok := want[combo]; !ok {
+				want[combo] = ""
+			}
+		}
+		if all {
+			for combo := range uint8(protocol.COMBOS) {
+				if _, ok := want[combo]; !ok {
+					want[combo] = ""
+				}
+			}
+		}
+
+		for combo, name := range want {
+			if !all && labels[combo] == name {
+				continue
+			}
+			event := protocol.NewLabel(combo, name)
+			select {
+			case writeChan <- event:
+			case <-shutdownChan:
+				return
+			}
+			if name == "" {
+				delete(labels, combo)
+			} else {
+				labels[combo] = name
+			}
+		}
+	}
+
+	// styles holds the styles last sent to the device; combos missing from
+	// it are drawn as bars.
+	styles := make(map[uint8]uint8)
+
+	// sendStyles sends the styles that changed, or with all those of every
+	// combo, like sendLabels.
+	sendStyles := func(all bool) {
+		configLock.RLock()
+		combos := activeCombos
+		configLock.RUnlock()
+
+		want := make(map[uint8]uint8)
+		for _, combo := range combos {
+			switch {
+			case combo.IsCrossfade():
+				want[combo.Combo] = protocol.STYLE_BALANCE
+			case combo.IsSwitcher():
+				want[combo.Combo] = protocol.STYLE_SWITCHER
+			}
+		}
+		for combo := range styles {
+			if _, ok := want[combo]; !ok {
+				want[combo] = protocol.STYLE_BAR
+			}
+		}
+		if all {
+			for combo := range uint8(protocol.COMBOS) {
+				if _, ok := want[combo]; !ok {
+					want[combo] = protocol.STYLE_BAR
+				}
+			}
+		}
+
+		for combo, style := range want {
+			if !all && styles[combo] == style {
+				continue
+			}
+			event := protocol.NewEvent(protocol.EVENT_TYPE_STYLE, combo, style)
+			select {
+			case writeChan <- event:
+			case <-shutdownChan:
+				return
+			}
+			if style == protocol.STYLE_BAR {
+				delete(styles, combo)
+			} else {
+				styles[combo] = style
+			}
+		}
+	}
+
+	sendSetEvents := func() {
+		serialLog.Info("sending set events to synchronize device state")
+		combos := liveCombos()
+
+		for _, combo := range combos {
+			// Leave combos alone that are being turned, and take the version
+			// before reading the level: a turn handled in between then makes
+			// the SET stale instead of overwriting the turn on the device.
+			if versions.Held(combo.Combo, time.Now()) {
+				continue
+			}
+			version, versioned := versions.Version(combo.Combo)
+
+			var currentVolume int
+			var muted bool
+			if combo.IsOSC() {
+				status := oscStatus(&combo)
+				currentVolume, muted = status.Level, status.Muted
+			} else if combo.IsSwitcher() {
+				// Switchers show whether their endpoint is the default.
+				_, current := switcherView(&combo)
+				currentVolume = int(boolState(current))
+			} else {
+				// Map the current volume back onto the knob so the position
+				// the firmware sent is the one it gets back
+				var err error
+				currentVolume, err = getComboLevel(&combo)
+				if errors.Is(err, control.ErrNoDevice) {
+					audioLog.Debug("combo has no device", "combo", combo.Combo, "err", err)
+					continue
+				}
+				if err != nil {
+					audioLog.Error("error getting current volume", "combo", combo.Combo, "err", err)
+					continue
+				}
+
+				publishLevel(combo.Combo, currentVolume)
+
+				muted, err = getComboMute(&combo)
+				if err != nil {
+					audioLog.Error("error getting mute state", "combo", combo.Combo, "err", err)
+					continue
+				}
+				publishMute(combo.Combo, muted)
+			}
+
+			// Create a set event, followed by the mute state so the screen
+			// also catches mutes made elsewhere
+			events := []*protocol.Event{
+				newSetEvent(combo.Combo, currentVolume, version, versioned),
+				newMuteEvent(combo.Combo, muted),
+			}
+
+			// Send the packets to writeChan
+			for _, event := range events {
+				select {
+				case writeChan <- event:
+				case <-shutdownChan:
+					serialLog.Info("set event sender received shutdown signal")
+					return
+				}
+			}
+		}
+	}
+
+	// sendDefaults resets the combos without config, which the device may
+	// have restored from flash, to level 0 and unmuted.
+	sendDefaults := func() {
+		configured := make(map[uint8]bool)
+		for _, combo := range liveCombos() {
+			configured[combo.Combo] = true
+		}
+		for combo := range uint8(protocol.COMBOS) {
+			if configured[combo] {
+				continue
+			}
+			version, versioned := versions.Version(combo)
+			for _, event := range []*protocol.Event{
+				newSetEvent(combo, 0, version, versioned),
+				newMuteEvent(combo, false),
+			} {
+				select {
+				case writeChan <- event:
+				case <-shutdownChan:
+					return
+				}
+			}
+		}
+	}
+
+	// Initial synchronization at startup
+	sendLabels(true)
+	sendStyles(true)
+	sendDefaults()
+	sendSetEvents()
+
+	// Periodic synchronization based on SetEventPeriod
+	configLock.RLock()
+	period := config.SetEventPeriod
+	configLock.RUnlock()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Apps coming and going change labels too.
+			sendLabels(false)
+			sendSetEvents()
+		case <-changed:
+			// Bindings may have changed, so sync right away.
+			configLock.RLock()
+			period = config.SetEventPeriod
+			configLock.RUnlock()
+			ticker.Reset(period)
+			sendLabels(false)
+			sendStyles(false)
+			sendSetEvents()
+		case info := <-connected:
+			// A device that was reset or replugged shows what it restored
+			// from flash, which may be from another config, so send the
+			// state of every combo.
+			serialLog.Info("device connected, restoring screens", "device", info.Name)
+			sendLabels(true)
+			sendStyles(true)
+			sendDefaults()
+			sendSetEvents()
+		case <-shutdownChan:
+			serialLog.Info("set event sender shutting down")
+			return
+		}
+	}
+}
+
+type DeviceMatcher struct{}
+
+func (DeviceMatcher) Match(info reliableserial.DeviceInfo) (_ bool) {
+	return info.Name == config.PortName
+}
+
+type command struct {
+	name string
+	help string
+	run  func(args []string) error
+}
+
+var commands = []command{
+	{"run", "run the daemon (default)", runCommand},
+	{"devices", "list audio endpoints with IDs, state, volume and config snippets", devicesCommand},
+	{"get", "print the level and mute state of one or all combos", getCommand},
+	{"set", "set a combo's level (0-100)", setCommand},
+	{"mute", "toggle a combo's mute state, or set it with on/off", muteCommand},
+	{"monitor", "stream live events and level changes", monitorCommand},
+	{"profile", "list profiles, or switch to the named one", profileCommand},
+	{"log", "list the daemon's log levels, or set a subsystem's level", logCommand},
+	{"service", "run the daemon under systemd, or install/uninstall a user unit", serviceCommand},
+}
+
+// needsConfig reports whether a command needs the config: the daemon and
+// everything working on combos or talking to the daemon do. The rest run with
+// the defaults, so they also work while the config is broken.
+func (c *command) needsConfig(args []string) bool {
+	switch c.name {
+	case "devices":
+		return false
+	case "service":
+		return len(args) == 0
+	}
+	return true
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [args]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+var portName = flag.String("port", "", "Serial port name (e.g., COM3)")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	name := flag.Arg(0)
+	if name == "" {
+		name = "run"
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var args []string
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
+	if cmd.needsConfig(args) {
+		newConfig, unknown, err := readConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration in %s: %v\n", configFile, err)
+			os.Exit(1)
+		}
+		if err := initLogging(cmd.name, newConfig.Log); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up logging: %v\n", err)
+			os.Exit(1)
+		}
+		warnUnknownKeys(unknown)
+		applyConfig(newConfig)
+		restoreProfile()
+	} else if err := initLogging(cmd.name, logging.Options{}); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up logging: %v\n", err)
+		os.Exit(1)
+	}
+
+	var err error
+	backend, err = newBackend(config.Backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open audio backend: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = cmd.run(args)
+	backend.Close()
+	logs.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+// initLogging sets up the loggers. Commands other than the daemon print
+// their results on stdout, so they only log problems to stderr.
+func initLogging(command string, opts logging.Options) error {
+	if command != "run" && command != "service" {
+		opts = logging.Options{Level: "warn"}
+	}
+	l, err := logging.New(opts, slog.LevelInfo)
+	if err != nil {
+		return err
+	}
+
+	logs = l
+	slog.SetDefault(l.Logger(logging.Main))
+	serialLog = l.Logger(logging.Serial)
+	audioLog = l.Logger(logging.Audio)
+	configLog = l.Logger(logging.Config)
+	return nil
+}
+
+func runCommand(args []string) error {
+	lock, err := lockInstance()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return runDaemon(nil)
+}
+
+// lockInstance makes sure only one daemon talks to the device.
+func lockInstance() (*service.Lock, error) {
+	path := config.LockFile
+	if path == "" {
+		path = service.DefaultLockPath()
+	}
+	lock, err := service.Acquire(path)
+	if errors.Is(err, service.ErrAlreadyRunning) {
+		return nil, fmt.Errorf("%w (lock %s)", err, path)
+	}
+	return lock, err
+}
+
+// runDaemon runs until interrupted. notify, if set, is told when the daemon
+// is ready and when it starts shutting down.
+func runDaemon(notify func(state string)) error {
+	if notify == nil {
+		notify = func(string) {}
+	}
+	if config.PortName == "" {
+		return errors.New("no serial port specified, use the -port flag to specify the serial port")
+	}
+
+	audioLog.Info("using audio backend", "backend", backend.Name())
+
+	warnMissingDevices()
+	warnUnmeteredApps()
+
+	if err := backend.Watch(invalidateDevices); err != nil {
+		audioLog.Warn("device change notifications unavailable", "err", err)
+	}
+
+	go configWatcher(shutdownChan)
+
+	rs := reliableserial.NewReliableSerial(
+		DeviceMatcher{},
+		reliableserial.SerialConfig{
+			BaudRate: config.BaudRate,
+		},
+		serialLog,
+		func() []byte {
+			return []byte{0xF0}
+		},
+		func() reliableserial.Serializable { return &protocol.Event{} },
+	)
+	defer rs.Close()
+	rs.SetProgress(serialProgress.Report)
+	// Pings tell the device the host is alive, and drop the link when the
+	// firmware hangs with the port still open.
+	rs.SetHeartbeat(reliableserial.Heartbeat{
+		Interval: protocol.HEARTBEAT_PERIOD,
+		Misses:   protocol.HEARTBEAT_MISSES,
+		Ping: func(stamp uint32) reliableserial.Serializable {
+			return protocol.NewPing(stamp)
+		},
+		Pong: func(msg reliableserial.Serializable) (uint32, bool) {
+			e, ok := msg.(*protocol.Event)
+			if !ok || e.Type != protocol.EVENT_TYPE_PONG {
+				return 0, false
+			}
+			return e.Stamp()
+		},
+	})
+
+	deviceChan = rs.SendChannel()
+	stats.Link(func() reliableserial.Stats {
+		s := rs.Stats()
+		if s.Device == "" {
+			configLock.RLock()
+			s.Device = config.PortName
+			configLock.RUnlock()
+		}
+		return s
+	})
+
+	server, err := ipc.Listen(socketPath(), hostController{}, hub, slog.Default())
+	if err != nil {
+		slog.Warn("control socket unavailable", "err", err)
+	} else {
+		slog.Info("control socket listening", "path", server.Addr())
+		defer server.Close()
+	}
+
+	if config.HTTP.Enabled {
+		api, err := httpapi.Listen(config.HTTP.Listen, httpapi.Options{
+			Controller: hostController{},
+			Hub:        hub,
+			Devices:    backend.Devices,
+			Link: func() control.LinkStatus {
+				configLock.RLock()
+				defer configLock.RUnlock()
+				return control.LinkStatus{Connected: rs.IsRunning(), Port: config.PortName}
+			},
+			Token:  config.HTTP.Token,
+			Logger: slog.Default(),
+		})
+		if err != nil {
+			slog.Warn("http api unavailable", "err", err)
+		} else {
+			slog.Info("http api listening", "addr", api.Addr())
+			defer api.Close()
+		}
+	}
+
+	if config.Metrics.Enabled {
+		server, err := stats.Listen(config.Metrics.Listen, slog.Default())
+		if err != nil {
+			slog.Warn("metrics unavailable", "err", err)
+		} else {
+			slog.Info("metrics listening", "addr", server.Addr())
+			defer server.Close()
+		}
+	}
+
+	if config.MQTT.Broker != "" {
+		bridge := mqttbridge.New(mqttbridge.Options{
+			Broker:          config.MQTT.Broker,
+			Username:        config.MQTT.Username,
+			Password:        config.MQTT.Password,
+			ClientID:        config.MQTT.ClientID,
+			TopicPrefix:     config.MQTT.TopicPrefix,
+			DiscoveryPrefix: config.MQTT.DiscoveryPrefix,
+			NodeID:          config.MQTT.NodeID,
+		}, hostController{}, slog.Default())
+		if err := bridge.Start(hub); err != nil {
+			slog.Warn("mqtt bridge unavailable", "err", err)
+		} else {
+			defer bridge.Close()
+		}
+	}
+
+	if config.OSC.Enabled() {
+		bridge := osc.New(osc.Options{
+			Listen:     config.OSC.Listen,
+			Send:       config.OSC.Send,
+			Routes:     oscRoutes,
+			Controller: hostController{},
+			Logger:     slog.Default(),
+		})
+		if err := bridge.Start(hub); err != nil {
+			slog.Warn("osc unavailable", "err", err)
+		} else {
+			slog.Info("osc bridge running", "addr", bridge.Addr().String(), "send", config.OSC.Send)
+			defer bridge.Close()
+		}
+	}
+
+	go setEventSender(rs.SendChannel(), rs.ConnectChannel(), shutdownChan)
+	go focusWatcher(shutdownChan)
+	go meterStreamer(rs.SendChannel(), rs.Stats, shutdownChan)
+
+	go func() {
+		for msg := range rs.ReceiveChannel() {
+			if m, ok := msg.(*protocol.Event); ok {
+				handleEvent(*m)
+			}
+		}
+	}()
+
+	// Wait for interrupt signal to gracefully shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	notify(service.Ready)
+	slog.Info("application is running. press Ctrl+C to exit")
+	<-sigs
+	slog.Info("interrupt signal received. initiating shutdown")
+	notify(service.Stopping)
+
+	// Signal all goroutines to stop
+	close(shutdownChan)
+
+	// Allow some time for goroutines to finish
 	time.Sleep(1 * time.Second)
 	slog.Info("application terminated gracefully")
+	return nil
+}
+
+type deviceListing struct {
+	audio.Device
+	Default bool  `json:"default"`
+	Volume  *int  `json:"volume,omitempty"`
+	Muted   *bool `json:"muted,omitempty"`
+}
+
+func devicesCommand(args []string) error {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print devices as JSON")
+	all := fs.Bool("all", false, "Include disabled, unplugged and missing endpoints")
+	fs.Parse(args)
+
+	devices, err := backend.Devices()
+	if err != nil {
+		return err
+	}
+
+	defaults := make(map[string]bool)
+	for _, flow := range []audio.Flow{audio.FlowRender, audio.FlowCapture} {
+		if d, err := backend.DefaultDevice(flow, audio.RoleConsole); err == nil {
+			defaults[d.ID] = true
+		}
+	}
+
+	listings := make([]deviceListing, 0, len(devices))
+	for _, d := range devices {
+		if !*all && d.State != audio.StateActive {
+			continue
+		}
+		l := deviceListing{Device: d, Default: defaults[d.ID]}
+		if d.State == audio.StateActive {
+			if vol, err := getCurrentVolume(d.ID); err == nil {
+				l.Volume = &vol
+			}
+			if muted, err := backend.Mute(d.ID); err == nil {
+				l.Muted = &muted
+			}
+		}
+		listings = append(listings, l)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listings)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FLOW\tSTATE\tDEFAULT\tVOLUME\tMUTED\tNAME\tID")
+	for _, l := range listings {
+		volume, muted := "-", "-"
+		if l.Volume != nil {
+			volume = fmt.Sprintf("%d", *l.Volume)
+		}
+		if l.Muted != nil {
+			muted = fmt.Sprintf("%t", *l.Muted)
+		}
+		def := ""
+		if l.Default {
+			def = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", l.Flow, l.State, def, volume, muted, l.Name, l.ID)
+	}
+	w.Flush()
+
+	if sb, ok := backend.(audio.SessionBackend); ok {
+		printApps(sb)
+	}
+	return printConfigSnippets(listings)
+}
+
+// printApps lists the apps with audio streams by the names app selectors
+// take.
+func printApps(sb audio.SessionBackend) {
+	sessions, err := sb.Sessions()
+	if err != nil {
+		audioLog.Warn("error listing app sessions", "err", err)
+		return
+	}
+	if len(sessions) == 0 {
+		return
+	}
+
+	var apps []string
+	streams := make(map[string]int)
+	playing := make(map[string]bool)
+	for _, s := range sessions {
+		if streams[s.App] == 0 {
+			apps = append(apps, s.App)
+		}
+		streams[s.App]++
+		playing[s.App] = playing[s.App] || s.Active
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tSTREAMS\tPLAYING")
+	for _, app := range apps {
+		fmt.Fprintf(w, "%s\t%d\t%t\n", app, streams[app], playing[app])
+	}
+	w.Flush()
+}
+
+// printConfigSnippets prints a combos entry for every active device. Devices
+// sharing a friendly name are bound by ID since a name would be ambiguous.
+func printConfigSnippets(listings []deviceListing) error {
+	names := make(map[string]int)
+	for _, l := range listings {
+		if l.State == audio.StateActive {
+			names[strings.ToLower(l.Name)]++
+		}
+	}
+
+	fmt.Println("\n# config.yaml snippets, paste below \"combos:\" and adjust the combo numbers")
+	combo := uint8(0)
+	for _, l := range listings {
+		if l.State != audio.StateActive {
+			continue
+		}
+		c := ComboConfig{Combo: combo}
+		if l.Flow == audio.FlowCapture {
+			c.Flow = l.Flow.String()
+		}
+		if names[strings.ToLower(l.Name)] == 1 {
+			c.DeviceName = l.Name
+		} else {
+			c.DeviceID = l.ID
+		}
+		out, err := yaml.Marshal([]ComboConfig{c})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  # %s (%s)\n", l.Name, l.Flow)
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			fmt.Println("  " + line)
+		}
+		combo++
+	}
+	return nil
+}
+
+func socketPath() string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if config.ControlSocket != "" {
+		return config.ControlSocket
+	}
+	return ipc.DefaultSocketPath()
+}
+
+type controlFlags struct {
+	*flag.FlagSet
+	direct bool
+	json   bool
+}
+
+func newControlFlags(name, args string) *controlFlags {
+	f := &controlFlags{FlagSet: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.BoolVar(&f.direct, "direct", false, "Talk to the audio backend even if the daemon is running")
+	f.BoolVar(&f.json, "json", false, "Print results as JSON")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: %s %s [flags] %s\n", os.Args[0], name, args)
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// controller returns the running daemon if there is one, otherwise commands
+// act on the backend directly.
+func (f *controlFlags) controller() control.Controller {
+	if !f.direct {
+		if c, err := ipc.Dial(socketPath()); err == nil {
+			return c
+		}
+	}
+	return hostController{}
+}
+
+func parseCombo(s string) (uint8, error) {
+	combo, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid combo %q", s)
+	}
+	return uint8(combo), nil
+}
+
+func printStatuses(statuses []control.ComboStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMBO\tLEVEL\tMUTED\tDEVICE")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(w, "%d\t-\t-\t%s\n", s.Combo, s.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%t\t%s\n", s.Combo, s.Level, s.Muted, s.DeviceID)
+	}
+	return w.Flush()
+}
+
+func getCommand(args []string) error {
+	f := newControlFlags("get", "[combo]")
+	f.Parse(args)
+	c := f.controller()
+
+	if f.NArg() == 0 {
+		statuses, err := c.Combos()
+		if err != nil {
+			return err
+		}
+		return printStatuses(statuses, f.json)
+	}
+
+	combo, err := parseCombo(f.Arg(0))
+	if err != nil {
+		return err
+	}
+	status, err := c.Get(combo)
+	if err != nil {
+		return err
+	}
+	return printStatuses([]control.ComboStatus{status}, f.json)
+}
+
+func setCommand(args []string) error {
+	f := newControlFlags("set", "<combo> <0-100>")
+	f.Parse(args)
+	if f.NArg() != 2 {
+		f.Usage()
+		os.Exit(2)
+	}
+
+	combo, err := parseCombo(f.Arg(0))
+	if err != nil {
+		return err
+	}
+	level, err := strconv.Atoi(f.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid level %q", f.Arg(1))
+	}
+
+	status, err := f.controller().SetLevel(combo, level)
+	if err != nil {
+		return err
+	}
+	return printStatuses([]control.ComboStatus{status}, f.json)
+}
+
+func muteCommand(args []string) error {
+	f := newControlFlags("mute", "<combo> [on|off]")
+	f.Parse(args)
+	if f.NArg() < 1 || f.NArg() > 2 {
+		f.Usage()
+		os.Exit(2)
+	}
+
+	combo, err := parseCombo(f.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c := f.controller()
+	var status control.ComboStatus
+	switch f.Arg(1) {
+	case "":
+		status, err = c.ToggleMute(combo)
+	case "on":
+		status, err = c.SetMute(combo, true)
+	case "off":
+		status, err = c.SetMute(combo, false)
+	default:
+		return fmt.Errorf("invalid mute state %q, expected on or off", f.Arg(1))
+	}
+	if err != nil {
+		return err
+	}
+	return printStatuses([]control.ComboStatus{status}, f.json)
+}
+
+func profileCommand(args []string) error {
+	f := newControlFlags("profile", "[name]")
+	f.Parse(args)
+	c := f.controller()
+
+	var (
+		profiles []control.ProfileStatus
+		err      error
+	)
+	if f.NArg() == 0 {
+		profiles, err = c.Profiles()
+	} else {
+		profiles, err = c.SwitchProfile(f.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(profiles)
+	}
+	if len(profiles) == 0 {
+		fmt.Println("no profiles configured")
+		return nil
+	}
+	for _, p := range profiles {
+		marker := " "
+		if p.Active {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, p.Name)
+	}
+	return nil
+}
+
+func serviceCommand(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			return installCommand(args[1:])
+		case "uninstall":
+			return uninstallCommand(args[1:])
+		}
+		return fmt.Errorf("unknown service command %q, expected install or uninstall", args[0])
+	}
+
+	lock, err := lockInstance()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	pidFile := config.PIDFile
+	if pidFile == "" {
+		pidFile = service.DefaultPIDPath()
+	}
+	if err := service.WritePIDFile(pidFile); err != nil {
+		return err
+	}
+	defer service.RemovePIDFile(pidFile)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go service.RunWatchdog(stop, slog.Default(), &mainProgress, &serialProgress)
+
+	return runDaemon(func(state string) {
+		if _, err := service.Notify(state); err != nil {
+			slog.Warn("error notifying service manager", "state", state, "err", err)
+		}
+	})
+}
+
+// systemctl runs systemctl on the user's service manager.
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func installCommand(args []string) error {
+	f := flag.NewFlagSet("service install", flag.ExitOnError)
+	noStart := f.Bool("no-start", false, "Only write the unit, do not enable and start it")
+	watchdog := f.Int("watchdog", 30, "Restart the daemon if it hangs for this many seconds, 0 to disable")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: %s [-port port] service install [flags]\n", os.Args[0])
+		f.PrintDefaults()
+	}
+	f.Parse(args)
+
+	dir, err := service.UserUnitDir()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The daemon reads config.yaml from its working directory.
+	configPath, err := filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+	unit := service.Unit{Exe: exe, Dir: filepath.Dir(configPath), WatchdogSec: *watchdog}
+	if *portName != "" {
+		unit.Args = []string{"-port", *portName}
+	}
+
+	path, err := service.Install(dir, unit)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", path)
+	if *noStart {
+		fmt.Println("start it with: systemctl --user enable --now", service.UnitName)
+		return nil
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", service.UnitName)
+}
+
+func uninstallCommand(args []string) error {
+	f := flag.NewFlagSet("service uninstall", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: %s service uninstall\n", os.Args[0])
+		f.PrintDefaults()
+	}
+	f.Parse(args)
+
+	dir, err := service.UserUnitDir()
+	if err != nil {
+		return err
+	}
+	// The unit may never have been enabled, so only report problems.
+	if err := systemctl("disable", "--now", service.UnitName); err != nil {
+		slog.Warn("error disabling unit", "err", err)
+	}
+	path, err := service.Uninstall(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Println("removed", path)
+	return systemctl("daemon-reload")
+}
+
+func logCommand(args []string) error {
+	f := flag.NewFlagSet("log", flag.ExitOnError)
+	asJSON := f.Bool("json", false, "Print results as JSON")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: %s log [flags] [subsystem level]\n", os.Args[0])
+		f.PrintDefaults()
+	}
+	f.Parse(args)
+	if f.NArg() != 0 && f.NArg() != 2 {
+		f.Usage()
+		os.Exit(2)
+	}
+
+	// Log levels only exist in the running daemon.
+	c, err := ipc.Dial(socketPath())
+	if err != nil {
+		return err
+	}
+	var levels []control.LogLevel
+	if f.NArg() == 0 {
+		levels, err = c.LogLevels()
+	} else {
+		levels, err = c.SetLogLevel(f.Arg(0), f.Arg(1))
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(levels)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBSYSTEM\tLEVEL")
+	for _, l := range levels {
+		fmt.Fprintf(w, "%s\t%s\n", l.Subsystem, l.Level)
+	}
+	return w.Flush()
+}
+
+func monitorCommand(args []string) error {
+	f := newControlFlags("monitor", "")
+	f.Parse(args)
+
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(done)
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	show := func(u control.Update) bool {
+		if f.json {
+			enc.Encode(u)
+			return true
+		}
+		if u.Kind == control.KindProfile {
+			fmt.Printf("%s %-6s %s\n", u.Time.Format("15:04:05.000"), u.Kind, u.Profile)
+			return true
+		}
+		line := fmt.Sprintf("%s %-6s combo=%d level=%d muted=%t", u.Time.Format("15:04:05.000"), u.Kind, u.Combo, u.Level, u.Muted)
+		if u.Event != "" {
+			line += " " + u.Event
+		}
+		fmt.Println(line)
+		return true
+	}
+
+	if c, ok := f.controller().(*ipc.Client); ok {
+		return c.Monitor(done, show)
+	}
+
+	// Without a daemon there are no knob events, so poll the backend and
+	// report level and mute changes.
+	slog.Warn("daemon not running, polling audio backend for changes")
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	last := make(map[uint8]control.ComboStatus)
+	for {
+		statuses, _ := hostController{}.Combos()
+		for _, s := range statuses {
+			prev, ok := last[s.Combo]
+			last[s.Combo] = s
+			if s.Error != "" {
+				continue
+			}
+			if !ok || prev.Level != s.Level {
+				show(control.Update{Time: time.Now(), Kind: control.KindVolume, Combo: s.Combo, Level: s.Level, Muted: s.Muted})
+			}
+			if ok && prev.Muted != s.Muted {
+				show(control.Update{Time: time.Now(), Kind: control.KindMute, Combo: s.Combo, Level: s.Level, Muted: s.Muted})
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		}
+	}
 }